@@ -0,0 +1,47 @@
+package command
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/service"
+	flag2 "flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	register(&Command{
+		Name:  "bootstrap-admin",
+		Usage: "创建第一个管理员账号，已有管理员时拒绝执行",
+		Run:   runBootstrapAdmin,
+	})
+}
+
+func runBootstrapAdmin(args []string) error {
+	fs := flag2.NewFlagSet("bootstrap-admin", flag2.ExitOnError)
+	username := fs.String("username", "admin", "管理员用户名")
+	password := fs.String("password", "", "管理员密码，为空时读取环境变量 ADMIN_PASSWORD")
+	force := fs.Bool("force", false, "已有管理员时仍然创建")
+	fs.Parse(args)
+
+	if *password == "" {
+		*password = os.Getenv("ADMIN_PASSWORD")
+	}
+	if *password == "" {
+		return errors.New("missing password: use -password or ADMIN_PASSWORD")
+	}
+	count, err := database.NewUserDao().CountUsersByRole(database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if count > 0 && !*force {
+		return errors.New("admin already exists, use -force to create another")
+	}
+	userService := service.NewUserService()
+	user, err := userService.CreateUser(*username, *password, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已创建管理员 %s (id %d)\n", user.Username, user.ID)
+	return nil
+}
@@ -0,0 +1,46 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command 命令行子命令，通过 `fairytale-creator [全局参数] <name> [子命令参数]` 执行
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]*Command{}
+
+func register(c *Command) {
+	commands[c.Name] = c
+}
+
+// Run 执行子命令，args[0]为子命令名
+func Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	c, ok := commands[args[0]]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return c.Run(args[1:])
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "可用命令:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].Usage)
+	}
+}
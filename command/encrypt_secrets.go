@@ -0,0 +1,23 @@
+package command
+
+import (
+	"fairytale-creator/service"
+	"fmt"
+)
+
+func init() {
+	register(&Command{
+		Name:  "encrypt-secrets",
+		Usage: "用 -secret-keys 的第一个密钥加密或重新加密所有工作区的服务商密钥",
+		Run:   runEncryptSecrets,
+	})
+}
+
+func runEncryptSecrets(args []string) error {
+	updated, err := service.EncryptWorkspaceSecrets()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已更新工作区 %d 个\n", updated)
+	return nil
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"context"
+	"fairytale-creator/service"
+	flag2 "flag"
+	"fmt"
+	"time"
+)
+
+func init() {
+	register(&Command{
+		Name:  "gc",
+		Usage: "清理未被引用的本地文件和R2对象，默认只列出不删除",
+		Run:   runGC,
+	})
+}
+
+func runGC(args []string) error {
+	fs := flag2.NewFlagSet("gc", flag2.ExitOnError)
+	dryRun := fs.Bool("dry-run", true, "只列出孤儿文件，不删除")
+	minAge := fs.Duration("min-age", 24*time.Hour, "只清理早于该时长之前修改的文件")
+	fs.Parse(args)
+
+	report, err := service.NewAssetService().CollectGarbage(context.Background(), *minAge, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, file := range report.LocalFiles {
+		fmt.Println("local ", file)
+	}
+	for _, key := range report.Objects {
+		fmt.Println("object", key)
+	}
+	action := "待删除"
+	if !*dryRun {
+		action = "已删除"
+	}
+	fmt.Printf("%s本地文件 %d 个，R2对象 %d 个，共 %d 字节\n", action, len(report.LocalFiles), len(report.Objects), report.Bytes)
+	return nil
+}
@@ -0,0 +1,69 @@
+package command
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	flag2 "flag"
+	"fmt"
+)
+
+func init() {
+	register(&Command{
+		Name:  "migrate",
+		Usage: "数据库迁移: migrate [-target sql|d1] up|down|status [-steps n]",
+		Run:   runMigrate,
+	})
+}
+
+func runMigrate(args []string) error {
+	fs := flag2.NewFlagSet("migrate", flag2.ExitOnError)
+	target := fs.String("target", database.MigrationTargetSQL, "迁移目标: sql（当前连接的MySQL或SQLite）/ d1")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("missing migrate action: up / down / status")
+	}
+	action := fs.Arg(0)
+	sub := flag2.NewFlagSet("migrate "+action, flag2.ExitOnError)
+	steps := sub.Int("steps", 0, "up时执行的迁移数，0为全部；down时回滚的迁移数，0为1个")
+	sub.Parse(fs.Args()[1:])
+
+	migrator, err := database.NewMigrator(*target)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "up":
+		done, err := migrator.Up(*steps)
+		for _, m := range done {
+			fmt.Printf("up   %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("执行迁移 %d 个\n", len(done))
+	case "down":
+		done, err := migrator.Down(*steps)
+		for _, m := range done {
+			fmt.Printf("down %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("回滚迁移 %d 个\n", len(done))
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-24s %s\n", s.Version, s.Name, state)
+		}
+	default:
+		return fmt.Errorf("unknown migrate action: %s", action)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package command
+
+import (
+	"context"
+	"fairytale-creator/service"
+	flag2 "flag"
+	"fmt"
+)
+
+func init() {
+	register(&Command{
+		Name:  "migrate-keys",
+		Usage: "将已有R2对象迁移到当前的对象键模板并更新数据库，默认只列出迁移计划",
+		Run:   runMigrateKeys,
+	})
+}
+
+func runMigrateKeys(args []string) error {
+	fs := flag2.NewFlagSet("migrate-keys", flag2.ExitOnError)
+	dryRun := fs.Bool("dry-run", true, "只列出迁移计划，不移动对象")
+	fs.Parse(args)
+
+	moves, err := service.NewAssetService().MigrateKeys(context.Background(), *dryRun)
+	for _, m := range moves {
+		fmt.Printf("chapter %d: %s -> %s\n", m.ChapterID, m.From, m.To)
+	}
+	if err != nil {
+		return err
+	}
+	action := "待迁移"
+	if !*dryRun {
+		action = "已迁移"
+	}
+	fmt.Printf("%s对象 %d 个\n", action, len(moves))
+	return nil
+}
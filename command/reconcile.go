@@ -0,0 +1,32 @@
+package command
+
+import (
+	"fairytale-creator/service"
+	flag2 "flag"
+	"fmt"
+)
+
+func init() {
+	register(&Command{
+		Name:  "reconcile",
+		Usage: "比较MySQL和D1中的故事，报告差异，可选择重新同步或导入",
+		Run:   runReconcile,
+	})
+}
+
+func runReconcile(args []string) error {
+	fs := flag2.NewFlagSet("reconcile", flag2.ExitOnError)
+	fix := fs.Bool("fix", false, "将缺失或不一致的故事从MySQL重新同步到D1")
+	importD1 := fs.Bool("import-d1", false, "将只存在于D1的故事按原id导入MySQL")
+	fs.Parse(args)
+
+	drifts, err := service.NewSyncService().Reconcile(*fix, *importD1)
+	for _, d := range drifts {
+		fmt.Printf("story %d: %s\n", d.StoryID, d.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("差异 %d 个\n", len(drifts))
+	return nil
+}
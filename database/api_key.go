@@ -0,0 +1,100 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const APIKeyTableName = "api_key"
+
+const (
+	APIKeyScopeRead     = "read"     // 等同 reader
+	APIKeyScopeGenerate = "generate" // 等同 editor
+	APIKeyScopeAdmin    = "admin"    // 等同 admin
+)
+
+// APIKey 用户的API Key，只保存密钥的SHA-256。权限取 Scope 和用户当前角色中较低的一个
+type APIKey struct {
+	gorm.Model
+	UserID      uint       `json:"user_id" gorm:"not null;column:user_id"`
+	WorkspaceID uint       `json:"workspace_id" gorm:"not null;default:1;column:workspace_id"` // 只能访问这个工作区
+	Name        string     `json:"name" gorm:"not null;default:'';column:name"`
+	Prefix      string     `json:"prefix" gorm:"not null;column:prefix"` // 密钥开头几位，便于辨认
+	KeyHash     string     `json:"-" gorm:"not null;column:key_hash"`
+	Scope       string     `json:"scope" gorm:"not null;column:scope"`
+	ExpiresAt   *time.Time `json:"expires_at" gorm:"column:expires_at"` // 为空时不过期
+	LastUsedAt  *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
+}
+
+func (k APIKey) TableName() string {
+	return APIKeyTableName
+}
+
+type APIKeyDao struct {
+	BaseDao
+}
+
+func NewAPIKeyDao() *APIKeyDao {
+	return &APIKeyDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+func (p *APIKeyDao) AddAPIKey(k *APIKey) error {
+	q := p.GetDB().Create(k)
+	if q.Error != nil {
+		logger.Error("创建API Key报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// GetAPIKeyByHash 查询未撤销且未过期的API Key
+func (p *APIKeyDao) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	var k APIKey
+	q := p.GetDB().Where("key_hash = ? AND (expires_at IS NULL OR expires_at > ?)", hash, time.Now()).Limit(1).Find(&k)
+	if q.Error != nil {
+		logger.Error("查询API Key报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &k, nil
+}
+
+// ListAPIKeysByUser 列出用户在工作区中未撤销的API Key，包括已过期的
+func (p *APIKeyDao) ListAPIKeysByUser(workspaceID, userID uint) ([]APIKey, error) {
+	var list []APIKey
+	q := p.GetDB().Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Order("id").Find(&list)
+	if q.Error != nil {
+		logger.Error("查询API Key报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+// TouchAPIKey 更新最近使用时间
+func (p *APIKeyDao) TouchAPIKey(id uint, at time.Time) error {
+	q := p.GetDB().Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", at)
+	if q.Error != nil {
+		logger.Error("更新API Key报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// DeleteAPIKey 撤销用户在工作区中的API Key，不存在时返回 RecordNotFoundError
+func (p *APIKeyDao) DeleteAPIKey(workspaceID, userID, id uint) error {
+	q := p.GetDB().Where("id = ? AND workspace_id = ? AND user_id = ?", id, workspaceID, userID).Delete(&APIKey{})
+	if q.Error != nil {
+		logger.Error("删除API Key报错：", q.Error.Error())
+		return InterError
+	}
+	if q.RowsAffected == 0 {
+		return RecordNotFoundError
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+
+	"gorm.io/gorm"
+)
+
+const AssetTableName = "asset"
+
+const (
+	AssetKindImage      = "image"
+	AssetKindImageThumb = "image_thumb"
+	AssetKindImageMid   = "image_medium"
+	AssetKindImageWebP  = "image_webp"
+	AssetKindVoice      = "voice"
+	AssetKindVoiceOpus  = "voice_opus"
+	AssetKindVoiceAAC   = "voice_aac"
+)
+
+// Asset 记录故事引用的每个文件在本地和R2中的位置，删除故事时据此级联清理，
+// 垃圾回收时未被任何有效记录引用的文件视为孤儿
+type Asset struct {
+	gorm.Model
+	StoryID   uint   `json:"story_id" gorm:"not null;column:story_id;index"`
+	ChapterID uint   `json:"chapter_id" gorm:"not null;column:chapter_id;index"`
+	Kind      string `json:"kind" gorm:"not null;column:kind"`
+	LocalPath string `json:"local_path" gorm:"not null;column:local_path"`
+	ObjectKey string `json:"object_key" gorm:"not null;column:object_key;index"`
+	Provider  string `json:"provider" gorm:"not null;default:'';column:provider"` // 生成该文件的服务商，主服务商熔断时为备用服务商
+}
+
+func (a Asset) TableName() string {
+	return AssetTableName
+}
+
+type AssetDao struct {
+	BaseDao
+}
+
+func NewAssetDao() *AssetDao {
+	return &AssetDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+func (p *AssetDao) AddAssets(assets []Asset) error {
+	if len(assets) == 0 {
+		return nil
+	}
+	q := p.GetDB().Create(&assets)
+	if q.Error != nil {
+		logger.Error("创建资源记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+func (p *AssetDao) ListAssetsByStory(storyID uint) ([]Asset, error) {
+	var assets []Asset
+	q := p.GetDB().Where("story_id = ?", storyID).Find(&assets)
+	if q.Error != nil {
+		logger.Error("查询资源记录报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return assets, nil
+}
+
+// GetAssetByObjectKey 按对象键查询未删除的资源记录
+func (p *AssetDao) GetAssetByObjectKey(key string) (*Asset, error) {
+	var asset Asset
+	q := p.GetDB().Where("object_key = ?", key).Limit(1).Find(&asset)
+	if q.Error != nil {
+		logger.Error("查询资源记录报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &asset, nil
+}
+
+// UpdateObjectKey 对象迁移到新键后更新资源记录
+func (p *AssetDao) UpdateObjectKey(oldKey, newKey string) error {
+	q := p.GetDB().Model(&Asset{}).Where("object_key = ?", oldKey).Update("object_key", newKey)
+	if q.Error != nil {
+		logger.Error("更新资源记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// DeleteAssetsByStory 软删除故事的所有资源记录
+func (p *AssetDao) DeleteAssetsByStory(storyID uint) error {
+	q := p.GetDB().Where("story_id = ?", storyID).Delete(&Asset{})
+	if q.Error != nil {
+		logger.Error("删除资源记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// ListLiveAssets 查询所有未删除的资源记录
+func (p *AssetDao) ListLiveAssets() ([]Asset, error) {
+	var assets []Asset
+	q := p.GetDB().Find(&assets)
+	if q.Error != nil {
+		logger.Error("查询资源记录报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return assets, nil
+}
+
+// LocalPathInUse 是否还有未删除的资源记录引用该本地文件。文件按内容哈希命名，不同故事可能共用同一个文件
+func (p *AssetDao) LocalPathInUse(localPath string) (bool, error) {
+	return p.inUse("local_path", localPath)
+}
+
+// ObjectKeyInUse 是否还有未删除的资源记录引用该对象
+func (p *AssetDao) ObjectKeyInUse(key string) (bool, error) {
+	return p.inUse("object_key", key)
+}
+
+func (p *AssetDao) inUse(column, value string) (bool, error) {
+	var count int64
+	q := p.GetDB().Model(&Asset{}).Where(column+" = ?", value).Count(&count)
+	if q.Error != nil {
+		logger.Error("查询资源记录报错：", q.Error.Error())
+		return false, InterError
+	}
+	return count > 0, nil
+}
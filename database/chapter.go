@@ -1,24 +1,33 @@
 package database
 
 import (
-	"fairytale-creator/flag"
+	"errors"
 	"fairytale-creator/logger"
 	"fairytale-creator/modelapi"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const ChapterTableName = "chapter"
 
 type Chapter struct {
 	gorm.Model
-	StoryID     uint   `json:"story_id" gorm:"not null;column:story_id;index"`
-	Title       string `json:"title" gorm:"not null;column:title"`
-	Content     string `json:"content" gorm:"not null;column:content"`
-	ImagePrompt string `json:"image_prompt" gorm:"not null;column:image_prompt"`
-	ImagePath   string `json:"image_path" gorm:"not null;column:image_path"`
-	VoicePath   string `json:"voice_path" gorm:"not null;column:voice_path"`
+	StoryID       uint   `json:"story_id" gorm:"not null;column:story_id;index"`
+	ChapterNumber int    `json:"chapter_number" gorm:"not null;default:0;column:chapter_number"` // 故事内从1开始的序号，已删除章节为 -id
+	Title         string `json:"title" gorm:"not null;column:title"`
+	Content       string `json:"content" gorm:"not null;column:content"`
+	ImagePrompt   string `json:"image_prompt" gorm:"not null;column:image_prompt"`
+	ImagePath     string `json:"image_path" gorm:"not null;column:image_path"`
+	ImageHash     string `json:"image_hash" gorm:"not null;default:'';column:image_hash"`
+	ImageMime     string `json:"image_mime" gorm:"not null;default:'';column:image_mime"`
+	ImageWidth    int    `json:"image_width" gorm:"not null;default:0;column:image_width"`
+	ImageHeight   int    `json:"image_height" gorm:"not null;default:0;column:image_height"`
+	VoicePath     string `json:"voice_path" gorm:"not null;column:voice_path"`
+	VoiceOpusPath string `json:"voice_opus_path" gorm:"not null;default:'';column:voice_opus_path"`
+	VoiceAACPath  string `json:"voice_aac_path" gorm:"not null;default:'';column:voice_aac_path"`
+	DurationMs    int64  `json:"duration_ms" gorm:"not null;default:0;column:duration_ms"`
 }
 
 func (c Chapter) TableName() string {
@@ -35,9 +44,10 @@ func NewChapterDao() *ChapterDao {
 	}
 }
 
+const chapterInsertSQL = "INSERT INTO chapter (story_id, chapter_number, title, content, image_prompt, image_path, image_hash, image_mime, image_width, image_height, voice_path, voice_opus_path, voice_aac_path, duration_ms, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
 func (p *ChapterDao) AddChapter(c *Chapter) error {
-	q := p.GetDB()
-	q.Create(c)
+	q := p.GetDB().Create(c)
 	if q.Error != nil {
 		logger.Error("创建章节报错：", q.Error.Error())
 		return InterError
@@ -45,13 +55,247 @@ func (p *ChapterDao) AddChapter(c *Chapter) error {
 	return nil
 }
 
-func (p *ChapterDao) AddChapterToD1(c *Chapter) (*modelapi.D1QueryResponse, error) {
-	client := modelapi.NewD1Client(flag.CfAccountID, flag.D1DatabaseID, flag.D1APIKey)
-	response, err := client.ExecuteQuery("INSERT INTO chapter (story_id, title, content, image_prompt, image_path, voice_path, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		[]interface{}{c.StoryID, c.Title, c.Content, c.ImagePrompt, c.ImagePath, c.VoicePath, time.Now().Unix(), time.Now().Unix(), nil})
+// AddChapterToD1 插入章节，成功后回填c.ID
+func (p *ChapterDao) AddChapterToD1(c *Chapter) error {
+	client := newD1Client()
+	response, err := client.ExecuteQuery(chapterInsertSQL,
+		[]interface{}{c.StoryID, c.ChapterNumber, c.Title, c.Content, c.ImagePrompt, c.ImagePath, c.ImageHash, c.ImageMime, c.ImageWidth, c.ImageHeight, c.VoicePath, c.VoiceOpusPath, c.VoiceAACPath, c.DurationMs, time.Now().Unix(), time.Now().Unix(), nil})
 	if err != nil {
 		logger.Error("添加章节到D1报错：", err.Error())
+		return err
+	}
+	c.ID = uint(response.Result[0].Meta.LastRowID)
+	return nil
+}
+
+// ListChapters 按章节序号查询故事的未删除章节
+func (p *ChapterDao) ListChapters(storyID uint) ([]Chapter, error) {
+	var chapters []Chapter
+	q := p.GetDB().Where("story_id = ?", storyID).Order("chapter_number").Find(&chapters)
+	if q.Error != nil {
+		logger.Error("查询章节报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return chapters, nil
+}
+
+func (p *ChapterDao) ListChaptersFromD1(storyID uint) ([]Chapter, error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT id, story_id, chapter_number, title, content, image_prompt, image_path, image_hash, image_mime, image_width, image_height, voice_path, voice_opus_path, voice_aac_path, duration_ms, created_at, updated_at FROM chapter WHERE story_id = ? AND deleted_at IS NULL ORDER BY chapter_number",
+		[]interface{}{storyID})
+	if err != nil {
+		logger.Error("从D1查询章节报错：", err.Error())
 		return nil, err
 	}
-	return response, nil
+	chapters, err := modelapi.ScanD1Rows[Chapter](response.Rows())
+	if err != nil {
+		logger.Error("解析D1章节报错：", err.Error())
+		return nil, InterError
+	}
+	return chapters, nil
+}
+
+// ListChaptersUnscoped 按id顺序查询故事的所有章节，包括已软删除的
+func (p *ChapterDao) ListChaptersUnscoped(storyID uint) ([]Chapter, error) {
+	return p.ListChaptersUnscopedByStories([]uint{storyID})
+}
+
+// ListChaptersUnscopedByStories 按id顺序查询多个故事的所有章节，包括已软删除的
+func (p *ChapterDao) ListChaptersUnscopedByStories(storyIDs []uint) ([]Chapter, error) {
+	var chapters []Chapter
+	if len(storyIDs) == 0 {
+		return chapters, nil
+	}
+	q := p.GetDB().Unscoped().Where("story_id IN ?", storyIDs).Order("id").Find(&chapters)
+	if q.Error != nil {
+		logger.Error("查询章节报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return chapters, nil
+}
+
+// ListChaptersFromD1ByStoryRange 分页读取D1中 story_id 在 (afterStoryID, upToStoryID] 之间的章节，包括已软删除的。
+// upToStoryID 为0时不设上限
+func (p *ChapterDao) ListChaptersFromD1ByStoryRange(afterStoryID, upToStoryID uint) ([]Chapter, error) {
+	client := newD1Client()
+	var chapters []Chapter
+	var lastID uint
+	for {
+		sql := "SELECT id, story_id, chapter_number, title, content, image_prompt, image_path, image_hash, image_mime, image_width, image_height, voice_path, voice_opus_path, voice_aac_path, duration_ms, created_at, updated_at, deleted_at FROM chapter WHERE story_id > ? AND id > ?"
+		params := []interface{}{afterStoryID, lastID}
+		if upToStoryID > 0 {
+			sql += " AND story_id <= ?"
+			params = append(params, upToStoryID)
+		}
+		response, err := client.ExecuteQuery(sql+" ORDER BY id LIMIT 500", params)
+		if err != nil {
+			logger.Error("从D1查询章节报错：", err.Error())
+			return nil, err
+		}
+		page, err := modelapi.ScanD1Rows[Chapter](response.Rows())
+		if err != nil {
+			logger.Error("解析D1章节报错：", err.Error())
+			return nil, InterError
+		}
+		if len(page) == 0 {
+			return chapters, nil
+		}
+		chapters = append(chapters, page...)
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// ListAllChapterPathsFromD1 分页读取所有未删除章节的文件路径，用于判断R2中的对象是否仍被引用
+func (p *ChapterDao) ListAllChapterPathsFromD1() ([]Chapter, error) {
+	client := newD1Client()
+	var chapters []Chapter
+	var lastID uint
+	for {
+		response, err := client.ExecuteQuery("SELECT id, story_id, image_path, image_hash, voice_path, voice_opus_path, voice_aac_path FROM chapter WHERE deleted_at IS NULL AND id > ? ORDER BY id LIMIT 500",
+			[]interface{}{lastID})
+		if err != nil {
+			logger.Error("从D1查询章节报错：", err.Error())
+			return nil, err
+		}
+		page, err := modelapi.ScanD1Rows[Chapter](response.Rows())
+		if err != nil {
+			logger.Error("解析D1章节报错：", err.Error())
+			return nil, InterError
+		}
+		if len(page) == 0 {
+			return chapters, nil
+		}
+		chapters = append(chapters, page...)
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// UpdateChapterPathsInD1 更新章节的图片和语音对象键
+func (p *ChapterDao) UpdateChapterPathsInD1(c *Chapter) error {
+	client := newD1Client()
+	_, err := client.ExecuteQuery("UPDATE chapter SET image_path = ?, voice_path = ?, voice_opus_path = ?, voice_aac_path = ?, updated_at = ? WHERE id = ?",
+		[]interface{}{c.ImagePath, c.VoicePath, c.VoiceOpusPath, c.VoiceAACPath, time.Now().Unix(), c.ID})
+	if err != nil {
+		logger.Error("更新D1章节报错：", err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetStoryIDByPathFromD1 查询引用了指定对象键的未删除章节所属的故事
+func (p *ChapterDao) GetStoryIDByPathFromD1(key string) (uint, error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT story_id FROM chapter WHERE deleted_at IS NULL AND (image_path = ? OR voice_path = ? OR voice_opus_path = ? OR voice_aac_path = ?) LIMIT 1",
+		[]interface{}{key, key, key, key})
+	if err != nil {
+		logger.Error("从D1查询章节报错：", err.Error())
+		return 0, err
+	}
+	chapters, err := modelapi.ScanD1Rows[Chapter](response.Rows())
+	if err != nil {
+		logger.Error("解析D1章节报错：", err.Error())
+		return 0, InterError
+	}
+	if len(chapters) == 0 {
+		return 0, RecordNotFoundError
+	}
+	return chapters[0].StoryID, nil
+}
+
+// ReorderChapters 按ids的顺序重新编号故事的章节，ids必须恰好是故事的全部未删除章节
+func (p *ChapterDao) ReorderChapters(workspaceID, storyID uint, ids []uint) error {
+	return p.renumberInTx(workspaceID, storyID, func(tx *gorm.DB, current []uint) ([]uint, error) {
+		if len(ids) != len(current) {
+			return nil, RequestError
+		}
+		exists := make(map[uint]bool, len(current))
+		for _, id := range current {
+			exists[id] = true
+		}
+		for _, id := range ids {
+			if !exists[id] {
+				return nil, RequestError
+			}
+			delete(exists, id)
+		}
+		return ids, nil
+	})
+}
+
+// InsertChapter 在position（从1开始）处插入章节，之后的章节顺延；position超出范围时追加到末尾
+func (p *ChapterDao) InsertChapter(workspaceID uint, c *Chapter, position int) error {
+	return p.renumberInTx(workspaceID, c.StoryID, func(tx *gorm.DB, current []uint) ([]uint, error) {
+		if position < 1 || position > len(current)+1 {
+			position = len(current) + 1
+		}
+		// 先以0占位插入，随后统一编号
+		c.ChapterNumber = 0
+		if err := tx.Create(c).Error; err != nil {
+			return nil, err
+		}
+		ids := make([]uint, 0, len(current)+1)
+		ids = append(ids, current[:position-1]...)
+		ids = append(ids, c.ID)
+		return append(ids, current[position-1:]...), nil
+	})
+}
+
+// DeleteChapter 软删除章节，其余章节重新连续编号
+func (p *ChapterDao) DeleteChapter(workspaceID, storyID, chapterID uint) error {
+	return p.renumberInTx(workspaceID, storyID, func(tx *gorm.DB, current []uint) ([]uint, error) {
+		ids := make([]uint, 0, len(current))
+		for _, id := range current {
+			if id != chapterID {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == len(current) {
+			return nil, RecordNotFoundError
+		}
+		if err := tx.Model(&Chapter{}).Where("id = ?", chapterID).Update("chapter_number", gorm.Expr("-id")).Error; err != nil {
+			return nil, err
+		}
+		if err := tx.Delete(&Chapter{}, chapterID).Error; err != nil {
+			return nil, err
+		}
+		return ids, nil
+	})
+}
+
+// renumberInTx 在事务中锁定工作区中的故事及其未删除章节，由change修改数据并返回新的章节顺序，然后重新编号，
+// 最后记录待同步到D1的变更。编号分两步：先全部置为 -id，再写入 1..n，避免与唯一索引冲突
+func (p *ChapterDao) renumberInTx(workspaceID, storyID uint, change func(tx *gorm.DB, current []uint) ([]uint, error)) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		var story Story
+		if q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND workspace_id = ?", storyID, workspaceID).Limit(1).Find(&story); q.Error != nil {
+			return q.Error
+		} else if q.RowsAffected == 0 {
+			return RecordNotFoundError
+		}
+		var current []uint
+		if err := tx.Model(&Chapter{}).Where("story_id = ?", storyID).Order("chapter_number").Pluck("id", &current).Error; err != nil {
+			return err
+		}
+		ids, err := change(tx, current)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&Chapter{}).Where("story_id = ?", storyID).Update("chapter_number", gorm.Expr("-id")).Error; err != nil {
+			return err
+		}
+		for i, id := range ids {
+			if err := tx.Model(&Chapter{}).Where("id = ?", id).Update("chapter_number", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&Outbox{Entity: OutboxEntityStory, EntityID: storyID, NextAttemptAt: time.Now()}).Error
+	})
+	if errors.Is(err, RecordNotFoundError) || errors.Is(err, RequestError) {
+		return err
+	}
+	if err != nil {
+		logger.Error("章节重新编号报错：", err.Error())
+		return InterError
+	}
+	return nil
 }
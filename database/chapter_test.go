@@ -0,0 +1,109 @@
+package database
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRenumberChapters(t *testing.T) {
+	tests := []struct {
+		name string
+		// change 在章节 A、B、C（id 1、2、3）上执行的修改
+		change    func(dao *ChapterDao) error
+		wantErr   error
+		wantOrder []string
+	}{
+		{
+			name:      "调整顺序",
+			change:    func(dao *ChapterDao) error { return dao.ReorderChapters(1, 1, []uint{3, 1, 2}) },
+			wantOrder: []string{"C", "A", "B"},
+		},
+		{
+			name:      "顺序缺少章节",
+			change:    func(dao *ChapterDao) error { return dao.ReorderChapters(1, 1, []uint{3, 1}) },
+			wantErr:   RequestError,
+			wantOrder: []string{"A", "B", "C"},
+		},
+		{
+			name:      "顺序包含其他故事的章节",
+			change:    func(dao *ChapterDao) error { return dao.ReorderChapters(1, 1, []uint{3, 1, 4}) },
+			wantErr:   RequestError,
+			wantOrder: []string{"A", "B", "C"},
+		},
+		{
+			name: "在中间插入",
+			change: func(dao *ChapterDao) error {
+				return dao.InsertChapter(1, &Chapter{StoryID: 1, Title: "D"}, 2)
+			},
+			wantOrder: []string{"A", "D", "B", "C"},
+		},
+		{
+			name: "位置超出范围时追加",
+			change: func(dao *ChapterDao) error {
+				return dao.InsertChapter(1, &Chapter{StoryID: 1, Title: "D"}, 9)
+			},
+			wantOrder: []string{"A", "B", "C", "D"},
+		},
+		{
+			name:      "删除后重新编号",
+			change:    func(dao *ChapterDao) error { return dao.DeleteChapter(1, 1, 2) },
+			wantOrder: []string{"A", "C"},
+		},
+		{
+			name:      "删除不存在的章节",
+			change:    func(dao *ChapterDao) error { return dao.DeleteChapter(1, 1, 4) },
+			wantErr:   RecordNotFoundError,
+			wantOrder: []string{"A", "B", "C"},
+		},
+		{
+			name:      "其他工作区的故事",
+			change:    func(dao *ChapterDao) error { return dao.ReorderChapters(2, 1, []uint{3, 1, 2}) },
+			wantErr:   RecordNotFoundError,
+			wantOrder: []string{"A", "B", "C"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			openTestDB(t)
+			for _, s := range []*Story{{Title: "一", WorkspaceID: 1}, {Title: "二", WorkspaceID: 1}} {
+				if err := NewStoryDao().AddStory(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, c := range []*Chapter{{StoryID: 1, Title: "A"}, {StoryID: 1, Title: "B"}, {StoryID: 1, Title: "C"}, {StoryID: 2, Title: "X"}} {
+				c.ChapterNumber = i + 1
+				if err := NewChapterDao().AddChapter(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tt.change(NewChapterDao())
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			chapters, err := NewChapterDao().ListChapters(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var order []string
+			for i, c := range chapters {
+				if c.ChapterNumber != i+1 {
+					t.Errorf("chapter %s number = %d, want %d", c.Title, c.ChapterNumber, i+1)
+				}
+				order = append(order, c.Title)
+			}
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("order = %v, want %v", order, tt.wantOrder)
+			}
+			// 修改成功时在同一事务中记录待同步的变更，失败时整体回滚
+			var pending int64
+			if err := GetDB().Model(&Outbox{}).Where("entity_id = ?", 1).Count(&pending).Error; err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]int64{true: 1, false: 0}[tt.wantErr == nil]; pending != want {
+				t.Errorf("outbox entries = %d, want %d", pending, want)
+			}
+		})
+	}
+}
@@ -4,26 +4,26 @@ import (
 	"errors"
 	"fairytale-creator/flag"
 	"fairytale-creator/logger"
+	"fairytale-creator/modelapi"
 	"fmt"
 	"os"
 	"time"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	logger2 "gorm.io/gorm/logger"
 )
 
 var (
-	gormDB          *gorm.DB
-	InterError      = errors.New("服务器报错，请稍候再试")
-	RequestError    = errors.New("请求参数有误")
-	FileFormatError = errors.New("文件格式错误")
+	gormDB              *gorm.DB
+	InterError          = errors.New("服务器报错，请稍候再试")
+	RequestError        = errors.New("请求参数有误")
+	FileFormatError     = errors.New("文件格式错误")
+	RecordNotFoundError = errors.New("记录不存在")
 )
 
 func Init() error {
-	if gormDB != nil {
-		return errors.New("connection already exists")
-	}
 	host := flag.MysqlHost
 	port := flag.MysqlPort
 	database := flag.MysqlDatabase
@@ -42,9 +42,21 @@ func Init() error {
 		database,
 		charset,
 	)
+	dialector := mysql.Open(address)
+	if flag.DBDriver == "sqlite" {
+		dialector = sqlite.Open(flag.SqlitePath)
+	}
+	return Open(dialector)
+}
+
+// Open 使用给定的驱动连接数据库，Init 按参数选择MySQL或SQLite后调用
+func Open(dialector gorm.Dialector) error {
+	if gormDB != nil {
+		return errors.New("connection already exists")
+	}
 	var err error
 	for i := 0; i < 40; i++ {
-		gormDB, err = gorm.Open(mysql.Open(address), &gorm.Config{
+		gormDB, err = gorm.Open(dialector, &gorm.Config{
 			Logger: logger2.Default.LogMode(logger2.Info),
 		})
 		if err != nil {
@@ -57,7 +69,7 @@ func Init() error {
 		logger.Error("连接数据库失败：", err.Error())
 		return err
 	}
-	gormDB.AutoMigrate(&Story{}, &Chapter{})
+	// 表结构由 migrations 目录中的迁移文件维护，见 MigrateUp
 	return nil
 }
 
@@ -77,3 +89,26 @@ func (p *BaseDao) GetDB() *gorm.DB {
 func (p *BaseDao) Transaction(db *gorm.DB) {
 	p.Engine = db
 }
+
+// d1DeletedAt D1中以Unix秒数保存删除时间，未删除为NULL
+func d1DeletedAt(deletedAt gorm.DeletedAt) interface{} {
+	if !deletedAt.Valid {
+		return nil
+	}
+	return deletedAt.Time.Unix()
+}
+
+// d1Time D1中以Unix秒数保存时间，零值（D1中早期数据没有时间）为NULL
+func d1Time(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// newD1Client 按配置创建D1客户端
+func newD1Client() *modelapi.D1Client {
+	client := modelapi.NewD1Client(flag.CfAccountID, flag.D1DatabaseID, flag.D1APIKey)
+	client.BaseURL = flag.D1BaseURL
+	return client
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"fairytale-creator/flag"
+	"fairytale-creator/modelapi/d1test"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	logger2 "gorm.io/gorm/logger"
+)
+
+// newTestDB 在临时目录中创建空的SQLite数据库并替换全局连接，测试结束后恢复
+func newTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger2.Default.LogMode(logger2.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := gormDB
+	gormDB = db
+	t.Cleanup(func() {
+		gormDB = prev
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// openTestDB 创建执行了全部迁移的测试数据库
+func openTestDB(t *testing.T) {
+	t.Helper()
+	newTestDB(t)
+	if err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestD1 启动模拟的D1服务并让D1客户端指向它，测试结束后恢复
+func newTestD1(t *testing.T) *d1test.Server {
+	t.Helper()
+	server := d1test.NewServer(t)
+	prev := flag.D1BaseURL
+	flag.D1BaseURL = server.URL
+	t.Cleanup(func() { flag.D1BaseURL = prev })
+	return server
+}
+
+// openTestD1 启动执行了全部D1迁移的模拟D1服务
+func openTestD1(t *testing.T) *d1test.Server {
+	t.Helper()
+	server := newTestD1(t)
+	migrator, err := NewMigrator(MigrationTargetD1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
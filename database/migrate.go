@@ -0,0 +1,355 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fairytale-creator/logger"
+	"fairytale-creator/migrations"
+	"fairytale-creator/modelapi"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const SchemaMigrationsTableName = "schema_migrations"
+
+const (
+	MigrationTargetSQL = "sql" // 当前连接的MySQL或SQLite
+	MigrationTargetD1  = "d1"
+)
+
+// Migration 一个版本的迁移，Up/Down 为SQL文本，可包含多条以分号结尾的语句
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 迁移的执行状态
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// LoadMigrations 读取内嵌的某种数据库的迁移文件，按版本号升序返回
+func LoadMigrations(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", dialect, err)
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		var up bool
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			up = true
+		case strings.HasSuffix(name, ".down.sql"):
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".up.sql"), ".down.sql")
+		prefix, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		content, err := fs.ReadFile(migrations.FS, path.Join(dialect, name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if up {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// splitStatements 按行尾分号拆分语句并去掉注释行，迁移文件中的字符串不能包含分号
+func splitStatements(sql string) []string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var statements []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+// migrationTarget 执行迁移的数据库
+type migrationTarget interface {
+	dialect() string
+	ensureTable() error
+	applied() (map[int]time.Time, error)
+	// apply 执行迁移语句并记录或删除版本，尽量在一个事务内完成
+	apply(m Migration, up bool) error
+}
+
+// Migrator 对一个数据库执行版本化迁移
+type Migrator struct {
+	target     migrationTarget
+	migrations []Migration
+}
+
+// NewMigrator 创建迁移器，target 为 MigrationTargetSQL 或 MigrationTargetD1
+func NewMigrator(target string) (*Migrator, error) {
+	var t migrationTarget
+	switch target {
+	case MigrationTargetSQL:
+		if GetDB() == nil {
+			return nil, errors.New("database not initialized")
+		}
+		t = &gormMigrationTarget{db: GetDB()}
+	case MigrationTargetD1:
+		t = &d1MigrationTarget{client: newD1Client()}
+	default:
+		return nil, fmt.Errorf("unknown migration target: %s", target)
+	}
+	list, err := LoadMigrations(t.dialect())
+	if err != nil {
+		return nil, err
+	}
+	if err := t.ensureTable(); err != nil {
+		return nil, err
+	}
+	return &Migrator{target: t, migrations: list}, nil
+}
+
+// Status 返回所有迁移及其执行状态
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.target.applied()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		at, ok := applied[migration.Version]
+		status = append(status, MigrationStatus{Migration: migration, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
+
+// Up 按版本升序执行未执行的迁移，steps<=0 时执行全部
+func (m *Migrator) Up(steps int) ([]Migration, error) {
+	applied, err := m.target.applied()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if steps > 0 && len(done) >= steps {
+			break
+		}
+		if err := m.target.apply(migration, true); err != nil {
+			return done, fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		logger.Log("migrated up", strconv.Itoa(migration.Version), migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down 按版本降序回滚已执行的迁移，steps<=0 时回滚一个
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	applied, err := m.target.applied()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Down == "" {
+			return done, fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		if err := m.target.apply(migration, false); err != nil {
+			return done, fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		logger.Log("migrated down", strconv.Itoa(migration.Version), migration.Name)
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+var ErrPendingMigrations = errors.New("存在未执行的数据库迁移，请先执行 migrate up")
+
+// RequireMigrated 检查当前连接数据库的迁移都已执行，未开启启动时迁移时用于拒绝在旧表结构上运行
+func RequireMigrated() error {
+	migrator, err := NewMigrator(MigrationTargetSQL)
+	if err != nil {
+		logger.Error("初始化迁移报错：", err.Error())
+		return err
+	}
+	status, err := migrator.Status()
+	if err != nil {
+		logger.Error("查询迁移状态报错：", err.Error())
+		return err
+	}
+	for _, s := range status {
+		if !s.Applied {
+			err := fmt.Errorf("%w: %d_%s", ErrPendingMigrations, s.Version, s.Name)
+			logger.Error(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateUp 执行当前连接数据库的所有未执行迁移
+func MigrateUp() error {
+	migrator, err := NewMigrator(MigrationTargetSQL)
+	if err != nil {
+		logger.Error("初始化迁移报错：", err.Error())
+		return err
+	}
+	if _, err := migrator.Up(0); err != nil {
+		logger.Error("数据库迁移报错：", err.Error())
+		return err
+	}
+	return nil
+}
+
+type schemaMigration struct {
+	Version   int    `gorm:"column:version"`
+	Name      string `gorm:"column:name"`
+	AppliedAt int64  `gorm:"column:applied_at"`
+}
+
+// gormMigrationTarget 通过GORM连接迁移MySQL或SQLite。MySQL的DDL会隐式提交，
+// 迁移中途失败时需要根据报错手动处理
+type gormMigrationTarget struct {
+	db *gorm.DB
+}
+
+func (t *gormMigrationTarget) dialect() string {
+	return t.db.Dialector.Name()
+}
+
+func (t *gormMigrationTarget) ensureTable() error {
+	return t.db.Exec("CREATE TABLE IF NOT EXISTS " + SchemaMigrationsTableName +
+		" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at BIGINT NOT NULL)").Error
+}
+
+func (t *gormMigrationTarget) applied() (map[int]time.Time, error) {
+	var rows []schemaMigration
+	if err := t.db.Table(SchemaMigrationsTableName).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = time.Unix(row.AppliedAt, 0)
+	}
+	return applied, nil
+}
+
+func (t *gormMigrationTarget) apply(m Migration, up bool) error {
+	sql := m.Down
+	if up {
+		sql = m.Up
+	}
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range splitStatements(sql) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if up {
+			return tx.Exec("INSERT INTO "+SchemaMigrationsTableName+" (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().Unix()).Error
+		}
+		return tx.Exec("DELETE FROM "+SchemaMigrationsTableName+" WHERE version = ?", m.Version).Error
+	})
+}
+
+// d1MigrationTarget 迁移Cloudflare D1，每个迁移的语句和版本记录在一个批次（事务）中执行
+type d1MigrationTarget struct {
+	client *modelapi.D1Client
+}
+
+func (t *d1MigrationTarget) dialect() string {
+	return MigrationTargetD1
+}
+
+func (t *d1MigrationTarget) ensureTable() error {
+	_, err := t.client.ExecuteQuery("CREATE TABLE IF NOT EXISTS "+SchemaMigrationsTableName+
+		" (version INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, applied_at INTEGER NOT NULL)", nil)
+	return err
+}
+
+func (t *d1MigrationTarget) applied() (map[int]time.Time, error) {
+	response, err := t.client.ExecuteQuery("SELECT version, name, applied_at FROM "+SchemaMigrationsTableName, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := modelapi.ScanD1Rows[schemaMigration](response.Rows())
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = time.Unix(row.AppliedAt, 0)
+	}
+	return applied, nil
+}
+
+func (t *d1MigrationTarget) apply(m Migration, up bool) error {
+	sql := m.Down
+	if up {
+		sql = m.Up
+	}
+	var queries []modelapi.D1QueryRequest
+	for _, stmt := range splitStatements(sql) {
+		queries = append(queries, modelapi.D1QueryRequest{SQL: stmt})
+	}
+	if up {
+		queries = append(queries, modelapi.D1QueryRequest{
+			SQL:    "INSERT INTO " + SchemaMigrationsTableName + " (version, name, applied_at) VALUES (?, ?, ?)",
+			Params: []interface{}{m.Version, m.Name, time.Now().Unix()},
+		})
+	} else {
+		queries = append(queries, modelapi.D1QueryRequest{
+			SQL:    "DELETE FROM " + SchemaMigrationsTableName + " WHERE version = ?",
+			Params: []interface{}{m.Version},
+		})
+	}
+	_, err := t.client.ExecuteBatch(context.Background(), queries)
+	return err
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"空文本", "", nil},
+		{"只有注释", "-- 注释\n  -- 缩进的注释\n", nil},
+		{"单条语句", "CREATE TABLE a (id INT);", []string{"CREATE TABLE a (id INT)"}},
+		{"缺少结尾分号", "DROP TABLE a", []string{"DROP TABLE a"}},
+		{
+			name: "多条语句和注释",
+			sql:  "-- 建表\nCREATE TABLE a (\n  id INT\n);\n\n-- 索引\nCREATE INDEX idx_a ON a (id);\n",
+			want: []string{"CREATE TABLE a (\n  id INT\n)", "CREATE INDEX idx_a ON a (id)"},
+		},
+		{"连续分号", "DROP TABLE a;;DROP TABLE b;", []string{"DROP TABLE a", "DROP TABLE b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.sql); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		dialect string
+		wantErr bool
+	}{
+		{"mysql", false},
+		{"sqlite", false},
+		{"d1", false},
+		{"postgres", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			list, err := LoadMigrations(tt.dialect)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(list) == 0 {
+				t.Fatal("LoadMigrations() returned no migrations")
+			}
+			for i, m := range list {
+				if i > 0 && m.Version <= list[i-1].Version {
+					t.Errorf("migration %d not after %d", m.Version, list[i-1].Version)
+				}
+				if m.Name == "" {
+					t.Errorf("migration %d has no name", m.Version)
+				}
+				if len(splitStatements(m.Up)) == 0 {
+					t.Errorf("migration %d has no up statements", m.Version)
+				}
+				if len(splitStatements(m.Down)) == 0 {
+					t.Errorf("migration %d has no down statements", m.Version)
+				}
+			}
+		})
+	}
+}
+
+// MySQL 和本地 SQLite 的表结构需要保持一致，迁移版本应一一对应
+func TestLoadMigrationsVersionsMatch(t *testing.T) {
+	versions := func(dialect string) []int {
+		list, err := LoadMigrations(dialect)
+		if err != nil {
+			t.Fatalf("LoadMigrations(%s) error = %v", dialect, err)
+		}
+		var v []int
+		for _, m := range list {
+			v = append(v, m.Version)
+		}
+		return v
+	}
+	if mysql, sqlite := versions("mysql"), versions("sqlite"); !reflect.DeepEqual(mysql, sqlite) {
+		t.Errorf("mysql versions %v, sqlite versions %v", mysql, sqlite)
+	}
+}
+
+// 从引入迁移前的表结构升级：已有数据保留并补齐新列，之后按新表结构读写，回滚后可以再次升级
+func TestMigrateUpFromBaseline(t *testing.T) {
+	newTestDB(t)
+	migrator, err := NewMigrator(MigrationTargetSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(1); err != nil {
+		t.Fatal(err)
+	}
+	db := GetDB()
+	for _, stmt := range []string{
+		"INSERT INTO story (id, created_at, updated_at, title, author, description, music_style, status) VALUES (1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, '小兔子', '作者', '简介', '轻快', 1)",
+		"INSERT INTO chapter (id, created_at, updated_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 1, '一', '内容', '提示词', 'a.png', 'a.mp3')",
+		"INSERT INTO chapter (id, created_at, updated_at, deleted_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (2, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 1, '二', '内容', '提示词', 'b.png', 'b.mp3')",
+		"INSERT INTO chapter (id, created_at, updated_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 1, '三', '内容', '提示词', 'c.png', 'c.mp3')",
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	status, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("migration %d_%s not applied", s.Version, s.Name)
+		}
+	}
+
+	story, err := NewStoryDao().GetStory(1)
+	if err != nil || story.WorkspaceID != 1 {
+		t.Fatalf("GetStory() = %+v, %v, want story in workspace 1", story, err)
+	}
+	chapters, err := NewChapterDao().ListChaptersUnscoped(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNumbers := []int{1, -2, 2}
+	for i, c := range chapters {
+		if c.ChapterNumber != wantNumbers[i] || c.VoicePath == "" || c.ImageHash != "" || c.DurationMs != 0 {
+			t.Errorf("chapter %d = number %d voice %q hash %q duration %d, want number %d with defaults",
+				c.ID, c.ChapterNumber, c.VoicePath, c.ImageHash, c.DurationMs, wantNumbers[i])
+		}
+	}
+	added := &Chapter{StoryID: 1, ChapterNumber: 3, Title: "四", ImagePath: "d.png", ImageHash: "d", ImageMime: "image/png", ImageWidth: 640, ImageHeight: 480, VoicePath: "d.mp3", VoiceOpusPath: "d.opus", DurationMs: 1000}
+	if err := NewChapterDao().AddChapter(added); err != nil {
+		t.Fatalf("AddChapter() after upgrade error = %v", err)
+	}
+	if err := NewAssetDao().AddAssets([]Asset{{StoryID: 1, ChapterID: added.ID, Kind: AssetKindImage, LocalPath: "d.png", ObjectKey: "d.png", Provider: "jimeng"}}); err != nil {
+		t.Fatalf("AddAssets() after upgrade error = %v", err)
+	}
+
+	if _, err := migrator.Down(len(status) - 1); err != nil {
+		t.Fatalf("Down() error = %v", err)
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatalf("Up() after Down() error = %v", err)
+	}
+}
+
+// D1 从原 d1.sql 的表结构升级后，同步和读取章节使用的新列都可用
+func TestMigrateD1FromBaseline(t *testing.T) {
+	server := newTestD1(t)
+	migrator, err := NewMigrator(MigrationTargetD1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO story (id, created_at, updated_at, title, author, description, music_style, status) VALUES (1, 1700000000, 1700000000, '小兔子', '作者', '简介', '轻快', 1)",
+		"INSERT INTO chapter (id, created_at, updated_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (1, 1700000000, 1700000000, 1, '一', '内容', '提示词', 'a.png', 'a.mp3')",
+	} {
+		if _, err := server.DB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+
+	created := time.Unix(1700000000, 0)
+	story := &Story{Model: gorm.Model{ID: 1, CreatedAt: created, UpdatedAt: created}, Title: "小兔子", WorkspaceID: 1, Status: StoryStatusPublished}
+	chapters := []Chapter{{Model: gorm.Model{ID: 1, CreatedAt: created, UpdatedAt: created}, StoryID: 1, ChapterNumber: 1, Title: "一",
+		ImagePath: "a.png", ImageHash: "a", ImageMime: "image/png", VoicePath: "a.mp3", VoiceOpusPath: "a.opus", DurationMs: 1000}}
+	if err := NewStoryDao().UpsertStoryWithChaptersToD1(story, chapters); err != nil {
+		t.Fatalf("UpsertStoryWithChaptersToD1() error = %v", err)
+	}
+	got, err := NewChapterDao().ListChaptersFromD1(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ImageMime != "image/png" || got[0].VoiceOpusPath != "a.opus" || got[0].DurationMs != 1000 {
+		t.Errorf("ListChaptersFromD1() = %+v", got)
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const OutboxTableName = "outbox"
+
+const OutboxEntityStory = "story"
+
+// Outbox 待同步到D1的变更，与业务数据在同一个事务中写入。
+// 同步时总是读取实体的最新完整状态，因此重复执行是幂等的
+type Outbox struct {
+	gorm.Model
+	Entity        string     `json:"entity" gorm:"not null;column:entity"`
+	EntityID      uint       `json:"entity_id" gorm:"not null;column:entity_id"`
+	Attempts      int        `json:"attempts" gorm:"not null;default:0;column:attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"not null;column:next_attempt_at;index"`
+	LastError     string     `json:"last_error" gorm:"not null;default:'';column:last_error"`
+	DoneAt        *time.Time `json:"done_at" gorm:"column:done_at;index"`
+}
+
+func (o Outbox) TableName() string {
+	return OutboxTableName
+}
+
+type OutboxDao struct {
+	BaseDao
+}
+
+func NewOutboxDao() *OutboxDao {
+	return &OutboxDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+// Enqueue 记录一条待同步的变更
+func (p *OutboxDao) Enqueue(entity string, id uint) error {
+	q := p.GetDB().Create(&Outbox{Entity: entity, EntityID: id, NextAttemptAt: time.Now()})
+	if q.Error != nil {
+		logger.Error("创建同步记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// ListDue 查询已到重试时间且未完成的变更，按创建顺序返回
+func (p *OutboxDao) ListDue(limit int) ([]Outbox, error) {
+	var entries []Outbox
+	q := p.GetDB().Where("done_at IS NULL AND next_attempt_at <= ?", time.Now()).Order("id").Limit(limit).Find(&entries)
+	if q.Error != nil {
+		logger.Error("查询同步记录报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return entries, nil
+}
+
+// MarkDone 将同一实体所有不晚于id的未完成变更标记为完成，它们已被本次同步覆盖
+func (p *OutboxDao) MarkDone(o *Outbox) error {
+	q := p.GetDB().Model(&Outbox{}).
+		Where("entity = ? AND entity_id = ? AND id <= ? AND done_at IS NULL", o.Entity, o.EntityID, o.ID).
+		Update("done_at", time.Now())
+	if q.Error != nil {
+		logger.Error("更新同步记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// MarkFailed 记录失败原因并安排下次重试
+func (p *OutboxDao) MarkFailed(o *Outbox, next time.Time, reason string) error {
+	q := p.GetDB().Model(&Outbox{}).Where("id = ?", o.ID).Updates(map[string]interface{}{
+		"attempts":        o.Attempts + 1,
+		"next_attempt_at": next,
+		"last_error":      reason,
+	})
+	if q.Error != nil {
+		logger.Error("更新同步记录报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
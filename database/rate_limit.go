@@ -0,0 +1,71 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const RateLimitBucketTableName = "rate_limit_bucket"
+
+// RateLimitBucket 共享存储中的令牌桶，FullAt 之后桶已补满，可以删除
+type RateLimitBucket struct {
+	BucketKey string    `gorm:"primarykey;column:bucket_key"`
+	Tokens    float64   `gorm:"not null;column:tokens"`
+	UpdatedAt time.Time `gorm:"not null;autoUpdateTime:false;column:updated_at"`
+	FullAt    time.Time `gorm:"not null;column:full_at"`
+}
+
+func (b RateLimitBucket) TableName() string {
+	return RateLimitBucketTableName
+}
+
+type RateLimitDao struct {
+	BaseDao
+}
+
+func NewRateLimitDao() *RateLimitDao {
+	return &RateLimitDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+// UpdateBucket 在事务中锁定并读取令牌桶，交给 update 修改后写回。桶不存在时 found 为false
+func (p *RateLimitDao) UpdateBucket(key string, update func(b *RateLimitBucket, found bool)) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		var b RateLimitBucket
+		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("bucket_key = ?", key).Limit(1).Find(&b)
+		if q.Error != nil {
+			return q.Error
+		}
+		update(&b, q.RowsAffected > 0)
+		b.BucketKey = key
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&b).Error
+	})
+	if err != nil {
+		logger.Error("更新限流令牌桶报错：", err.Error())
+		return InterError
+	}
+	return nil
+}
+
+func (p *RateLimitDao) DeleteBucket(key string) error {
+	q := p.GetDB().Where("bucket_key = ?", key).Delete(&RateLimitBucket{})
+	if q.Error != nil {
+		logger.Error("删除限流令牌桶报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// DeleteFullBuckets 删除已补满的令牌桶，返回删除数量
+func (p *RateLimitDao) DeleteFullBuckets(now time.Time) (int64, error) {
+	q := p.GetDB().Where("full_at < ?", now).Delete(&RateLimitBucket{})
+	if q.Error != nil {
+		logger.Error("清理限流令牌桶报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return q.RowsAffected, nil
+}
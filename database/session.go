@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const UserSessionTableName = "user_session"
+
+// UserSession 登录会话，只保存会话令牌的SHA-256，撤销会话即删除记录
+type UserSession struct {
+	gorm.Model
+	TokenHash  string    `json:"-" gorm:"not null;column:token_hash"`
+	UserID     uint      `json:"user_id" gorm:"not null;default:0;column:user_id"`
+	Data       []byte    `json:"-" gorm:"column:data"` // gob编码的会话数据
+	IP         string    `json:"ip" gorm:"not null;default:'';column:ip"`
+	UserAgent  string    `json:"user_agent" gorm:"not null;default:'';column:user_agent"`
+	LastSeenAt time.Time `json:"last_seen_at" gorm:"not null;column:last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;column:expires_at"`
+}
+
+func (s UserSession) TableName() string {
+	return UserSessionTableName
+}
+
+type SessionDao struct {
+	BaseDao
+}
+
+func NewSessionDao() *SessionDao {
+	return &SessionDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+// GetSessionByTokenHash 查询未过期的会话
+func (p *SessionDao) GetSessionByTokenHash(hash string) (*UserSession, error) {
+	var s UserSession
+	q := p.GetDB().Where("token_hash = ? AND expires_at > ?", hash, time.Now()).Limit(1).Find(&s)
+	if q.Error != nil {
+		logger.Error("查询会话报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &s, nil
+}
+
+// SaveSession 创建或更新会话
+func (p *SessionDao) SaveSession(s *UserSession) error {
+	q := p.GetDB().Save(s)
+	if q.Error != nil {
+		logger.Error("保存会话报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// TouchSession 更新会话的最近访问时间
+func (p *SessionDao) TouchSession(id uint, at time.Time) error {
+	q := p.GetDB().Model(&UserSession{}).Where("id = ?", id).Update("last_seen_at", at)
+	if q.Error != nil {
+		logger.Error("更新会话报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// ListSessionsByUser 列出用户未过期的会话，最近访问的在前
+func (p *SessionDao) ListSessionsByUser(userID uint) ([]UserSession, error) {
+	var list []UserSession
+	q := p.GetDB().Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("last_seen_at DESC").Find(&list)
+	if q.Error != nil {
+		logger.Error("查询会话报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+// DeleteSessionByTokenHash 删除令牌对应的会话，不存在时不报错
+func (p *SessionDao) DeleteSessionByTokenHash(hash string) error {
+	q := p.GetDB().Unscoped().Where("token_hash = ?", hash).Delete(&UserSession{})
+	if q.Error != nil {
+		logger.Error("删除会话报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// DeleteUserSession 删除用户的某个会话，会话不属于该用户时返回 RecordNotFoundError
+func (p *SessionDao) DeleteUserSession(userID, id uint) error {
+	q := p.GetDB().Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&UserSession{})
+	if q.Error != nil {
+		logger.Error("删除会话报错：", q.Error.Error())
+		return InterError
+	}
+	if q.RowsAffected == 0 {
+		return RecordNotFoundError
+	}
+	return nil
+}
+
+// DeleteUserSessions 删除用户除exceptHash外的所有会话，exceptHash为空时全部删除
+func (p *SessionDao) DeleteUserSessions(userID uint, exceptHash string) (int64, error) {
+	q := p.GetDB().Unscoped().Where("user_id = ? AND token_hash <> ?", userID, exceptHash).Delete(&UserSession{})
+	if q.Error != nil {
+		logger.Error("删除会话报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return q.RowsAffected, nil
+}
+
+// DeleteExpiredSessions 清理已过期的会话
+func (p *SessionDao) DeleteExpiredSessions() (int64, error) {
+	q := p.GetDB().Unscoped().Where("expires_at <= ?", time.Now()).Delete(&UserSession{})
+	if q.Error != nil {
+		logger.Error("清理会话报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return q.RowsAffected, nil
+}
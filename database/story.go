@@ -1,9 +1,11 @@
 package database
 
 import (
-	"fairytale-creator/flag"
+	"context"
+	"errors"
 	"fairytale-creator/logger"
 	"fairytale-creator/modelapi"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,13 +13,20 @@ import (
 
 const StoryTableName = "story"
 
+const (
+	StoryStatusPending   = 0 // 待审阅
+	StoryStatusPublished = 1 // 已发布，读者可见
+	StoryStatusGenerated = 2 // 生成完成
+)
+
 type Story struct {
 	gorm.Model
 	Title       string `json:"title" gorm:"not null;column:title"`
 	Author      string `json:"author" gorm:"not null;column:author"`
 	Description string `json:"description" gorm:"not null;column:description"`
 	MusicStyle  string `json:"music_style" gorm:"not null;column:music_style"`
-	Status      int    `json:"status" gorm:"not null;column:status"` // 0: 待审阅, 1: 已上传, 2: 生成完成
+	Status      int    `json:"status" gorm:"not null;column:status"` // 见 StoryStatus 常量
+	WorkspaceID uint   `json:"workspace_id" gorm:"not null;default:1;column:workspace_id"`
 }
 
 func (s Story) TableName() string {
@@ -35,8 +44,19 @@ func NewStoryDao() *StoryDao {
 }
 
 func (p *StoryDao) AddStory(s *Story) error {
-	q := p.GetDB()
-	q.Create(s)
+	q := p.GetDB().Create(s)
+	if q.Error != nil {
+		logger.Error("创建故事报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// ReserveStory 以软删除状态插入故事占用一个id，上传完成后由 RestoreStory 恢复。
+// 失败时占位行保持软删除，读者不可见，id也不会被重复分配
+func (p *StoryDao) ReserveStory(s *Story) error {
+	s.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	q := p.GetDB().Create(s)
 	if q.Error != nil {
 		logger.Error("创建故事报错：", q.Error.Error())
 		return InterError
@@ -44,13 +64,390 @@ func (p *StoryDao) AddStory(s *Story) error {
 	return nil
 }
 
-func (p *StoryDao) AddStoryToD1(s *Story) (*modelapi.D1QueryResponse, error) {
-	client := modelapi.NewD1Client(flag.CfAccountID, flag.D1DatabaseID, flag.D1APIKey)
-	response, err := client.ExecuteQuery("INSERT INTO story (title, author, description, music_style, status, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		[]interface{}{s.Title, s.Author, s.Description, s.MusicStyle, s.Status, time.Now().Unix(), time.Now().Unix(), nil})
+// RestoreStory 恢复 ReserveStory 占用的故事
+func (p *StoryDao) RestoreStory(s *Story) error {
+	q := p.GetDB().Unscoped().Model(s).Update("deleted_at", nil)
+	if q.Error != nil {
+		logger.Error("恢复故事报错：", q.Error.Error())
+		return InterError
+	}
+	s.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+// ReserveStoryInD1 以软删除状态插入故事，用D1分配的自增id回填s.ID，
+// 多个实例并发调用也不会拿到相同的id。章节由 AddChaptersToReservedStoryInD1 写入
+func (p *StoryDao) ReserveStoryInD1(s *Story) error {
+	client := newD1Client()
+	now := time.Now().Unix()
+	response, err := client.ExecuteQuery("INSERT INTO story (workspace_id, title, author, description, music_style, status, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		[]interface{}{s.WorkspaceID, s.Title, s.Author, s.Description, s.MusicStyle, s.Status, now, now, now})
+	if err != nil {
+		logger.Error("添加故事到D1报错：", err.Error())
+		return err
+	}
+	s.ID = uint(response.Result[0].Meta.LastRowID)
+	return nil
+}
+
+// GetStory 查询未删除的故事
+func (p *StoryDao) GetStory(id uint) (*Story, error) {
+	var s Story
+	q := p.GetDB().Where("id = ?", id).Limit(1).Find(&s)
+	if q.Error != nil {
+		logger.Error("查询故事报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &s, nil
+}
+
+// ListStories 按创建时间倒序分页查询工作区中未删除的故事
+func (p *StoryDao) ListStories(workspaceID uint, offset, limit int) ([]Story, error) {
+	var stories []Story
+	q := p.GetDB().Where("workspace_id = ?", workspaceID).Order("id DESC").Offset(offset).Limit(limit).Find(&stories)
+	if q.Error != nil {
+		logger.Error("查询故事报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return stories, nil
+}
+
+// SoftDeleteStory 软删除故事及其所有章节，并在同一事务中记录待同步的变更
+func (p *StoryDao) SoftDeleteStory(id uint) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("story_id = ?", id).Delete(&Chapter{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Story{}, id).Error; err != nil {
+			return err
+		}
+		return tx.Create(&Outbox{Entity: OutboxEntityStory, EntityID: id, NextAttemptAt: time.Now()}).Error
+	})
+	if err != nil {
+		logger.Error("删除故事报错：", err.Error())
+		return InterError
+	}
+	return nil
+}
+
+// SetStoryStatus 更新故事状态，并在同一事务中记录待同步的变更
+func (p *StoryDao) SetStoryStatus(id uint, status int) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		q := tx.Model(&Story{}).Where("id = ?", id).Update("status", status)
+		if q.Error != nil {
+			return q.Error
+		}
+		if q.RowsAffected == 0 {
+			return RecordNotFoundError
+		}
+		return tx.Create(&Outbox{Entity: OutboxEntityStory, EntityID: id, NextAttemptAt: time.Now()}).Error
+	})
+	if errors.Is(err, RecordNotFoundError) {
+		return err
+	}
+	if err != nil {
+		logger.Error("更新故事状态报错：", err.Error())
+		return InterError
+	}
+	return nil
+}
+
+// GetStoryUnscoped 查询故事，包括已软删除的
+func (p *StoryDao) GetStoryUnscoped(id uint) (*Story, error) {
+	var s Story
+	q := p.GetDB().Unscoped().Where("id = ?", id).Limit(1).Find(&s)
+	if q.Error != nil {
+		logger.Error("查询故事报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &s, nil
+}
+
+// ListStoriesUnscopedAfter 按id顺序查询id大于afterID的故事，包括已软删除的，用于分页遍历
+func (p *StoryDao) ListStoriesUnscopedAfter(afterID uint, limit int) ([]Story, error) {
+	var stories []Story
+	q := p.GetDB().Unscoped().Where("id > ?", afterID).Order("id").Limit(limit).Find(&stories)
+	if q.Error != nil {
+		logger.Error("查询故事报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return stories, nil
+}
+
+// ImportStory 按原id写入故事和章节，用于把只存在于D1的故事导入MySQL
+func (p *StoryDao) ImportStory(s *Story, chapters []Chapter) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		if len(chapters) == 0 {
+			return nil
+		}
+		return tx.Create(&chapters).Error
+	})
+	if err != nil {
+		logger.Error("导入故事报错：", err.Error())
+		return InterError
+	}
+	return nil
+}
+
+// AddChaptersToReservedStoryInD1 在一个批次中插入所有章节并恢复 ReserveStoryInD1 占用的故事，
+// D1 批次内的语句作为一个事务执行，任一语句失败整体回滚。成功后回填章节id
+func (p *StoryDao) AddChaptersToReservedStoryInD1(s *Story, chapters []Chapter) error {
+	client := newD1Client()
+	now := time.Now().Unix()
+	var queries []modelapi.D1QueryRequest
+	for _, c := range chapters {
+		queries = append(queries, modelapi.D1QueryRequest{
+			SQL:    chapterInsertSQL,
+			Params: []interface{}{s.ID, c.ChapterNumber, c.Title, c.Content, c.ImagePrompt, c.ImagePath, c.ImageHash, c.ImageMime, c.ImageWidth, c.ImageHeight, c.VoicePath, c.VoiceOpusPath, c.VoiceAACPath, c.DurationMs, now, now, nil},
+		})
+	}
+	queries = append(queries, modelapi.D1QueryRequest{
+		SQL:    "UPDATE story SET deleted_at = NULL, updated_at = ? WHERE id = ?",
+		Params: []interface{}{now, s.ID},
+	})
+	response, err := client.ExecuteBatch(context.Background(), queries)
 	if err != nil {
 		logger.Error("添加故事到D1报错：", err.Error())
+		return err
+	}
+	for i := range chapters {
+		chapters[i].StoryID = s.ID
+		chapters[i].ID = uint(response.Result[i].Meta.LastRowID)
+	}
+	return nil
+}
+
+// ErrD1IDConflict D1中已有相同id但不是同一条记录（创建时间或所属故事不同）的故事或章节，
+// 通常是切换到MySQL之前直接写入D1的数据，不能被覆盖
+var ErrD1IDConflict = errors.New("D1中存在id相同的其他记录")
+
+// UpsertStoryWithChaptersToD1 在一个批次中按MySQL的id写入或覆盖故事和章节（包括软删除状态），
+// 重复执行结果相同。只覆盖创建时间相同的行，id被其他记录占用时返回 ErrD1IDConflict，不会覆盖这些记录
+func (p *StoryDao) UpsertStoryWithChaptersToD1(s *Story, chapters []Chapter) error {
+	client := newD1Client()
+	if err := checkD1IDConflicts(client, s, chapters); err != nil {
+		return err
+	}
+	// 检查之后id又被其他记录占用时，ON CONFLICT 的 WHERE 条件不成立，该行不会被覆盖，影响行数为0
+	queries := []modelapi.D1QueryRequest{{
+		SQL: "INSERT INTO story (id, workspace_id, title, author, description, music_style, status, created_at, updated_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+			"ON CONFLICT(id) DO UPDATE SET title = excluded.title, " +
+			"workspace_id = excluded.workspace_id, author = excluded.author, description = excluded.description, music_style = excluded.music_style, " +
+			"status = excluded.status, updated_at = excluded.updated_at, deleted_at = excluded.deleted_at " +
+			"WHERE story.created_at IS excluded.created_at",
+		Params: []interface{}{s.ID, s.WorkspaceID, s.Title, s.Author, s.Description, s.MusicStyle, s.Status, d1Time(s.CreatedAt), s.UpdatedAt.Unix(), d1DeletedAt(s.DeletedAt)},
+	}, {
+		// 先把章节序号置为 -id，避免按新序号逐行写入时与尚未更新的行冲突
+		SQL:    "UPDATE chapter SET chapter_number = -id WHERE story_id = ?",
+		Params: []interface{}{s.ID},
+	}}
+	for _, c := range chapters {
+		queries = append(queries, modelapi.D1QueryRequest{
+			SQL: "INSERT INTO chapter (id, story_id, chapter_number, title, content, image_prompt, image_path, image_hash, image_mime, image_width, image_height, voice_path, voice_opus_path, voice_aac_path, duration_ms, created_at, updated_at, deleted_at) " +
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+				"ON CONFLICT(id) DO UPDATE SET title = excluded.title, " +
+				"chapter_number = excluded.chapter_number, content = excluded.content, image_prompt = excluded.image_prompt, " +
+				"image_path = excluded.image_path, image_hash = excluded.image_hash, image_mime = excluded.image_mime, image_width = excluded.image_width, image_height = excluded.image_height, " +
+				"voice_path = excluded.voice_path, voice_opus_path = excluded.voice_opus_path, voice_aac_path = excluded.voice_aac_path, duration_ms = excluded.duration_ms, " +
+				"updated_at = excluded.updated_at, deleted_at = excluded.deleted_at " +
+				"WHERE chapter.story_id = excluded.story_id AND chapter.created_at IS excluded.created_at",
+			Params: []interface{}{c.ID, c.StoryID, c.ChapterNumber, c.Title, c.Content, c.ImagePrompt, c.ImagePath, c.ImageHash, c.ImageMime, c.ImageWidth, c.ImageHeight, c.VoicePath, c.VoiceOpusPath, c.VoiceAACPath, c.DurationMs, d1Time(c.CreatedAt), c.UpdatedAt.Unix(), d1DeletedAt(c.DeletedAt)},
+		})
+	}
+	response, err := client.ExecuteBatch(context.Background(), queries)
+	if err != nil {
+		logger.Error("同步故事到D1报错：", err.Error())
+		return err
+	}
+	if response.Result[0].Meta.Changes == 0 {
+		return fmt.Errorf("%w: story %d", ErrD1IDConflict, s.ID)
+	}
+	for i, c := range chapters {
+		if response.Result[i+2].Meta.Changes == 0 {
+			return fmt.Errorf("%w: chapter %d", ErrD1IDConflict, c.ID)
+		}
+	}
+	return nil
+}
+
+// checkD1IDConflicts 在一个批次中查询D1里与故事或章节id相同、但创建时间或所属故事不同的行，有则返回 ErrD1IDConflict
+func checkD1IDConflicts(client *modelapi.D1Client, s *Story, chapters []Chapter) error {
+	queries := []modelapi.D1QueryRequest{{
+		SQL:    "SELECT id FROM story WHERE id = ? AND created_at IS NOT ?",
+		Params: []interface{}{s.ID, d1Time(s.CreatedAt)},
+	}}
+	for _, c := range chapters {
+		queries = append(queries, modelapi.D1QueryRequest{
+			SQL:    "SELECT id FROM chapter WHERE id = ? AND (story_id != ? OR created_at IS NOT ?)",
+			Params: []interface{}{c.ID, c.StoryID, d1Time(c.CreatedAt)},
+		})
+	}
+	response, err := client.ExecuteBatch(context.Background(), queries)
+	if err != nil {
+		logger.Error("检查D1中的id冲突报错：", err.Error())
+		return err
+	}
+	if len(response.Result[0].Results) > 0 {
+		return fmt.Errorf("%w: story %d", ErrD1IDConflict, s.ID)
+	}
+	for i, c := range chapters {
+		if len(response.Result[i+1].Results) > 0 {
+			return fmt.Errorf("%w: chapter %d", ErrD1IDConflict, c.ID)
+		}
+	}
+	return nil
+}
+
+// MaxIDsFromD1 返回D1中故事和章节的最大id，包括已软删除的
+func (p *StoryDao) MaxIDsFromD1() (storyID, chapterID uint, err error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT (SELECT COALESCE(MAX(id), 0) FROM story) AS story_id, (SELECT COALESCE(MAX(id), 0) FROM chapter) AS chapter_id", nil)
+	if err != nil {
+		logger.Error("从D1查询最大id报错：", err.Error())
+		return 0, 0, err
+	}
+	type maxIDs struct {
+		StoryID   uint `json:"story_id"`
+		ChapterID uint `json:"chapter_id"`
+	}
+	rows, err := modelapi.ScanD1Rows[maxIDs](response.Rows())
+	if err != nil || len(rows) == 0 {
+		logger.Error("解析D1最大id报错")
+		return 0, 0, InterError
+	}
+	return rows[0].StoryID, rows[0].ChapterID, nil
+}
+
+// SeedIDsAbove 把MySQL中故事和章节的自增起点调到给定id之后，避免新写入的id与D1中已有的数据重复。
+// 自增起点只会调大，可重复执行
+func (p *StoryDao) SeedIDsAbove(storyID, chapterID uint) error {
+	for table, minID := range map[string]uint{StoryTableName: storyID, ChapterTableName: chapterID} {
+		var err error
+		if p.GetDB().Dialector.Name() == "sqlite" {
+			// sqlite_sequence 在表第一次插入数据后才有对应的行
+			err = p.GetDB().Exec("INSERT INTO sqlite_sequence (name, seq) SELECT ?, 0 WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = ?)", table, table).Error
+			if err == nil {
+				err = p.GetDB().Exec("UPDATE sqlite_sequence SET seq = ? WHERE name = ? AND seq < ?", minID, table, minID).Error
+			}
+		} else {
+			var maxID uint
+			err = p.GetDB().Table(table).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error
+			if err == nil && maxID < minID {
+				err = p.GetDB().Exec(fmt.Sprintf("ALTER TABLE %s AUTO_INCREMENT = %d", table, minID+1)).Error
+			}
+		}
+		if err != nil {
+			logger.Error("设置自增起点报错：", err.Error())
+			return InterError
+		}
+	}
+	return nil
+}
+
+// ListAllStoriesFromD1 分页读取D1中的所有故事，包括已软删除的
+func (p *StoryDao) ListAllStoriesFromD1() ([]Story, error) {
+	var stories []Story
+	var lastID uint
+	for {
+		page, err := p.ListStoriesFromD1After(lastID, 500)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			return stories, nil
+		}
+		stories = append(stories, page...)
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// ListStoriesFromD1After 按id顺序读取D1中id大于afterID的故事，包括已软删除的，用于分页遍历
+func (p *StoryDao) ListStoriesFromD1After(afterID uint, limit int) ([]Story, error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT id, workspace_id, title, author, description, music_style, status, created_at, updated_at, deleted_at FROM story WHERE id > ? ORDER BY id LIMIT ?",
+		[]interface{}{afterID, limit})
+	if err != nil {
+		logger.Error("从D1查询故事报错：", err.Error())
+		return nil, err
+	}
+	stories, err := modelapi.ScanD1Rows[Story](response.Rows())
+	if err != nil {
+		logger.Error("解析D1故事报错：", err.Error())
+		return nil, InterError
+	}
+	return stories, nil
+}
+
+// SoftDeleteStoryFromD1 软删除故事及其所有章节
+func (p *StoryDao) SoftDeleteStoryFromD1(id uint) error {
+	client := newD1Client()
+	now := time.Now().Unix()
+	// 章节和故事在同一个批次中更新，D1 批次内的语句作为一个事务执行
+	_, err := client.ExecuteBatch(context.Background(), []modelapi.D1QueryRequest{
+		{SQL: "UPDATE chapter SET deleted_at = ?, updated_at = ? WHERE story_id = ? AND deleted_at IS NULL", Params: []interface{}{now, now, id}},
+		{SQL: "UPDATE story SET deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL", Params: []interface{}{now, now, id}},
+	})
+	if err != nil {
+		logger.Error("从D1删除故事报错：", err.Error())
+		return err
+	}
+	return nil
+}
+
+// SetStoryStatusInD1 更新D1中的故事状态
+func (p *StoryDao) SetStoryStatusInD1(id uint, status int) error {
+	client := newD1Client()
+	_, err := client.ExecuteQuery("UPDATE story SET status = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL",
+		[]interface{}{status, time.Now().Unix(), id})
+	if err != nil {
+		logger.Error("更新D1故事状态报错：", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (p *StoryDao) GetStoryFromD1(id uint) (*Story, error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT id, workspace_id, title, author, description, music_style, status, created_at, updated_at FROM story WHERE id = ? AND deleted_at IS NULL",
+		[]interface{}{id})
+	if err != nil {
+		logger.Error("从D1查询故事报错：", err.Error())
 		return nil, err
 	}
-	return response, nil
+	stories, err := modelapi.ScanD1Rows[Story](response.Rows())
+	if err != nil {
+		logger.Error("解析D1故事报错：", err.Error())
+		return nil, InterError
+	}
+	if len(stories) == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &stories[0], nil
+}
+
+// ListStoriesFromD1 按创建时间倒序分页查询工作区中未删除的故事
+func (p *StoryDao) ListStoriesFromD1(workspaceID uint, offset, limit int) ([]Story, error) {
+	client := newD1Client()
+	response, err := client.ExecuteQuery("SELECT id, workspace_id, title, author, description, music_style, status, created_at, updated_at FROM story "+
+		"WHERE workspace_id = ? AND deleted_at IS NULL ORDER BY id DESC LIMIT ? OFFSET ?",
+		[]interface{}{workspaceID, limit, offset})
+	if err != nil {
+		logger.Error("从D1查询故事报错：", err.Error())
+		return nil, err
+	}
+	stories, err := modelapi.ScanD1Rows[Story](response.Rows())
+	if err != nil {
+		logger.Error("解析D1故事报错：", err.Error())
+		return nil, InterError
+	}
+	return stories, nil
 }
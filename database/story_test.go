@@ -0,0 +1,108 @@
+package database
+
+import (
+	"errors"
+	"fairytale-creator/modelapi"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestUpsertStoryWithChaptersToD1(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	story := func(id uint) *Story {
+		return &Story{Model: gorm.Model{ID: id, CreatedAt: created, UpdatedAt: created}, Title: "新故事", WorkspaceID: 1, Status: StoryStatusPublished}
+	}
+	chapter := func(id, storyID uint, n int) Chapter {
+		return Chapter{Model: gorm.Model{ID: id, CreatedAt: created, UpdatedAt: created}, StoryID: storyID, ChapterNumber: n, Title: "章节", VoicePath: "v.mp3"}
+	}
+	tests := []struct {
+		name string
+		// legacy 同步前已写入D1的行
+		legacy []string
+		// race 在写入批次执行前插入的行，模拟检查之后其他写入占用了id
+		race     string
+		story    *Story
+		chapters []Chapter
+		wantErr  error
+		// want 执行后在D1中查询的语句和期望的行数
+		want map[string]int
+	}{
+		{
+			name:     "写入新故事",
+			story:    story(10),
+			chapters: []Chapter{chapter(100, 10, 1), chapter(101, 10, 2)},
+			want:     map[string]int{"SELECT id FROM story WHERE id = 10 AND title = '新故事'": 1, "SELECT id FROM chapter WHERE story_id = 10": 2},
+		},
+		{
+			name:     "覆盖同一故事",
+			legacy:   []string{"INSERT INTO story (id, created_at, title, author, description, music_style, status) VALUES (10, 1700000000, '旧标题', '', '', '', 0)"},
+			story:    story(10),
+			chapters: []Chapter{chapter(100, 10, 1)},
+			want:     map[string]int{"SELECT id FROM story WHERE id = 10 AND title = '新故事' AND status = 1": 1},
+		},
+		{
+			name:     "故事id被创建时间不同的故事占用",
+			legacy:   []string{"INSERT INTO story (id, created_at, title, author, description, music_style, status) VALUES (10, 1600000000, '旧故事', '', '', '', 0)"},
+			story:    story(10),
+			chapters: []Chapter{chapter(100, 10, 1)},
+			wantErr:  ErrD1IDConflict,
+			want:     map[string]int{"SELECT id FROM story WHERE id = 10 AND title = '旧故事'": 1, "SELECT id FROM chapter": 0},
+		},
+		{
+			name: "章节id被其他故事占用",
+			legacy: []string{
+				"INSERT INTO story (id, created_at, title, author, description, music_style, status) VALUES (9, 1600000000, '旧故事', '', '', '', 0)",
+				"INSERT INTO chapter (id, created_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (101, 1700000000, 9, '旧章节', '', '', '', '')",
+			},
+			story:    story(10),
+			chapters: []Chapter{chapter(100, 10, 1), chapter(101, 10, 2)},
+			wantErr:  ErrD1IDConflict,
+			want:     map[string]int{"SELECT id FROM story WHERE id = 10": 0, "SELECT id FROM chapter WHERE id = 101 AND story_id = 9 AND title = '旧章节'": 1},
+		},
+		{
+			name:     "检查之后id被占用时不覆盖",
+			race:     "INSERT INTO chapter (id, created_at, story_id, title, content, image_prompt, image_path, voice_path) VALUES (101, 1600000000, 9, '旧章节', '', '', '', '')",
+			story:    story(10),
+			chapters: []Chapter{chapter(100, 10, 1), chapter(101, 10, 2)},
+			wantErr:  ErrD1IDConflict,
+			want:     map[string]int{"SELECT id FROM chapter WHERE id = 101 AND story_id = 9 AND title = '旧章节'": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := openTestD1(t)
+			for _, stmt := range tt.legacy {
+				if _, err := server.DB.Exec(stmt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.race != "" {
+				server.SetFail(func(batch []modelapi.D1QueryRequest) (int, string) {
+					if strings.HasPrefix(batch[0].SQL, "INSERT INTO story") {
+						if _, err := server.DB.Exec(tt.race); err != nil {
+							t.Error(err)
+						}
+						server.SetFail(nil)
+					}
+					return 0, ""
+				})
+			}
+			err := NewStoryDao().UpsertStoryWithChaptersToD1(tt.story, tt.chapters)
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("UpsertStoryWithChaptersToD1() error = %v, want %v", err, tt.wantErr)
+			}
+			for stmt, want := range tt.want {
+				var got int
+				if err := server.DB.QueryRow("SELECT COUNT(*) FROM (" + stmt + ")").Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("%s: %d rows, want %d", stmt, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const UsageRecordTableName = "usage_record"
+
+const (
+	UsageProviderDeepSeek  = "deepseek"
+	UsageProviderSeedream  = "seedream"
+	UsageProviderCosyVoice = "cosyvoice"
+	UsageProviderJimeng    = "jimeng"
+	UsageProviderVolcTTS   = "volctts"
+)
+
+const (
+	UsageOperationStory = "story" // 一次故事生成任务，units 固定为1，用于配额统计
+	UsageOperationText  = "text"  // 文本生成
+	UsageOperationImage = "image" // 图片生成
+	UsageOperationVoice = "voice" // 语音合成
+)
+
+const (
+	UsageUnitJob       = "job"
+	UsageUnitToken     = "token"
+	UsageUnitImage     = "image"
+	UsageUnitCharacter = "character"
+)
+
+// UsageRecord 一次服务商调用的用量和估算费用，同一生成任务的记录 JobID 相同（任务记录自身的id）
+type UsageRecord struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	WorkspaceID uint      `json:"workspace_id" gorm:"not null;column:workspace_id"`
+	UserID      uint      `json:"user_id" gorm:"not null;column:user_id"`
+	JobID       uint      `json:"job_id" gorm:"not null;default:0;column:job_id"`     // 不属于生成任务时为0
+	StoryID     uint      `json:"story_id" gorm:"not null;default:0;column:story_id"` // 故事入库后回填
+	Provider    string    `json:"provider" gorm:"not null;default:'';column:provider"`
+	Operation   string    `json:"operation" gorm:"not null;column:operation"`
+	Unit        string    `json:"unit" gorm:"not null;default:'';column:unit"`
+	Units       int64     `json:"units" gorm:"not null;default:0;column:units"`
+	Cost        float64   `json:"cost" gorm:"not null;default:0;column:cost"` // 估算费用（元）
+}
+
+func (r UsageRecord) TableName() string {
+	return UsageRecordTableName
+}
+
+// UsageFilter 用量查询条件，为0的字段不限制
+type UsageFilter struct {
+	WorkspaceID uint
+	UserID      uint
+	From        time.Time
+	To          time.Time // 不含，为零值时不限制
+}
+
+// UsageSummary 按服务商、操作和计量单位汇总的用量
+type UsageSummary struct {
+	Provider  string  `json:"provider"`
+	Operation string  `json:"operation"`
+	Unit      string  `json:"unit"`
+	Calls     int64   `json:"calls"`
+	Units     int64   `json:"units"`
+	Cost      float64 `json:"cost"`
+}
+
+type UsageDao struct {
+	BaseDao
+}
+
+func NewUsageDao() *UsageDao {
+	return &UsageDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+func (p *UsageDao) AddUsage(r *UsageRecord) error {
+	q := p.GetDB().Create(r)
+	if q.Error != nil {
+		logger.Error("记录用量报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// SetJobStory 把生成任务及其所有用量记录关联到入库后的故事
+func (p *UsageDao) SetJobStory(jobID, storyID uint) error {
+	q := p.GetDB().Model(&UsageRecord{}).Where("id = ? OR job_id = ?", jobID, jobID).Update("story_id", storyID)
+	if q.Error != nil {
+		logger.Error("更新用量报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// CountJobs 统计生成任务数
+func (p *UsageDao) CountJobs(f UsageFilter) (int64, error) {
+	var count int64
+	q := p.filter(f).Where("operation = ?", UsageOperationStory).Count(&count)
+	if q.Error != nil {
+		logger.Error("查询用量报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return count, nil
+}
+
+// SumCost 统计估算费用
+func (p *UsageDao) SumCost(f UsageFilter) (float64, error) {
+	var cost float64
+	q := p.filter(f).Select("COALESCE(SUM(cost), 0)").Scan(&cost)
+	if q.Error != nil {
+		logger.Error("查询用量报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return cost, nil
+}
+
+// Summarize 按服务商、操作和计量单位汇总用量
+func (p *UsageDao) Summarize(f UsageFilter) ([]UsageSummary, error) {
+	var list []UsageSummary
+	q := p.filter(f).
+		Select("provider, operation, unit, COUNT(*) AS calls, COALESCE(SUM(units), 0) AS units, COALESCE(SUM(cost), 0) AS cost").
+		Group("provider, operation, unit").Order("provider, operation, unit").Scan(&list)
+	if q.Error != nil {
+		logger.Error("查询用量报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+func (p *UsageDao) filter(f UsageFilter) *gorm.DB {
+	q := p.GetDB().Model(&UsageRecord{})
+	if f.WorkspaceID != 0 {
+		q = q.Where("workspace_id = ?", f.WorkspaceID)
+	}
+	if f.UserID != 0 {
+		q = q.Where("user_id = ?", f.UserID)
+	}
+	if !f.From.IsZero() {
+		q = q.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q = q.Where("created_at < ?", f.To)
+	}
+	return q
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"errors"
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	UserTableName      = "user"
+	UserTokenTableName = "user_token"
+)
+
+const (
+	RoleAdmin  = "admin"  // 管理用户
+	RoleEditor = "editor" // 生成和编辑故事
+	RoleReader = "reader" // 只读
+)
+
+const (
+	UserTokenInvite = "invite"
+	UserTokenReset  = "reset"
+)
+
+type User struct {
+	gorm.Model
+	Username     string `json:"username" gorm:"not null;column:username"`
+	PasswordHash string `json:"-" gorm:"not null;column:password_hash"`
+	Role         string `json:"role" gorm:"not null;column:role"`
+}
+
+func (u User) TableName() string {
+	return UserTableName
+}
+
+// UserToken 邀请注册或重置密码的一次性令牌，只保存令牌的SHA-256
+type UserToken struct {
+	gorm.Model
+	Kind        string     `json:"kind" gorm:"not null;column:kind"`
+	TokenHash   string     `json:"-" gorm:"not null;column:token_hash"`
+	UserID      uint       `json:"user_id" gorm:"not null;default:0;column:user_id"`           // 重置密码的用户
+	Role        string     `json:"role" gorm:"not null;default:'';column:role"`                // 邀请注册后的角色
+	WorkspaceID uint       `json:"workspace_id" gorm:"not null;default:1;column:workspace_id"` // 邀请注册后加入的工作区
+	CreatedBy   uint       `json:"created_by" gorm:"not null;default:0;column:created_by"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null;column:expires_at"`
+	UsedAt      *time.Time `json:"used_at" gorm:"column:used_at"`
+}
+
+func (t UserToken) TableName() string {
+	return UserTokenTableName
+}
+
+type UserDao struct {
+	BaseDao
+}
+
+func NewUserDao() *UserDao {
+	return &UserDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+func (p *UserDao) AddUser(u *User) error {
+	q := p.GetDB().Create(u)
+	if q.Error != nil {
+		logger.Error("创建用户报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+func (p *UserDao) GetUser(id uint) (*User, error) {
+	return p.getUser("id = ?", id)
+}
+
+func (p *UserDao) GetUserByUsername(username string) (*User, error) {
+	return p.getUser("username = ?", username)
+}
+
+func (p *UserDao) getUser(query string, arg interface{}) (*User, error) {
+	var u User
+	q := p.GetDB().Where(query, arg).Limit(1).Find(&u)
+	if q.Error != nil {
+		logger.Error("查询用户报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &u, nil
+}
+
+func (p *UserDao) ListUsers() ([]User, error) {
+	var users []User
+	q := p.GetDB().Order("id").Find(&users)
+	if q.Error != nil {
+		logger.Error("查询用户报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return users, nil
+}
+
+// CountUsersByRole 统计某个角色的用户数
+func (p *UserDao) CountUsersByRole(role string) (int64, error) {
+	var count int64
+	q := p.GetDB().Model(&User{}).Where("role = ?", role).Count(&count)
+	if q.Error != nil {
+		logger.Error("查询用户报错：", q.Error.Error())
+		return 0, InterError
+	}
+	return count, nil
+}
+
+func (p *UserDao) UpdatePassword(id uint, hash string) error {
+	q := p.GetDB().Model(&User{}).Where("id = ?", id).Update("password_hash", hash)
+	if q.Error != nil {
+		logger.Error("更新用户密码报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+func (p *UserDao) UpdateRole(id uint, role string) error {
+	q := p.GetDB().Model(&User{}).Where("id = ?", id).Update("role", role)
+	if q.Error != nil {
+		logger.Error("更新用户角色报错：", q.Error.Error())
+		return InterError
+	}
+	if q.RowsAffected == 0 {
+		return RecordNotFoundError
+	}
+	return nil
+}
+
+func (p *UserDao) AddToken(t *UserToken) error {
+	q := p.GetDB().Create(t)
+	if q.Error != nil {
+		logger.Error("创建令牌报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// UseToken 在事务中校验令牌未使用且未过期，标记为已使用后调用fn
+func (p *UserDao) UseToken(kind, hash string, fn func(tx *gorm.DB, t *UserToken) error) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var t UserToken
+		q := tx.Where("kind = ? AND token_hash = ? AND used_at IS NULL AND expires_at > ?", kind, hash, now).Limit(1).Find(&t)
+		if q.Error != nil {
+			return q.Error
+		}
+		if q.RowsAffected == 0 {
+			return RecordNotFoundError
+		}
+		// 条件更新，并发使用同一令牌时只有一个成功
+		q = tx.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", t.ID).Update("used_at", now)
+		if q.Error != nil {
+			return q.Error
+		}
+		if q.RowsAffected == 0 {
+			return RecordNotFoundError
+		}
+		return fn(tx, &t)
+	})
+	if err == nil || errors.Is(err, RecordNotFoundError) || errors.Is(err, RequestError) {
+		return err
+	}
+	logger.Error("使用令牌报错：", err.Error())
+	return InterError
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"fairytale-creator/logger"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WorkspaceTableName       = "workspace"
+	WorkspaceMemberTableName = "workspace_member"
+)
+
+// DefaultWorkspaceID 迁移时创建的默认工作区，工作区上线前的数据都属于它
+const DefaultWorkspaceID = 1
+
+// Workspace 工作区。主题、画风和选角为JSON，为空时使用内置或全局配置；
+// 服务商密钥为空时使用全局参数，不在接口中返回
+type Workspace struct {
+	gorm.Model
+	Name                  string  `json:"name" gorm:"not null;column:name"`
+	Slug                  string  `json:"slug" gorm:"not null;column:slug"`
+	Themes                string  `json:"-" gorm:"column:themes"`                                                   // 主题列表，JSON字符串数组
+	Styles                string  `json:"-" gorm:"column:styles"`                                                   // 画风列表，JSON字符串数组
+	VoiceCasting          string  `json:"-" gorm:"column:voice_casting"`                                            // 选角表，格式同 -voice-casting-file
+	DailyStoryQuota       int     `json:"daily_story_quota" gorm:"not null;default:0;column:daily_story_quota"`     // 每天最多生成的故事数，0为不限
+	MonthlyStoryQuota     int     `json:"monthly_story_quota" gorm:"not null;default:0;column:monthly_story_quota"` // 每月最多生成的故事数，0为不限
+	MonthlyCostQuota      float64 `json:"monthly_cost_quota" gorm:"not null;default:0;column:monthly_cost_quota"`   // 每月估算费用上限（元），0为不限
+	DeepSeekAPIKey        string  `json:"-" gorm:"not null;default:'';column:deepseek_api_key"`
+	DoubaoSeedreamAPIKey  string  `json:"-" gorm:"not null;default:'';column:doubao_seedream_api_key"`
+	CosyVoiceAPIKey       string  `json:"-" gorm:"not null;default:'';column:cosy_voice_api_key"`
+	JimengAccessKeyID     string  `json:"-" gorm:"not null;default:'';column:jimeng_access_key_id"`
+	JimengSecretAccessKey string  `json:"-" gorm:"not null;default:'';column:jimeng_secret_access_key"`
+}
+
+func (w Workspace) TableName() string {
+	return WorkspaceTableName
+}
+
+// WorkspaceMember 工作区成员及其在工作区内的角色，移除成员即删除记录
+type WorkspaceMember struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	WorkspaceID uint      `json:"workspace_id" gorm:"not null;column:workspace_id"`
+	UserID      uint      `json:"user_id" gorm:"not null;column:user_id"`
+	Role        string    `json:"role" gorm:"not null;column:role"`
+}
+
+func (m WorkspaceMember) TableName() string {
+	return WorkspaceMemberTableName
+}
+
+type WorkspaceDao struct {
+	BaseDao
+}
+
+func NewWorkspaceDao() *WorkspaceDao {
+	return &WorkspaceDao{
+		BaseDao{Engine: GetDB()},
+	}
+}
+
+// AddWorkspace 创建工作区，并把创建者以管理员加入
+func (p *WorkspaceDao) AddWorkspace(w *Workspace, ownerID uint) error {
+	err := p.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(w).Error; err != nil {
+			return err
+		}
+		return tx.Create(&WorkspaceMember{WorkspaceID: w.ID, UserID: ownerID, Role: RoleAdmin}).Error
+	})
+	if err != nil {
+		logger.Error("创建工作区报错：", err.Error())
+		return InterError
+	}
+	return nil
+}
+
+func (p *WorkspaceDao) GetWorkspace(id uint) (*Workspace, error) {
+	return p.getWorkspace("id = ?", id)
+}
+
+func (p *WorkspaceDao) GetWorkspaceBySlug(slug string) (*Workspace, error) {
+	return p.getWorkspace("slug = ?", slug)
+}
+
+func (p *WorkspaceDao) getWorkspace(query string, arg interface{}) (*Workspace, error) {
+	var w Workspace
+	q := p.GetDB().Where(query, arg).Limit(1).Find(&w)
+	if q.Error != nil {
+		logger.Error("查询工作区报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &w, nil
+}
+
+func (p *WorkspaceDao) ListWorkspaces() ([]Workspace, error) {
+	var list []Workspace
+	q := p.GetDB().Order("id").Find(&list)
+	if q.Error != nil {
+		logger.Error("查询工作区报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+// ListWorkspacesByUser 列出用户所属的工作区
+func (p *WorkspaceDao) ListWorkspacesByUser(userID uint) ([]Workspace, error) {
+	var list []Workspace
+	q := p.GetDB().Where("id IN (?)", p.GetDB().Model(&WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", userID)).
+		Order("id").Find(&list)
+	if q.Error != nil {
+		logger.Error("查询工作区报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+// UpdateWorkspace 更新工作区的配置，fields 为列名到值的映射
+func (p *WorkspaceDao) UpdateWorkspace(id uint, fields map[string]interface{}) error {
+	q := p.GetDB().Model(&Workspace{}).Where("id = ?", id).Updates(fields)
+	if q.Error != nil {
+		logger.Error("更新工作区报错：", q.Error.Error())
+		return InterError
+	}
+	if q.RowsAffected == 0 {
+		return RecordNotFoundError
+	}
+	return nil
+}
+
+// GetMember 查询用户在工作区的成员记录
+func (p *WorkspaceDao) GetMember(workspaceID, userID uint) (*WorkspaceMember, error) {
+	var m WorkspaceMember
+	q := p.GetDB().Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Limit(1).Find(&m)
+	if q.Error != nil {
+		logger.Error("查询工作区成员报错：", q.Error.Error())
+		return nil, InterError
+	}
+	if q.RowsAffected == 0 {
+		return nil, RecordNotFoundError
+	}
+	return &m, nil
+}
+
+func (p *WorkspaceDao) ListMembers(workspaceID uint) ([]WorkspaceMember, error) {
+	var list []WorkspaceMember
+	q := p.GetDB().Where("workspace_id = ?", workspaceID).Order("id").Find(&list)
+	if q.Error != nil {
+		logger.Error("查询工作区成员报错：", q.Error.Error())
+		return nil, InterError
+	}
+	return list, nil
+}
+
+// SetMember 添加成员或修改成员角色
+func (p *WorkspaceDao) SetMember(m *WorkspaceMember) error {
+	q := p.GetDB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(m)
+	if q.Error != nil {
+		logger.Error("设置工作区成员报错：", q.Error.Error())
+		return InterError
+	}
+	return nil
+}
+
+// RemoveMember 移除成员，不存在时返回 RecordNotFoundError
+func (p *WorkspaceDao) RemoveMember(workspaceID, userID uint) error {
+	q := p.GetDB().Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Delete(&WorkspaceMember{})
+	if q.Error != nil {
+		logger.Error("移除工作区成员报错：", q.Error.Error())
+		return InterError
+	}
+	if q.RowsAffected == 0 {
+		return RecordNotFoundError
+	}
+	return nil
+}
@@ -2,11 +2,21 @@ package flag
 
 import (
 	"flag"
+	"time"
 )
 
 var (
 	Username              string
 	Password              string
+	InviteTTL             time.Duration
+	ResetTokenTTL         time.Duration
+	SessionKeys           string
+	SessionMaxAge         time.Duration
+	SessionSecure         bool
+	SessionSameSite       string
+	JWTKeys               string
+	SecretKeys            string
+	JWTTTL                time.Duration
 	VideoRoot             string
 	DeepSeekAPIKey        string
 	DeepSeekUrl           string
@@ -16,7 +26,21 @@ var (
 	ImageRoot             string
 	CosyVoiceAPIKey       string
 	VoiceRoot             string
+	VoiceCastingFile      string
+	TTSTextType           string
+	PronunciationFile     string
+	TTSTimeout            time.Duration
+	JimengTimeout         time.Duration
+	AudioPostProcess      bool
+	PresignTTL            time.Duration
+	PresignRefreshMargin  time.Duration
+	AssetRedirect         bool
+	AudioTargetLUFS       float64
+	AudioFormats          string
 	DoubaoSeedreamAPIKey  string
+	DBDriver              string
+	SqlitePath            string
+	MigrateOnStart        bool
 	MysqlUsername         string
 	MysqlPassword         string
 	MysqlHost             string
@@ -26,13 +50,57 @@ var (
 	D1DatabaseID          string
 	D1Email               string
 	D1APIKey              string
+	D1BaseURL             string
+	StoryStore            string
+	SyncInterval          time.Duration
+	SyncMaxBackoff        time.Duration
 	R2AccessKeyID         string
 	R2AccessKeySecret     string
+	R2Bucket              string
+	R2ImageKeyTemplate    string
+	R2VoiceKeyTemplate    string
+	AssetBaseURL          string
+	UserDailyStoryQuota   int
+	UserMonthlyStoryQuota int
+	PriceDeepSeekInput    float64
+	PriceDeepSeekOutput   float64
+	PriceSeedreamImage    float64
+	PriceCosyVoice        float64
+	RateLimits            string
+	RateLimitStore        string
+	ClientRateLimit       string
+	LoginMaxFailures      int
+	LoginMaxUserFailures  int
+	LoginLockout          time.Duration
+	TrustedProxies        string
+	ProviderLimits        string
+	ProviderMaxRetries    int
+	ProviderRetryDelay    time.Duration
+	ProviderMaxRetryDelay time.Duration
+	BreakerFailures       int
+	BreakerOpenTimeout    time.Duration
+	FallbackImageProvider string
+	FallbackTTSProvider   string
+	VolcTTSAppID          string
+	VolcTTSAccessToken    string
+	VolcTTSCluster        string
+	VolcTTSVoice          string
+	PriceJimengImage      float64
+	PriceVolcTTS          float64
 )
 
 func init() {
-	flag.StringVar(&Username, "username", "admin", "用户名")
-	flag.StringVar(&Password, "password", "20240316", "密码")
+	flag.StringVar(&Username, "username", "", "已废弃，改用 bootstrap-admin 子命令。与 -password 同时设置且还没有管理员时，启动时用它们创建管理员")
+	flag.StringVar(&Password, "password", "", "已废弃，见 -username")
+	flag.DurationVar(&InviteTTL, "invite-ttl", 7*24*time.Hour, "邀请注册令牌有效期")
+	flag.DurationVar(&ResetTokenTTL, "reset-token-ttl", 24*time.Hour, "重置密码令牌有效期")
+	flag.StringVar(&SessionKeys, "session-keys", "", "会话Cookie签名密钥，逗号分隔，第一个用于签名，其余仅用于验证以便轮换；为空时读取环境变量 SESSION_KEYS")
+	flag.DurationVar(&SessionMaxAge, "session-max-age", 30*24*time.Hour, "登录会话有效期")
+	flag.BoolVar(&SessionSecure, "session-secure", true, "会话Cookie是否只通过HTTPS发送，本地HTTP调试时关闭")
+	flag.StringVar(&SessionSameSite, "session-same-site", "lax", "会话Cookie的SameSite: lax / strict / none")
+	flag.StringVar(&JWTKeys, "jwt-keys", "", "访问令牌(JWT)签名密钥，逗号分隔，第一个用于签名，其余仅用于验证以便轮换；为空时读取环境变量 JWT_KEYS")
+	flag.StringVar(&SecretKeys, "secret-keys", "", "工作区服务商密钥的加密密钥，逗号分隔，第一个用于加密，其余仅用于解密以便轮换；为空时读取环境变量 SECRET_KEYS")
+	flag.DurationVar(&JWTTTL, "jwt-ttl", 15*time.Minute, "访问令牌有效期")
 	flag.StringVar(&VideoRoot, "video-root", "", "视频存储根路径")
 	flag.StringVar(&DeepSeekAPIKey, "deepseek-api-key", "", "DeepSeek API Key")
 	flag.StringVar(&DeepSeekUrl, "deepseek-url", "https://api.deepseek.com", "DeepSeek URL")
@@ -42,7 +110,20 @@ func init() {
 	flag.StringVar(&ImageRoot, "image-root", "images", "图片存储根路径")
 	flag.StringVar(&CosyVoiceAPIKey, "cosy-voice-api-key", "", "CosyVoice API Key")
 	flag.StringVar(&VoiceRoot, "voice-root", "voices", "语音存储根路径")
+	flag.StringVar(&VoiceCastingFile, "voice-casting-file", "", "配音选角表(JSON)路径，为空时使用内置选角")
+	flag.StringVar(&TTSTextType, "tts-text-type", "plain", "语音合成文本类型: plain / ssml")
+	flag.DurationVar(&TTSTimeout, "tts-timeout", 5*time.Minute, "单个章节语音合成超时时间")
+	flag.DurationVar(&PresignTTL, "presign-ttl", time.Hour, "资源预签名URL有效期")
+	flag.DurationVar(&PresignRefreshMargin, "presign-refresh-margin", 5*time.Minute, "预签名URL缓存在过期前多久失效并重新签名")
+	flag.BoolVar(&AssetRedirect, "asset-redirect", false, "是否开启 /v1/asset/*key 重定向到预签名URL")
+	flag.BoolVar(&AudioPostProcess, "audio-post-process", true, "是否对合成语音做响度归一化、去静音和转码(依赖ffmpeg)")
+	flag.Float64Var(&AudioTargetLUFS, "audio-target-lufs", -16, "语音响度归一化目标(LUFS)")
+	flag.StringVar(&AudioFormats, "audio-formats", "opus,aac", "除MP3外额外生成的语音格式，逗号分隔: opus / aac")
+	flag.StringVar(&PronunciationFile, "pronunciation-file", "", "全局读音词典(JSON)路径，ssml模式下生效")
 	flag.StringVar(&DoubaoSeedreamAPIKey, "doubao-seedream-api-key", "", "Doubao Seedream API Key")
+	flag.StringVar(&DBDriver, "db-driver", "mysql", "数据库类型: mysql / sqlite")
+	flag.StringVar(&SqlitePath, "sqlite-path", "fairytale.db", "SQLite数据库文件路径，db-driver为sqlite时生效")
+	flag.BoolVar(&MigrateOnStart, "migrate-on-start", true, "启动服务时执行未执行的数据库迁移")
 	flag.StringVar(&MysqlUsername, "mysql-username", "admin", "Mysql用户名")
 	flag.StringVar(&MysqlPassword, "mysql-password", "20240316", "Mysql密码")
 	flag.StringVar(&MysqlHost, "mysql-host", "localhost", "Mysq	l主机")
@@ -52,7 +133,54 @@ func init() {
 	flag.StringVar(&D1DatabaseID, "d1-database-id", "", "D1 Database ID")
 	flag.StringVar(&D1Email, "d1-email", "", "D1 Email")
 	flag.StringVar(&D1APIKey, "d1-api-key", "", "D1 API Key")
+	flag.StringVar(&D1BaseURL, "d1-base-url", "https://api.cloudflare.com/client/v4", "Cloudflare API地址，本地测试时可指向模拟服务")
+	flag.StringVar(&StoryStore, "story-store", "mysql", "故事和章节的存储: mysql（以MySQL为准并同步到D1）/ d1（直接写入D1）")
+	flag.DurationVar(&SyncInterval, "sync-interval", 5*time.Second, "MySQL到D1同步的轮询间隔")
+	flag.DurationVar(&SyncMaxBackoff, "sync-max-backoff", time.Hour, "同步失败后重试的最长等待时间")
 	flag.StringVar(&R2AccessKeyID, "r2-access-key-id", "", "R2 Access Key ID")
 	flag.StringVar(&R2AccessKeySecret, "r2-access-key-secret", "", "R2 Access Key Secret")
+	flag.StringVar(&R2Bucket, "r2-bucket", "fairytale", "R2存储桶名称")
+	flag.StringVar(&R2ImageKeyTemplate, "r2-image-key-template", "workspaces/{workspace_id}/stories/{story_id}/chapters/{n}/image{ext}", "章节图片对象键模板，可用占位符: {workspace_id} {story_id} {n} {name} {ext}；衍生图在扩展名前追加 _thumb 等后缀，启动时检查不会与其他键重复")
+	flag.StringVar(&R2VoiceKeyTemplate, "r2-voice-key-template", "workspaces/{workspace_id}/stories/{story_id}/chapters/{n}/voice{ext}", "章节语音对象键模板，可用占位符同上")
+	flag.StringVar(&AssetBaseURL, "asset-base-url", "", "公开CDN地址，设置后资源链接直接拼接该地址而不再预签名")
+	flag.IntVar(&UserDailyStoryQuota, "user-daily-story-quota", 0, "每个用户每天最多生成的故事数（所有工作区合计），0为不限")
+	flag.IntVar(&UserMonthlyStoryQuota, "user-monthly-story-quota", 0, "每个用户每月最多生成的故事数（所有工作区合计），0为不限")
+	flag.Float64Var(&PriceDeepSeekInput, "price-deepseek-input", 0.002, "DeepSeek输入单价（元/千tokens），用于估算费用")
+	flag.Float64Var(&PriceDeepSeekOutput, "price-deepseek-output", 0.008, "DeepSeek输出单价（元/千tokens）")
+	flag.Float64Var(&PriceSeedreamImage, "price-seedream-image", 0.2, "Seedream单价（元/张）")
+	flag.Float64Var(&PriceCosyVoice, "price-cosyvoice", 2, "CosyVoice单价（元/万字符）")
+	flag.StringVar(&RateLimits, "rate-limits", "*=20/s:40,/v1/story/add=6/h:2,/v1/story/voice/generate=30/m:10,/v1/user/login=10/m:10,/v1/user/register=10/h:5,/v1/user/password/reset=10/h:5",
+		"接口限流，逗号分隔的 路由=次数/单位(s|m|h)[:突发]，* 为其他接口共用的默认值，off 为不限流")
+	flag.StringVar(&RateLimitStore, "rate-limit-store", "memory", "限流令牌桶的存储: memory（单实例）/ db（多实例共享）")
+	flag.StringVar(&ClientRateLimit, "client-rate-limit", "60/s:120", "认证之前按客户端IP的限流，格式同 -rate-limits，off 为不限流")
+	flag.IntVar(&LoginMaxFailures, "login-max-failures", 5, "同一用户名在同一IP连续登录失败多少次后锁定，0为不锁定")
+	flag.IntVar(&LoginMaxUserFailures, "login-max-user-failures", 50, "同一用户名在所有IP累计登录失败多少次后锁定，应远大于 -login-max-failures，避免他人故意输错就能锁定账号，0为不限制")
+	flag.DurationVar(&LoginLockout, "login-lockout", 15*time.Minute, "登录锁定时长，期间每隔 锁定时长/失败次数 恢复一次尝试机会")
+	flag.StringVar(&TrustedProxies, "trusted-proxies", "127.0.0.1,::1", "可信反向代理地址，逗号分隔，只采信它们转发的 X-Forwarded-For 作为客户端IP")
+	flag.StringVar(&ProviderLimits, "provider-limits", "deepseek=5:4,seedream=2:2,jimeng=1:1,cosyvoice=3:3,volctts=5:4,d1=20:8,r2=20:8",
+		"调用服务商的限流，逗号分隔的 服务商=每秒请求数[:并发数]，未列出的服务商不限制")
+	flag.IntVar(&ProviderMaxRetries, "provider-max-retries", 3, "调用服务商遇到限流、5xx或网络错误时的重试次数")
+	flag.DurationVar(&ProviderRetryDelay, "provider-retry-delay", time.Second, "调用服务商首次重试的等待时间，之后指数增长并加随机抖动")
+	flag.DurationVar(&ProviderMaxRetryDelay, "provider-max-retry-delay", 30*time.Second, "调用服务商重试的最长等待时间")
+	flag.IntVar(&BreakerFailures, "breaker-failures", 5, "服务商连续失败多少次后熔断，0为不熔断")
+	flag.DurationVar(&BreakerOpenTimeout, "breaker-open-timeout", 30*time.Second, "熔断后多久放行一个探测请求")
+	flag.StringVar(&FallbackImageProvider, "fallback-image-provider", "jimeng", "Seedream不可用时的备用出图服务商: jimeng / 空（不切换）")
+	flag.DurationVar(&JimengTimeout, "jimeng-timeout", 3*time.Minute, "即梦备用出图的超时时间，包括提交任务和轮询结果")
+	flag.StringVar(&FallbackTTSProvider, "fallback-tts-provider", "volctts", "CosyVoice不可用时的备用朗读服务商: volctts / 空（不切换）")
+	flag.StringVar(&VolcTTSAppID, "volc-tts-appid", "", "火山引擎语音合成 AppID，备用朗读使用")
+	flag.StringVar(&VolcTTSAccessToken, "volc-tts-access-token", "", "火山引擎语音合成 Access Token")
+	flag.StringVar(&VolcTTSCluster, "volc-tts-cluster", "volcano_tts", "火山引擎语音合成集群")
+	flag.StringVar(&VolcTTSVoice, "volc-tts-voice", "BV700_streaming", "备用朗读使用的音色")
+	flag.Float64Var(&PriceJimengImage, "price-jimeng-image", 0.2, "即梦单价（元/张）")
+	flag.Float64Var(&PriceVolcTTS, "price-volc-tts", 5, "火山引擎语音合成单价（元/万字符）")
+}
+
+// Parse 解析命令行参数，main 在读取任何参数之前调用
+func Parse() {
 	flag.Parse()
 }
+
+// Args 返回全局参数之后的剩余参数，用于执行子命令
+func Args() []string {
+	return flag.Args()
+}
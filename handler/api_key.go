@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/request"
+	"fairytale-creator/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listAPIKeys(ctx *gin.Context) {
+	requested, _ := strconv.ParseUint(ctx.Query("user_id"), 10, 64)
+	userID, ok := targetUserID(ctx, uint(requested))
+	if !ok {
+		return
+	}
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	apiKeyService := service.NewAPIKeyService()
+	list, err := apiKeyService.ListAPIKeys(service.CurrentWorkspace(ctx).ID, userID)
+	if err != nil {
+		res[Message] = "获取API Key失败"
+		return
+	}
+	res[Data] = list
+	res[Message] = "获取API Key成功"
+}
+
+func createAPIKey(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.CreateAPIKeyReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil || form.ExpiresInDays < 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	apiKeyService := service.NewAPIKeyService()
+	created, err := apiKeyService.CreateAPIKey(ctx, form)
+	if err != nil {
+		res[Message] = authErrorMessage(err, "创建API Key失败")
+		return
+	}
+	res[Data] = created
+	res[Message] = "创建API Key成功"
+}
+
+func revokeAPIKey(ctx *gin.Context) {
+	var form request.RevokeAPIKeyReq
+	if err := ctx.ShouldBindJSON(&form); err != nil || form.ID == 0 {
+		ctx.JSON(http.StatusOK, gin.H{Data: nil, Message: "请求有误"})
+		return
+	}
+	userID, ok := targetUserID(ctx, form.UserID)
+	if !ok {
+		return
+	}
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	apiKeyService := service.NewAPIKeyService()
+	err := apiKeyService.RevokeAPIKey(service.CurrentWorkspace(ctx).ID, userID, form.ID)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "API Key不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "撤销API Key失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "撤销API Key成功"
+}
+
+// issueToken 用会话或API Key换取短期访问令牌
+func issueToken(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	token, err := service.IssueAccessToken(ctx)
+	if err != nil {
+		res[Message] = authErrorMessage(err, "签发访问令牌失败")
+		return
+	}
+	res[Data] = token
+	res[Message] = "签发访问令牌成功"
+}
+
+// authErrorMessage 将API Key和访问令牌相关的错误转换为提示信息
+func authErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidScope):
+		return "范围无效，可选 read / generate / admin"
+	case errors.Is(err, service.ErrScopeTooBroad):
+		return "范围不能超过当前权限"
+	case errors.Is(err, service.ErrTokenFromToken):
+		return "访问令牌不能用于换取新令牌"
+	default:
+		return fallback
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/service"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// redirectAsset 校验对象所属故事已发布后重定向到预签名地址
+func redirectAsset(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key == "" {
+		res[Message] = "请求有误"
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	assetService := service.NewAssetService()
+	url, err := assetService.PublishedAssetURL(c.Request.Context(), key)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "资源不存在"
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	if err != nil {
+		res[Message] = "获取资源失败"
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/request"
+	"fairytale-creator/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func reorderChapters(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	var form request.ReorderChaptersReq
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.StoryID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	chapterService := service.NewChapterService()
+	err = chapterService.ReorderChapters(service.CurrentWorkspace(c).ID, form.StoryID, form.ChapterIDs)
+	if err != nil {
+		res[Message] = chapterErrorMessage(err, "调整章节顺序失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "调整章节顺序成功"
+	return
+}
+
+func insertChapter(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	var form request.InsertChapterReq
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.StoryID == 0 || form.Title == "" {
+		res[Message] = "请求有误"
+		return
+	}
+	chapterService := service.NewChapterService()
+	chapter, err := chapterService.InsertChapter(service.CurrentWorkspace(c).ID, form.StoryID, form.Position, form.Title, form.Content)
+	if err != nil {
+		res[Message] = chapterErrorMessage(err, "插入章节失败")
+		return
+	}
+	res[Data] = chapter
+	res[Message] = "插入章节成功"
+	return
+}
+
+func deleteChapter(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	var form request.DeleteChapterReq
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.StoryID == 0 || form.ChapterID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	chapterService := service.NewChapterService()
+	err = chapterService.DeleteChapter(service.CurrentWorkspace(c).ID, form.StoryID, form.ChapterID)
+	if err != nil {
+		res[Message] = chapterErrorMessage(err, "删除章节失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "删除章节成功"
+	return
+}
+
+// chapterErrorMessage 将章节编辑的错误转换为提示信息
+func chapterErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, database.RecordNotFoundError):
+		return "故事或章节不存在"
+	case errors.Is(err, database.RequestError):
+		return "章节列表与故事不一致"
+	case errors.Is(err, service.ErrChapterEditUnsupported):
+		return "当前存储不支持编辑章节"
+	default:
+		return fallback
+	}
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"fairytale-creator/database"
 	"fairytale-creator/flag"
+	"fairytale-creator/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,16 +14,68 @@ const (
 )
 
 func Init(engine *gin.Engine) {
-	user := engine.Group("/v1/user")
+	// 无需登录，按客户端IP限流
+	public := engine.Group("/v1/user", middleware.RateLimit)
 	{
-		user.POST("/login", login)
+		public.POST("/login", login)
+		public.POST("/register", register)
+		public.POST("/password/reset", resetPassword)
 	}
 
-	story := engine.Group("/v1/story")
+	// 服务商熔断状态，供监控使用
+	engine.GET("/v1/health", middleware.RateLimit, getHealth)
+
+	// 以下路由均需登录，角色要求 reader < editor < admin。
+	// 认证之前按IP限流，认证之后按用户或API Key限流
+	authed := engine.Group("/v1", middleware.ClientRateLimit, middleware.LoginAuth, middleware.RateLimit)
+	editor := middleware.RequireRole(database.RoleEditor)
+	admin := middleware.RequireRole(database.RoleAdmin)
+
+	user := authed.Group("/user")
+	{
+		user.POST("/logout", middleware.SessionOnly, logout)
+		user.POST("/password", middleware.SessionOnly, changePassword)
+		user.GET("/sessions", listSessions)
+		user.POST("/sessions/revoke", middleware.SessionOnly, revokeSession)
+		user.GET("/api-keys", middleware.Workspace, listAPIKeys)
+		user.POST("/api-keys/create", middleware.SessionOnly, middleware.Workspace, createAPIKey)
+		user.POST("/api-keys/revoke", middleware.SessionOnly, middleware.Workspace, revokeAPIKey)
+		user.POST("/token", middleware.Workspace, issueToken)
+		user.GET("/list", admin, listUsers)
+		user.POST("/invite", admin, createInvite)
+		user.POST("/reset-token", admin, createResetToken)
+		user.POST("/role", admin, setRole)
+	}
+
+	// 以下路由在工作区内操作，工作区由 X-Workspace-ID 或凭证绑定的工作区确定，角色取在工作区中的角色
+	workspace := authed.Group("/workspace")
+	{
+		workspace.GET("/list", listWorkspaces)
+		workspace.POST("/create", admin, createWorkspace)
+		workspace.GET("/detail", middleware.Workspace, getWorkspace)
+		workspace.POST("/update", middleware.Workspace, admin, updateWorkspace)
+		workspace.GET("/members", middleware.Workspace, admin, listMembers)
+		workspace.POST("/members/set", middleware.Workspace, admin, setMember)
+		workspace.POST("/members/remove", middleware.Workspace, admin, removeMember)
+	}
+
+	authed.GET("/usage", middleware.Workspace, getUsage)
+
+	story := authed.Group("/story", middleware.Workspace)
 	{
-		story.POST("/add", addStory)
 		story.GET("/list", listStory)
-		story.POST("/voice/generate", generateVoice)
+		story.GET("/detail/:id", getStory)
+		story.POST("/add", editor, addStory)
+		story.POST("/delete", editor, deleteStory)
+		story.POST("/publish", editor, publishStory)
+		story.POST("/voice/generate", editor, generateVoice)
+		story.POST("/chapter/reorder", editor, reorderChapters)
+		story.POST("/chapter/insert", editor, insertChapter)
+		story.POST("/chapter/delete", editor, deleteChapter)
+	}
+	// 资源跳转只对已发布故事签名，供未登录的播放端使用
+	if flag.AssetRedirect {
+		engine.GET("/v1/asset/*key", middleware.RateLimit, redirectAsset)
 	}
 	engine.Static("/v1/resource", flag.VideoRoot)
 }
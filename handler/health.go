@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"fairytale-creator/modelapi"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getHealth 返回各服务商熔断器的状态，有熔断器未关闭时 status 为 degraded，无需登录
+func getHealth(c *gin.Context) {
+	providers := modelapi.BreakerStatuses()
+	status := "ok"
+	for _, provider := range providers {
+		if provider.State != modelapi.BreakerClosed {
+			status = "degraded"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		Data:    gin.H{"status": status, "providers": providers},
+		Message: "获取服务状态成功",
+	})
+}
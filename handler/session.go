@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/request"
+	"fairytale-creator/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listSessions(ctx *gin.Context) {
+	requested, _ := strconv.ParseUint(ctx.Query("user_id"), 10, 64)
+	userID, ok := targetUserID(ctx, uint(requested))
+	if !ok {
+		return
+	}
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	sessionService := service.NewSessionService()
+	list, err := sessionService.ListSessions(ctx, userID)
+	if err != nil {
+		res[Message] = "获取会话失败"
+		return
+	}
+	res[Data] = list
+	res[Message] = "获取会话成功"
+}
+
+func revokeSession(ctx *gin.Context) {
+	var form request.RevokeSessionReq
+	if err := ctx.ShouldBindJSON(&form); err != nil {
+		ctx.JSON(http.StatusOK, gin.H{Data: nil, Message: "请求有误"})
+		return
+	}
+	userID, ok := targetUserID(ctx, form.UserID)
+	if !ok {
+		return
+	}
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	sessionService := service.NewSessionService()
+	if form.ID == 0 {
+		n, err := sessionService.RevokeOtherSessions(ctx, userID)
+		if err != nil {
+			res[Message] = "撤销会话失败"
+			return
+		}
+		res[Data] = n
+		res[Message] = "撤销会话成功"
+		return
+	}
+	err := sessionService.RevokeSession(userID, form.ID)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "会话不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "撤销会话失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "撤销会话成功"
+}
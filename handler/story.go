@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"errors"
+	"fairytale-creator/database"
 	"fairytale-creator/flag"
+	"fairytale-creator/modelapi"
 	"fairytale-creator/request"
 	"fairytale-creator/service"
 	"net/http"
 	"path"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,8 +22,15 @@ func addStory(c *gin.Context) {
 	defer func() {
 		c.JSON(http.StatusOK, res)
 	}()
-	storyService := service.NewStoryService()
-	story := storyService.GenerateStory()
+	workspace := service.CurrentWorkspace(c)
+	meter, err := service.StartJob(workspace, service.CurrentUser(c).ID)
+	if err != nil {
+		res[Message] = quotaErrorMessage(err, "生成故事失败")
+		return
+	}
+	storyService := service.NewStoryServiceForWorkspace(workspace)
+	storyService.Meter = meter
+	story := storyService.GenerateStory(c.Request.Context())
 	// story := &response.Story{
 	// 	Title:       "故事标题",
 	// 	Author:      "故事作者",
@@ -35,7 +46,7 @@ func addStory(c *gin.Context) {
 		res[Message] = "生成故事失败"
 		return
 	}
-	err := storyService.AddStory(story)
+	err = storyService.AddStory(c.Request.Context(), story)
 	if err != nil {
 		res[Message] = "添加故事失败"
 		return
@@ -54,11 +65,110 @@ func listStory(c *gin.Context) {
 	defer func() {
 		c.JSON(http.StatusOK, res)
 	}()
-	res[Data] = flag.DeepSeekAPIKey
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if page < 1 || size < 1 || size > 100 {
+		res[Message] = "请求有误"
+		return
+	}
+	storyService := service.NewStoryServiceForWorkspace(service.CurrentWorkspace(c))
+	list, err := storyService.ListStories((page-1)*size, size)
+	if err != nil {
+		res[Message] = "获取故事失败"
+		return
+	}
+	res[Data] = list
+	res[Message] = "获取故事成功"
+	return
+}
+
+func getStory(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		res[Message] = "请求有误"
+		return
+	}
+	storyService := service.NewStoryServiceForWorkspace(service.CurrentWorkspace(c))
+	story, err := storyService.GetStory(c.Request.Context(), uint(id))
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "故事不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "获取故事失败"
+		return
+	}
+	res[Data] = story
 	res[Message] = "获取故事成功"
 	return
 }
 
+func deleteStory(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	var form request.DeleteStoryReq
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.ID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	assetService := service.NewAssetService()
+	err = assetService.DeleteStory(c.Request.Context(), service.CurrentWorkspace(c).ID, form.ID)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "故事不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "删除故事失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "删除故事成功"
+	return
+}
+
+// publishStory 发布或撤回故事，只有已发布故事的资源可以匿名访问
+func publishStory(c *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		c.JSON(http.StatusOK, res)
+	}()
+	var form request.PublishStoryReq
+	err := c.ShouldBindJSON(&form)
+	if err != nil || form.ID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	storyService := service.NewStoryServiceForWorkspace(service.CurrentWorkspace(c))
+	err = storyService.SetPublished(form.ID, form.Published)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "故事不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "更新故事状态失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "更新故事状态成功"
+	return
+}
+
 func generateVoice(c *gin.Context) {
 	res := gin.H{
 		Data:    nil,
@@ -73,13 +183,33 @@ func generateVoice(c *gin.Context) {
 		res[Message] = "请求有误"
 		return
 	}
-	storyService := service.NewStoryService()
-	success := storyService.GenerateVoice(form.Text, path.Join(flag.VideoRoot, form.Filename))
-	if !success {
-		res[Message] = "生成语音失败"
+	workspace := service.CurrentWorkspace(c)
+	if err := service.CheckCostQuota(workspace); err != nil {
+		res[Message] = quotaErrorMessage(err, "生成语音失败")
+		return
+	}
+	storyService := service.NewStoryServiceForWorkspace(workspace)
+	storyService.Meter = service.NewUsageMeter(workspace.ID, service.CurrentUser(c).ID)
+	err = storyService.GenerateVoice(c.Request.Context(), form.Text, path.Join(flag.VideoRoot, form.Filename))
+	if err != nil {
+		res[Message] = voiceErrorMessage(err)
 		return
 	}
 	res[Data] = true
 	res[Message] = "生成语音成功"
 	return
 }
+
+// voiceErrorMessage 将语音合成错误转换为返回给前端的提示
+func voiceErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, modelapi.ErrTTSDataInspection):
+		return "文本未通过内容审核"
+	case errors.Is(err, modelapi.ErrTTSQuota):
+		return "语音合成额度不足，请稍候再试"
+	case errors.Is(err, modelapi.ErrTTSTimeout):
+		return "语音合成超时，请稍候再试"
+	default:
+		return "生成语音失败"
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/service"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getUsage(ctx *gin.Context) {
+	requested, _ := strconv.ParseUint(ctx.Query("user_id"), 10, 64)
+	userID := uint(requested)
+	// 工作区管理员可以查看整个工作区（user_id为0）或指定成员，其他成员只能查看自己
+	if service.CurrentRole(ctx) != database.RoleAdmin {
+		current := service.CurrentUser(ctx).ID
+		if userID != 0 && userID != current {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{Data: nil, Message: "没有权限"})
+			return
+		}
+		userID = current
+	}
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	from, to, err := service.UsagePeriod(ctx.Query("period"), ctx.Query("from"), ctx.Query("to"))
+	if err != nil {
+		res[Message] = "统计区间有误"
+		return
+	}
+	usageService := service.NewUsageService()
+	report, err := usageService.Report(service.CurrentWorkspace(ctx), userID, from, to)
+	if err != nil {
+		res[Message] = "获取用量失败"
+		return
+	}
+	res[Data] = report
+	res[Message] = "获取用量成功"
+}
+
+// quotaErrorMessage 将配额错误转换为提示信息
+func quotaErrorMessage(err error, fallback string) string {
+	var quotaErr *service.QuotaError
+	if !errors.As(err, &quotaErr) {
+		return fallback
+	}
+	scope := map[string]string{service.QuotaScopeWorkspace: "工作区", service.QuotaScopeUser: "您"}[quotaErr.Scope]
+	period := map[string]string{service.QuotaPeriodDay: "今日", service.QuotaPeriodMonth: "本月"}[quotaErr.Period]
+	if quotaErr.Kind == service.QuotaKindCost {
+		return fmt.Sprintf("%s%s费用已达上限（%.2f元）", scope, period, quotaErr.Limit)
+	}
+	return fmt.Sprintf("%s%s生成故事数已达上限（%d个）", scope, period, int(quotaErr.Limit))
+}
@@ -1,13 +1,31 @@
 package handler
 
 import (
-	"github.com/gin-gonic/gin"
-	"net/http"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/middleware"
 	"fairytale-creator/request"
 	"fairytale-creator/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
 )
 
 func login(ctx *gin.Context) {
+	//转化为LoginRequest结构
+	var form request.LoginReq
+	if err := ctx.ShouldBindJSON(&form); err != nil {
+		ctx.JSON(http.StatusOK, gin.H{Data: nil, Message: "请求有误"})
+		return
+	}
+	userService := service.NewUserService()
+	user, err := userService.Login(ctx, form)
+	var locked *service.LoginLockedError
+	if errors.As(err, &locked) {
+		middleware.SetRetryAfter(ctx, locked.RetryAfter)
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{Data: nil, Message: "登录失败次数过多，请稍后再试"})
+		return
+	}
 	res := gin.H{
 		Data:    nil,
 		Message: "",
@@ -15,14 +33,225 @@ func login(ctx *gin.Context) {
 	defer func() {
 		ctx.JSON(http.StatusOK, res)
 	}()
-	//转化为LoginRequest结构
-	var form request.LoginReq
+	if err != nil {
+		res[Message] = userErrorMessage(err, "登录失败")
+		return
+	}
+	res[Data] = user
+	res[Message] = "登录成功"
+}
+
+func logout(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	userService := service.NewUserService()
+	if err := userService.Logout(ctx); err != nil {
+		res[Message] = "退出登录失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "退出登录成功"
+}
+
+func register(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.RegisterReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil || form.Token == "" {
+		res[Message] = "请求有误"
+		return
+	}
+	userService := service.NewUserService()
+	user, err := userService.Register(form)
+	if err != nil {
+		res[Message] = userErrorMessage(err, "注册失败")
+		return
+	}
+	res[Data] = user
+	res[Message] = "注册成功"
+}
+
+func changePassword(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.ChangePasswordReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil {
+		res[Message] = "请求有误"
+		return
+	}
+	userService := service.NewUserService()
+	if err := userService.ChangePassword(ctx, form); err != nil {
+		res[Message] = userErrorMessage(err, "修改密码失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "修改密码成功"
+}
+
+func resetPassword(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.ResetPasswordReq
 	err := ctx.ShouldBindJSON(&form)
+	if err != nil || form.Token == "" {
+		res[Message] = "请求有误"
+		return
+	}
+	userService := service.NewUserService()
+	if err := userService.ResetPassword(form); err != nil {
+		res[Message] = userErrorMessage(err, "重置密码失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "重置密码成功"
+}
+
+func listUsers(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	userService := service.NewUserService()
+	users, err := userService.ListUsers()
+	if err != nil {
+		res[Message] = "获取用户失败"
+		return
+	}
+	res[Data] = users
+	res[Message] = "获取用户成功"
+}
+
+func createInvite(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.CreateInviteReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil {
+		res[Message] = "请求有误"
+		return
+	}
+	userService := service.NewUserService()
+	token, err := userService.CreateInvite(service.CurrentUser(ctx).ID, form.Role, form.WorkspaceID)
+	if err != nil {
+		res[Message] = userErrorMessage(err, "创建邀请失败")
+		return
+	}
+	res[Data] = token
+	res[Message] = "创建邀请成功"
+}
+
+func createResetToken(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.CreateResetTokenReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil || form.UserID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	userService := service.NewUserService()
+	token, err := userService.CreateResetToken(service.CurrentUser(ctx).ID, form.UserID)
 	if err != nil {
+		res[Message] = userErrorMessage(err, "创建重置令牌失败")
+		return
+	}
+	res[Data] = token
+	res[Message] = "创建重置令牌成功"
+}
+
+func setRole(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.SetRoleReq
+	err := ctx.ShouldBindJSON(&form)
+	if err != nil || form.UserID == 0 {
 		res[Message] = "请求有误"
 		return
 	}
 	userService := service.NewUserService()
-	data := userService.Login(ctx, form)
-	res[Data] = data
+	if err := userService.SetRole(form.UserID, form.Role); err != nil {
+		res[Message] = userErrorMessage(err, "修改角色失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "修改角色成功"
+}
+
+// targetUserID 返回要操作的用户，非管理员只能操作自己的会话和API Key
+func targetUserID(ctx *gin.Context, requested uint) (uint, bool) {
+	user := service.CurrentUser(ctx)
+	if requested == 0 || requested == user.ID {
+		return user.ID, true
+	}
+	if service.CurrentRole(ctx) != database.RoleAdmin {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{Data: nil, Message: "没有权限"})
+		return 0, false
+	}
+	return requested, true
+}
+
+// userErrorMessage 将用户相关的错误转换为提示信息
+func userErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidCredentials):
+		return "用户名或密码错误"
+	case errors.Is(err, service.ErrWeakPassword):
+		return "密码至少8位"
+	case errors.Is(err, service.ErrPasswordTooLong):
+		return "密码不能超过72个字节"
+	case errors.Is(err, service.ErrInvalidUsername):
+		return "用户名长度需要在3到64之间"
+	case errors.Is(err, service.ErrUsernameTaken):
+		return "用户名已存在"
+	case errors.Is(err, service.ErrInvalidRole):
+		return "角色无效"
+	case errors.Is(err, service.ErrInvalidToken):
+		return "令牌无效或已过期"
+	case errors.Is(err, service.ErrWorkspaceNotFound):
+		return "工作区不存在"
+	case errors.Is(err, database.RecordNotFoundError):
+		return "用户不存在"
+	default:
+		return fallback
+	}
 }
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/request"
+	"fairytale-creator/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func listWorkspaces(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	workspaceService := service.NewWorkspaceService()
+	list, err := workspaceService.ListWorkspaces(ctx)
+	if err != nil {
+		res[Message] = "获取工作区失败"
+		return
+	}
+	res[Data] = list
+	res[Message] = "获取工作区成功"
+}
+
+func createWorkspace(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.CreateWorkspaceReq
+	if err := ctx.ShouldBindJSON(&form); err != nil {
+		res[Message] = "请求有误"
+		return
+	}
+	workspaceService := service.NewWorkspaceService()
+	workspace, err := workspaceService.CreateWorkspace(ctx, form)
+	if err != nil {
+		res[Message] = workspaceErrorMessage(err, "创建工作区失败")
+		return
+	}
+	res[Data] = workspace
+	res[Message] = "创建工作区成功"
+}
+
+func getWorkspace(ctx *gin.Context) {
+	workspaceService := service.NewWorkspaceService()
+	ctx.JSON(http.StatusOK, gin.H{Data: workspaceService.GetWorkspace(ctx), Message: "获取工作区成功"})
+}
+
+func updateWorkspace(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.UpdateWorkspaceReq
+	if err := ctx.ShouldBindJSON(&form); err != nil {
+		res[Message] = "请求有误"
+		return
+	}
+	workspaceService := service.NewWorkspaceService()
+	if err := workspaceService.UpdateWorkspace(ctx, form); err != nil {
+		res[Message] = workspaceErrorMessage(err, "修改工作区失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "修改工作区成功"
+}
+
+func listMembers(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	workspaceService := service.NewWorkspaceService()
+	list, err := workspaceService.ListMembers(ctx)
+	if err != nil {
+		res[Message] = "获取成员失败"
+		return
+	}
+	res[Data] = list
+	res[Message] = "获取成员成功"
+}
+
+func setMember(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.SetMemberReq
+	if err := ctx.ShouldBindJSON(&form); err != nil || form.UserID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	workspaceService := service.NewWorkspaceService()
+	if err := workspaceService.SetMember(ctx, form); err != nil {
+		res[Message] = userErrorMessage(err, "设置成员失败")
+		return
+	}
+	res[Data] = true
+	res[Message] = "设置成员成功"
+}
+
+func removeMember(ctx *gin.Context) {
+	res := gin.H{
+		Data:    nil,
+		Message: "",
+	}
+	defer func() {
+		ctx.JSON(http.StatusOK, res)
+	}()
+	var form request.RemoveMemberReq
+	if err := ctx.ShouldBindJSON(&form); err != nil || form.UserID == 0 {
+		res[Message] = "请求有误"
+		return
+	}
+	workspaceService := service.NewWorkspaceService()
+	err := workspaceService.RemoveMember(ctx, form.UserID)
+	if errors.Is(err, database.RecordNotFoundError) {
+		res[Message] = "成员不存在"
+		return
+	}
+	if err != nil {
+		res[Message] = "移除成员失败"
+		return
+	}
+	res[Data] = true
+	res[Message] = "移除成员成功"
+}
+
+// workspaceErrorMessage 将工作区相关的错误转换为提示信息
+func workspaceErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, service.ErrInvalidWorkspace):
+		return "工作区名称长度需要在1到64之间"
+	case errors.Is(err, service.ErrInvalidSlug):
+		return "标识只能包含小写字母、数字和短横线，长度2到64"
+	case errors.Is(err, service.ErrSlugTaken):
+		return "标识已存在"
+	case errors.Is(err, service.ErrInvalidSettings):
+		return "工作区配置有误"
+	case errors.Is(err, service.ErrSecretKeyMissing):
+		return "服务器未配置加密密钥，无法保存服务商密钥"
+	default:
+		return fallback
+	}
+}
@@ -3,31 +3,111 @@ package main
 import (
 	"context"
 	"errors"
+	"fairytale-creator/command"
 	"fairytale-creator/database"
+	"fairytale-creator/flag"
 	"fairytale-creator/handler"
 	"fairytale-creator/logger"
+	"fairytale-creator/modelapi"
+	"fairytale-creator/service"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/memstore"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	flag.Parse()
+
+	// 调用服务商的限流、重试和熔断，子命令同样使用
+	retry := modelapi.RetryPolicy{MaxRetries: flag.ProviderMaxRetries, BaseDelay: flag.ProviderRetryDelay, MaxDelay: flag.ProviderMaxRetryDelay}
+	breaker := modelapi.BreakerPolicy{FailureThreshold: flag.BreakerFailures, OpenTimeout: flag.BreakerOpenTimeout}
+	if err := modelapi.ConfigureOutbound(flag.ProviderLimits, retry, breaker); err != nil {
+		logger.Error("服务商限流配置有误：", err.Error())
+		os.Exit(1)
+	}
+
+	if err := service.ValidateKeyTemplates(); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	// 初始化数据库
-	database.Init()
+	if err := database.Init(); err != nil {
+		os.Exit(1)
+	}
+
+	// migrate 子命令自行管理迁移，服务和其他子命令（如 gc）都需要最新的表结构
+	if len(flag.Args()) == 0 || flag.Args()[0] != "migrate" {
+		migrate := database.RequireMigrated
+		if flag.MigrateOnStart {
+			migrate = database.MigrateUp
+		}
+		if err := migrate(); err != nil {
+			os.Exit(1)
+		}
+	}
+
+	// 带子命令时执行命令后退出，如 gc
+	if len(flag.Args()) > 0 {
+		if err := command.Run(flag.Args()); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Username != "" || flag.Password != "" {
+		userService := service.NewUserService()
+		if err := userService.BootstrapLegacyAdmin(flag.Username, flag.Password); err != nil {
+			logger.Error("创建管理员失败：", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// 以MySQL为准时新故事的id不能与D1中已有的故事重复，无法确认时不启动
+	if flag.StoryStore == "mysql" {
+		if err := service.NewSyncService().SeedIDs(); err != nil {
+			logger.Error("设置故事id起点失败：", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if err := service.InitRateLimit(); err != nil {
+		logger.Error("初始化限流失败：", err.Error())
+		os.Exit(1)
+	}
 
 	r := gin.Default()
+	// 只信任来自这些代理的 X-Forwarded-For，用于确定限流使用的客户端IP
+	var proxies []string
+	if flag.TrustedProxies != "" {
+		proxies = strings.Split(flag.TrustedProxies, ",")
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		logger.Error("可信代理配置有误：", err.Error())
+		os.Exit(1)
+	}
 
-	// 创建一个基于内存的存储对象
-	store := memstore.NewStore([]byte("secret"))
-	// 使用 sessions 中间件，并将内存存储对象传递给它
+	// 会话保存在数据库中，多个实例共享
+	store := service.NewSessionStore()
 	r.Use(sessions.Sessions("session", store))
 	handler.Init(r)
+
+	// MySQL为准时，后台把变更同步到D1
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	if flag.StoryStore == "mysql" {
+		go service.NewSyncService().StartSyncWorker(syncCtx)
+	}
+	sessionService := service.NewSessionService()
+	go sessionService.StartSessionCleanup(syncCtx)
+
 	srv := &http.Server{
 		//0.0.0.0:8080
 		Addr:    "127.0.0.1:9700",
@@ -42,7 +122,7 @@ func main() {
 	}()
 
 	// 优雅关机
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGINT)
 	sig := <-c
 	logger.Log("[main],app stopping,receive:", sig.String())
@@ -1,17 +1,65 @@
 package middleware
 
 import (
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-gonic/gin"
+	"errors"
+	"fairytale-creator/service"
 	"net/http"
+
+	"github.com/gin-gonic/gin"
 )
 
+// LoginAuth 认证请求，支持会话、API Key（Authorization: Bearer fc_... 或 X-API-Key）和JWT访问令牌，
+// 并把当前用户和有效角色放入上下文，见 service.CurrentUser 和 service.CurrentRole
 func LoginAuth(c *gin.Context) {
-	session := sessions.Default(c)
-	login := session.Get("login")
-	if login == nil || !login.(bool) {
-		c.JSON(http.StatusUnauthorized, gin.H{"data": false, "message": "请登录后再试"})
-		c.Abort()
-		return
+	err := service.Authenticate(c)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUnauthenticated):
+		abort(c, http.StatusUnauthorized, "请登录后再试")
+	case errors.Is(err, service.ErrInvalidCredential):
+		abort(c, http.StatusUnauthorized, "凭证无效或已过期")
+	default:
+		abort(c, http.StatusInternalServerError, "认证失败")
+	}
+}
+
+// RequireRole 要求本次请求的有效角色不低于role，需放在 LoginAuth 之后
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if service.CurrentUser(c) == nil {
+			abort(c, http.StatusUnauthorized, "请登录后再试")
+			return
+		}
+		if !service.RoleAtLeast(service.CurrentRole(c), role) {
+			abort(c, http.StatusForbidden, "没有权限")
+			return
+		}
+	}
+}
+
+// SessionOnly 要求本次请求通过登录会话认证，用于修改密码、管理会话和API Key等账号操作，
+// API Key 和由它换取的访问令牌即使权限足够也不能执行，需放在 LoginAuth 之后
+func SessionOnly(c *gin.Context) {
+	if c.GetString(service.ContextAuthMethod) != service.AuthMethodSession {
+		abort(c, http.StatusForbidden, "请使用账号密码登录后操作")
+	}
+}
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"data": nil, "message": message})
+}
+
+// Workspace 确定本次请求的工作区（见 service.ResolveWorkspace），并把有效角色改为在该工作区中的角色，
+// 需放在 LoginAuth 之后、RequireRole 之前
+func Workspace(c *gin.Context) {
+	err := service.ResolveWorkspace(c)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrNotMember):
+		abort(c, http.StatusForbidden, "不是该工作区的成员")
+	case errors.Is(err, service.ErrWorkspaceMismatch):
+		abort(c, http.StatusForbidden, "凭证不属于该工作区")
+	default:
+		abort(c, http.StatusInternalServerError, "获取工作区失败")
 	}
 }
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"fairytale-creator/service"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit 按 -rate-limits 对请求限流，已登录的请求按用户或API Key计数，需放在 LoginAuth 之后，
+// LoginAuth 之前用 ClientRateLimit；未登录的请求按客户端IP计数。超出时返回429和 Retry-After
+func RateLimit(c *gin.Context) {
+	if wait := service.TakeRateLimit(c); wait > 0 {
+		SetRetryAfter(c, wait)
+		abort(c, http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+	}
+}
+
+// ClientRateLimit 按 -client-rate-limit 和客户端IP限流，放在 LoginAuth 之前，
+// 携带无效凭证的请求在认证之前就会被拦下，不会无限次查询数据库
+func ClientRateLimit(c *gin.Context) {
+	if wait := service.TakeClientRateLimit(c); wait > 0 {
+		SetRetryAfter(c, wait)
+		abort(c, http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+	}
+}
+
+// SetRetryAfter 设置 Retry-After 响应头，按秒向上取整，至少为1秒
+func SetRetryAfter(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Max(1, math.Ceil(wait.Seconds())))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+}
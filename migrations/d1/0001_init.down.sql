@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS chapter;
+DROP TABLE IF EXISTS story;
@@ -1,4 +1,5 @@
-CREATE TABLE story (
+-- 基线：原 d1.sql 的表结构，时间列保存Unix秒数。已有数据库执行时因 IF NOT EXISTS 不会改动
+CREATE TABLE IF NOT EXISTS story (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   created_at INTEGER,
   updated_at INTEGER,
@@ -11,7 +12,7 @@ CREATE TABLE story (
 );
 CREATE INDEX IF NOT EXISTS idx_story_deleted_at ON story (deleted_at);
 
-CREATE TABLE chapter (
+CREATE TABLE IF NOT EXISTS chapter (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   created_at INTEGER,
   updated_at INTEGER,
@@ -24,4 +25,4 @@ CREATE TABLE chapter (
   voice_path TEXT NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_chapter_deleted_at ON chapter (deleted_at);
-CREATE INDEX IF NOT EXISTS idx_chapter_story_id ON chapter (story_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_chapter_story_id ON chapter (story_id);
@@ -0,0 +1,4 @@
+-- 章节音频的Opus、AAC版本和时长
+ALTER TABLE chapter ADD COLUMN voice_opus_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN voice_aac_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE chapter DROP COLUMN image_mime;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uk_chapter_story_number;
+ALTER TABLE chapter DROP COLUMN chapter_number;
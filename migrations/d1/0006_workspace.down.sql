@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_story_workspace_id;
+ALTER TABLE story DROP COLUMN workspace_id;
@@ -0,0 +1,3 @@
+-- 故事按工作区划分，已有故事归入默认工作区
+ALTER TABLE story ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_story_workspace_id ON story (workspace_id);
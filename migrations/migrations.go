@@ -0,0 +1,12 @@
+// Package migrations 内嵌数据库迁移文件，是表结构的唯一来源。
+// 文件名格式为 <版本号>_<名称>.up.sql / .down.sql，每种数据库一个目录：mysql、sqlite、d1。
+// D1 也是SQLite，但时间列保存Unix秒数，与GORM写入本地SQLite的格式不同，因此单独一个目录；
+// D1 只保存同步过去的故事和章节，用户等表没有对应的迁移，版本号与另外两个目录各自编号。
+// 0001 是引入迁移前的原始表结构，之后每次改表单独一个迁移，按加入的先后编号。
+// mysql 目录的迁移使用窗口函数（如 0007 的 ROW_NUMBER），需要 MySQL 8.0 及以上
+package migrations
+
+import "embed"
+
+//go:embed mysql/*.sql sqlite/*.sql d1/*.sql
+var FS embed.FS
@@ -0,0 +1,30 @@
+-- 基线：与原先 AutoMigrate 建出的 story、chapter 表一致，已有数据库执行时因 IF NOT EXISTS 不会改动
+CREATE TABLE IF NOT EXISTS story (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  title LONGTEXT NOT NULL,
+  author LONGTEXT NOT NULL,
+  description LONGTEXT NOT NULL,
+  music_style LONGTEXT NOT NULL,
+  status BIGINT NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_story_deleted_at (deleted_at)
+);
+
+CREATE TABLE IF NOT EXISTS chapter (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  story_id BIGINT UNSIGNED NOT NULL,
+  title LONGTEXT NOT NULL,
+  content LONGTEXT NOT NULL,
+  image_prompt LONGTEXT NOT NULL,
+  image_path LONGTEXT NOT NULL,
+  voice_path LONGTEXT NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_chapter_deleted_at (deleted_at),
+  INDEX idx_chapter_story_id (story_id)
+);
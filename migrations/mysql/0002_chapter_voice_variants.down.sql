@@ -0,0 +1,3 @@
+ALTER TABLE chapter DROP COLUMN duration_ms;
+ALTER TABLE chapter DROP COLUMN voice_aac_path;
+ALTER TABLE chapter DROP COLUMN voice_opus_path;
@@ -0,0 +1,4 @@
+-- 章节音频的Opus、AAC版本和时长
+ALTER TABLE chapter ADD COLUMN voice_opus_path VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN voice_aac_path VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN duration_ms BIGINT NOT NULL DEFAULT 0;
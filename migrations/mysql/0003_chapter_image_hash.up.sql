@@ -0,0 +1,4 @@
+-- 归档图片的内容哈希和尺寸
+ALTER TABLE chapter ADD COLUMN image_hash VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN image_width BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE chapter ADD COLUMN image_height BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,17 @@
+-- 故事生成的每个文件（图片、音频及其派生版本）在本地和R2中的位置，删除故事时据此清理
+CREATE TABLE IF NOT EXISTS asset (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  story_id BIGINT UNSIGNED NOT NULL,
+  chapter_id BIGINT UNSIGNED NOT NULL,
+  kind VARCHAR(32) NOT NULL,
+  local_path VARCHAR(1024) NOT NULL,
+  object_key VARCHAR(512) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_asset_deleted_at (deleted_at),
+  INDEX idx_asset_story_id (story_id),
+  INDEX idx_asset_chapter_id (chapter_id),
+  INDEX idx_asset_object_key (object_key)
+);
@@ -0,0 +1,17 @@
+-- 待同步到D1的故事变更，与故事的写入在同一事务中记录，由后台任务重试直到成功
+CREATE TABLE IF NOT EXISTS outbox (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  entity VARCHAR(32) NOT NULL,
+  entity_id BIGINT UNSIGNED NOT NULL,
+  attempts BIGINT NOT NULL DEFAULT 0,
+  next_attempt_at DATETIME(3) NOT NULL,
+  last_error TEXT NOT NULL,
+  done_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  INDEX idx_outbox_deleted_at (deleted_at),
+  INDEX idx_outbox_next_attempt_at (next_attempt_at),
+  INDEX idx_outbox_done_at (done_at)
+);
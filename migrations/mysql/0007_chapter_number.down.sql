@@ -0,0 +1,2 @@
+DROP INDEX uk_chapter_story_number ON chapter;
+ALTER TABLE chapter DROP COLUMN chapter_number;
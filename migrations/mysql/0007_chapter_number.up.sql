@@ -0,0 +1,8 @@
+-- 章节序号：未删除的章节按id顺序从1编号，已删除的章节使用 -id，保证 (story_id, chapter_number) 唯一
+-- ROW_NUMBER 需要 MySQL 8.0 及以上
+ALTER TABLE chapter ADD COLUMN chapter_number BIGINT NOT NULL DEFAULT 0;
+UPDATE chapter c JOIN (
+  SELECT id, ROW_NUMBER() OVER (PARTITION BY story_id ORDER BY id) AS n FROM chapter WHERE deleted_at IS NULL
+) r ON c.id = r.id SET c.chapter_number = r.n;
+UPDATE chapter SET chapter_number = -id WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX uk_chapter_story_number ON chapter (story_id, chapter_number);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS user (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  username VARCHAR(64) NOT NULL,
+  password_hash VARCHAR(255) NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_user_deleted_at (deleted_at),
+  UNIQUE INDEX uk_user_username (username)
+);
+
+-- 邀请和重置密码的令牌，只保存令牌的SHA-256
+CREATE TABLE IF NOT EXISTS user_token (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  kind VARCHAR(16) NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  user_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  role VARCHAR(16) NOT NULL DEFAULT '',
+  created_by BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  expires_at DATETIME(3) NOT NULL,
+  used_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  INDEX idx_user_token_deleted_at (deleted_at),
+  UNIQUE INDEX uk_user_token_hash (token_hash)
+);
@@ -0,0 +1,19 @@
+-- 登录会话，Cookie中只保存签名后的会话令牌，这里保存令牌的SHA-256和会话数据
+CREATE TABLE IF NOT EXISTS user_session (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  token_hash CHAR(64) NOT NULL,
+  user_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  data BLOB NULL,
+  ip VARCHAR(64) NOT NULL DEFAULT '',
+  user_agent VARCHAR(255) NOT NULL DEFAULT '',
+  last_seen_at DATETIME(3) NOT NULL,
+  expires_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_user_session_deleted_at (deleted_at),
+  UNIQUE INDEX uk_user_session_token_hash (token_hash),
+  INDEX idx_user_session_user_id (user_id),
+  INDEX idx_user_session_expires_at (expires_at)
+);
@@ -0,0 +1,18 @@
+-- 机器客户端使用的API Key，只保存密钥的SHA-256，删除即撤销
+CREATE TABLE IF NOT EXISTS api_key (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  user_id BIGINT UNSIGNED NOT NULL,
+  name VARCHAR(64) NOT NULL DEFAULT '',
+  prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL,
+  scope VARCHAR(16) NOT NULL,
+  expires_at DATETIME(3) NULL,
+  last_used_at DATETIME(3) NULL,
+  PRIMARY KEY (id),
+  INDEX idx_api_key_deleted_at (deleted_at),
+  UNIQUE INDEX uk_api_key_hash (key_hash),
+  INDEX idx_api_key_user_id (user_id)
+);
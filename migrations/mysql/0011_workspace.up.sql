@@ -0,0 +1,43 @@
+-- 工作区：故事、主题、画风、选角和API Key按工作区隔离，服务商密钥和配额可覆盖全局参数
+CREATE TABLE IF NOT EXISTS workspace (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  deleted_at DATETIME(3) NULL,
+  name VARCHAR(64) NOT NULL,
+  slug VARCHAR(64) NOT NULL,
+  themes TEXT NULL,
+  styles TEXT NULL,
+  voice_casting TEXT NULL,
+  daily_story_quota INT NOT NULL DEFAULT 0,
+  deepseek_api_key VARCHAR(255) NOT NULL DEFAULT '',
+  doubao_seedream_api_key VARCHAR(255) NOT NULL DEFAULT '',
+  cosy_voice_api_key VARCHAR(255) NOT NULL DEFAULT '',
+  jimeng_access_key_id VARCHAR(255) NOT NULL DEFAULT '',
+  jimeng_secret_access_key VARCHAR(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (id),
+  INDEX idx_workspace_deleted_at (deleted_at),
+  UNIQUE INDEX uk_workspace_slug (slug)
+);
+
+CREATE TABLE IF NOT EXISTS workspace_member (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  updated_at DATETIME(3) NULL,
+  workspace_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT UNSIGNED NOT NULL,
+  role VARCHAR(16) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE INDEX uk_workspace_member (workspace_id, user_id),
+  INDEX idx_workspace_member_user_id (user_id)
+);
+
+-- 已有数据归入默认工作区，已有用户以原角色加入
+INSERT INTO workspace (id, created_at, updated_at, name, slug) VALUES (1, NOW(3), NOW(3), 'default', 'default');
+INSERT INTO workspace_member (created_at, updated_at, workspace_id, user_id, role)
+  SELECT NOW(3), NOW(3), 1, id, role FROM user WHERE deleted_at IS NULL;
+
+ALTER TABLE story ADD COLUMN workspace_id BIGINT UNSIGNED NOT NULL DEFAULT 1;
+CREATE INDEX idx_story_workspace_id ON story (workspace_id);
+ALTER TABLE api_key ADD COLUMN workspace_id BIGINT UNSIGNED NOT NULL DEFAULT 1;
+ALTER TABLE user_token ADD COLUMN workspace_id BIGINT UNSIGNED NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+ALTER TABLE workspace DROP COLUMN monthly_cost_quota;
+ALTER TABLE workspace DROP COLUMN monthly_story_quota;
+DROP TABLE IF EXISTS usage_record;
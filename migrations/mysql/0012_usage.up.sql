@@ -0,0 +1,21 @@
+-- 调用服务商的用量和估算费用，生成任务本身也记一条（operation = story），用于配额统计
+CREATE TABLE IF NOT EXISTS usage_record (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+  created_at DATETIME(3) NULL,
+  workspace_id BIGINT UNSIGNED NOT NULL,
+  user_id BIGINT UNSIGNED NOT NULL,
+  job_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  story_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  provider VARCHAR(32) NOT NULL DEFAULT '',
+  operation VARCHAR(16) NOT NULL,
+  unit VARCHAR(16) NOT NULL DEFAULT '',
+  units BIGINT NOT NULL DEFAULT 0,
+  cost DECIMAL(14,6) NOT NULL DEFAULT 0,
+  PRIMARY KEY (id),
+  INDEX idx_usage_record_workspace (workspace_id, created_at),
+  INDEX idx_usage_record_user (user_id, created_at),
+  INDEX idx_usage_record_job_id (job_id)
+);
+
+ALTER TABLE workspace ADD COLUMN monthly_story_quota INT NOT NULL DEFAULT 0;
+ALTER TABLE workspace ADD COLUMN monthly_cost_quota DECIMAL(14,6) NOT NULL DEFAULT 0;
@@ -0,0 +1,9 @@
+-- 限流令牌桶，-rate-limit-store 为 db 时多个实例共享
+CREATE TABLE IF NOT EXISTS rate_limit_bucket (
+  bucket_key VARCHAR(191) NOT NULL,
+  tokens DOUBLE NOT NULL,
+  updated_at DATETIME(3) NOT NULL,
+  full_at DATETIME(3) NOT NULL,
+  PRIMARY KEY (bucket_key),
+  INDEX idx_rate_limit_bucket_full_at (full_at)
+);
@@ -0,0 +1,2 @@
+-- 记录生成每个文件的服务商，主服务商熔断改用备用服务商时可以区分
+ALTER TABLE asset ADD COLUMN provider VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,28 @@
+-- 基线：本地SQLite，GORM以DATETIME文本保存时间。已有数据库执行时因 IF NOT EXISTS 不会改动
+CREATE TABLE IF NOT EXISTS story (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  title TEXT NOT NULL,
+  author TEXT NOT NULL,
+  description TEXT NOT NULL,
+  music_style TEXT NOT NULL,
+  status INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_story_deleted_at ON story (deleted_at);
+
+CREATE TABLE IF NOT EXISTS chapter (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  story_id INTEGER NOT NULL,
+  title TEXT NOT NULL,
+  content TEXT NOT NULL,
+  image_prompt TEXT NOT NULL,
+  image_path TEXT NOT NULL,
+  voice_path TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_chapter_deleted_at ON chapter (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_chapter_story_id ON chapter (story_id);
@@ -0,0 +1,3 @@
+ALTER TABLE chapter DROP COLUMN image_height;
+ALTER TABLE chapter DROP COLUMN image_width;
+ALTER TABLE chapter DROP COLUMN image_hash;
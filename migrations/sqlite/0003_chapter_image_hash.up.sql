@@ -0,0 +1,4 @@
+-- 归档图片的内容哈希和尺寸
+ALTER TABLE chapter ADD COLUMN image_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE chapter ADD COLUMN image_width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chapter ADD COLUMN image_height INTEGER NOT NULL DEFAULT 0;
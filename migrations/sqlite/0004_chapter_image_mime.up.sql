@@ -0,0 +1,2 @@
+-- 保存归档时根据内容判断的图片MIME类型
+ALTER TABLE chapter ADD COLUMN image_mime TEXT NOT NULL DEFAULT '';
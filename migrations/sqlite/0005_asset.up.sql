@@ -0,0 +1,16 @@
+-- 故事生成的每个文件（图片、音频及其派生版本）在本地和R2中的位置，删除故事时据此清理
+CREATE TABLE IF NOT EXISTS asset (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  story_id INTEGER NOT NULL,
+  chapter_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  local_path TEXT NOT NULL,
+  object_key TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_asset_deleted_at ON asset (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_asset_story_id ON asset (story_id);
+CREATE INDEX IF NOT EXISTS idx_asset_chapter_id ON asset (chapter_id);
+CREATE INDEX IF NOT EXISTS idx_asset_object_key ON asset (object_key);
@@ -0,0 +1,16 @@
+-- 待同步到D1的故事变更，与故事的写入在同一事务中记录，由后台任务重试直到成功
+CREATE TABLE IF NOT EXISTS outbox (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  entity TEXT NOT NULL,
+  entity_id INTEGER NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at DATETIME NOT NULL,
+  last_error TEXT NOT NULL DEFAULT '',
+  done_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_deleted_at ON outbox (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt_at ON outbox (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_done_at ON outbox (done_at);
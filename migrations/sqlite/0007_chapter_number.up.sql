@@ -0,0 +1,7 @@
+-- 章节序号：未删除的章节按id顺序从1编号，已删除的章节使用 -id，保证 (story_id, chapter_number) 唯一
+ALTER TABLE chapter ADD COLUMN chapter_number INTEGER NOT NULL DEFAULT 0;
+UPDATE chapter SET chapter_number = (
+  SELECT COUNT(*) FROM chapter c2 WHERE c2.story_id = chapter.story_id AND c2.deleted_at IS NULL AND c2.id <= chapter.id
+) WHERE deleted_at IS NULL;
+UPDATE chapter SET chapter_number = -id WHERE deleted_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS uk_chapter_story_number ON chapter (story_id, chapter_number);
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS user (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  username TEXT NOT NULL,
+  password_hash TEXT NOT NULL,
+  role TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_deleted_at ON user (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_user_username ON user (username);
+
+-- 邀请和重置密码的令牌，只保存令牌的SHA-256
+CREATE TABLE IF NOT EXISTS user_token (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  kind TEXT NOT NULL,
+  token_hash TEXT NOT NULL,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  role TEXT NOT NULL DEFAULT '',
+  created_by INTEGER NOT NULL DEFAULT 0,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_user_token_deleted_at ON user_token (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_user_token_hash ON user_token (token_hash);
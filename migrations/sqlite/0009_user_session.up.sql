@@ -0,0 +1,18 @@
+-- 登录会话，Cookie中只保存签名后的会话令牌，这里保存令牌的SHA-256和会话数据
+CREATE TABLE IF NOT EXISTS user_session (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  token_hash TEXT NOT NULL,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  data BLOB,
+  ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  last_seen_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_session_deleted_at ON user_session (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_user_session_token_hash ON user_session (token_hash);
+CREATE INDEX IF NOT EXISTS idx_user_session_user_id ON user_session (user_id);
+CREATE INDEX IF NOT EXISTS idx_user_session_expires_at ON user_session (expires_at);
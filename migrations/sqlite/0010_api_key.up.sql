@@ -0,0 +1,17 @@
+-- 机器客户端使用的API Key，只保存密钥的SHA-256，删除即撤销
+CREATE TABLE IF NOT EXISTS api_key (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  expires_at DATETIME,
+  last_used_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_api_key_deleted_at ON api_key (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_api_key_hash ON api_key (key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_key_user_id ON api_key (user_id);
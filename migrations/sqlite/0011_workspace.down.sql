@@ -0,0 +1,6 @@
+ALTER TABLE user_token DROP COLUMN workspace_id;
+ALTER TABLE api_key DROP COLUMN workspace_id;
+DROP INDEX IF EXISTS idx_story_workspace_id;
+ALTER TABLE story DROP COLUMN workspace_id;
+DROP TABLE IF EXISTS workspace_member;
+DROP TABLE IF EXISTS workspace;
@@ -0,0 +1,41 @@
+-- 工作区：故事、主题、画风、选角和API Key按工作区隔离，服务商密钥和配额可覆盖全局参数
+CREATE TABLE IF NOT EXISTS workspace (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  deleted_at DATETIME,
+  name TEXT NOT NULL,
+  slug TEXT NOT NULL,
+  themes TEXT,
+  styles TEXT,
+  voice_casting TEXT,
+  daily_story_quota INTEGER NOT NULL DEFAULT 0,
+  deepseek_api_key TEXT NOT NULL DEFAULT '',
+  doubao_seedream_api_key TEXT NOT NULL DEFAULT '',
+  cosy_voice_api_key TEXT NOT NULL DEFAULT '',
+  jimeng_access_key_id TEXT NOT NULL DEFAULT '',
+  jimeng_secret_access_key TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_deleted_at ON workspace (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_workspace_slug ON workspace (slug);
+
+CREATE TABLE IF NOT EXISTS workspace_member (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  updated_at DATETIME,
+  workspace_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  role TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uk_workspace_member ON workspace_member (workspace_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_workspace_member_user_id ON workspace_member (user_id);
+
+-- 已有数据归入默认工作区，已有用户以原角色加入
+INSERT INTO workspace (id, created_at, updated_at, name, slug) VALUES (1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'default', 'default');
+INSERT INTO workspace_member (created_at, updated_at, workspace_id, user_id, role)
+  SELECT CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 1, id, role FROM user WHERE deleted_at IS NULL;
+
+ALTER TABLE story ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_story_workspace_id ON story (workspace_id);
+ALTER TABLE api_key ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE user_token ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,6 @@
+ALTER TABLE workspace DROP COLUMN monthly_cost_quota;
+ALTER TABLE workspace DROP COLUMN monthly_story_quota;
+DROP INDEX IF EXISTS idx_usage_record_job_id;
+DROP INDEX IF EXISTS idx_usage_record_user;
+DROP INDEX IF EXISTS idx_usage_record_workspace;
+DROP TABLE IF EXISTS usage_record;
@@ -0,0 +1,20 @@
+-- 调用服务商的用量和估算费用，生成任务本身也记一条（operation = story），用于配额统计
+CREATE TABLE IF NOT EXISTS usage_record (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME,
+  workspace_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  job_id INTEGER NOT NULL DEFAULT 0,
+  story_id INTEGER NOT NULL DEFAULT 0,
+  provider TEXT NOT NULL DEFAULT '',
+  operation TEXT NOT NULL,
+  unit TEXT NOT NULL DEFAULT '',
+  units INTEGER NOT NULL DEFAULT 0,
+  cost REAL NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_usage_record_workspace ON usage_record (workspace_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_record_user ON usage_record (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_record_job_id ON usage_record (job_id);
+
+ALTER TABLE workspace ADD COLUMN monthly_story_quota INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE workspace ADD COLUMN monthly_cost_quota REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,8 @@
+-- 限流令牌桶，-rate-limit-store 为 db 时多个实例共享
+CREATE TABLE IF NOT EXISTS rate_limit_bucket (
+  bucket_key TEXT NOT NULL PRIMARY KEY,
+  tokens REAL NOT NULL,
+  updated_at DATETIME NOT NULL,
+  full_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_bucket_full_at ON rate_limit_bucket (full_at);
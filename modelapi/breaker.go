@@ -0,0 +1,141 @@
+package modelapi
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+var (
+	// ErrCircuitOpen 服务商的熔断器处于打开状态，请求未发出
+	ErrCircuitOpen = errors.New("circuit open")
+	// ErrProviderUnavailable 服务商持续限流、出错或不可达，重试用尽
+	ErrProviderUnavailable = errors.New("provider unavailable")
+)
+
+// IsUnavailable 服务商当前不可用（熔断或重试用尽），可以改用备用服务商
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrProviderUnavailable)
+}
+
+// BreakerPolicy 连续 FailureThreshold 次失败后打开熔断器，OpenTimeout 后放行一个探测请求，
+// 探测成功则关闭，失败则重新打开。FailureThreshold 为0时不熔断
+type BreakerPolicy struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// DefaultBreakerPolicy 未调用 ConfigureBreakers 时的熔断策略
+var DefaultBreakerPolicy = BreakerPolicy{FailureThreshold: 5, OpenTimeout: 30 * time.Second}
+
+// Breaker 一个服务商接口的熔断器，只有5xx、超时和网络错误重试用尽计为失败
+type Breaker struct {
+	policy    BreakerPolicy
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+func newBreaker(policy BreakerPolicy) *Breaker {
+	return &Breaker{policy: policy, state: BreakerClosed}
+}
+
+// allow 判断是否可以发出请求，打开状态超过 OpenTimeout 后转为半开并只放行一个探测请求
+func (b *Breaker) allow() error {
+	if b.policy.FailureThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+	}
+	b.probing = b.state == BreakerHalfOpen
+	return nil
+}
+
+// record 记录一次调用的结果，err为nil表示服务商可用
+func (b *Breaker) record(err error) {
+	if b.policy.FailureThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.policy.FailureThreshold {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// cancel 请求因ctx结束而中止，不计入结果，半开状态下允许再次探测
+func (b *Breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// BreakerStatus 熔断器的当前状态，用于健康检查
+type BreakerStatus struct {
+	Provider  string     `json:"provider"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`            // 连续失败次数
+	OpenedAt  *time.Time `json:"opened_at,omitempty"` // 最近一次打开的时间
+	RetryAt   *time.Time `json:"retry_at,omitempty"`  // 打开状态下放行探测请求的时间
+	LastError string     `json:"-"`                   // 最近一次失败的原因，可能含服务商返回的内容，不对外返回
+}
+
+func (b *Breaker) status(provider string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := BreakerStatus{Provider: provider, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+		if b.state == BreakerOpen {
+			retryAt := openedAt.Add(b.policy.OpenTimeout)
+			status.RetryAt = &retryAt
+		}
+	}
+	return status
+}
+
+// BreakerStatuses 返回所有服务商的熔断器状态，按服务商名称排序
+func BreakerStatuses() []BreakerStatus {
+	for _, name := range []string{ProviderDeepSeek, ProviderSeedream, ProviderJimeng, ProviderCosyVoice, ProviderVolcTTS, ProviderD1, ProviderR2} {
+		GetOutbound(name)
+	}
+	outboundMu.Lock()
+	list := make([]BreakerStatus, 0, len(outbounds))
+	for name, o := range outbounds {
+		list = append(list, o.breaker.status(name))
+	}
+	outboundMu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Provider < list[j].Provider
+	})
+	return list
+}
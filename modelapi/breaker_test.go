@@ -0,0 +1,189 @@
+package modelapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	errDown := errors.New("503")
+	type step struct {
+		action    string // allow / fail / ok / cancel / wait
+		wantErr   error  // allow 的期望结果
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "连续失败达到阈值后打开",
+			steps: []step{
+				{action: "allow", wantState: BreakerClosed},
+				{action: "fail", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+				{action: "fail", wantState: BreakerOpen},
+				{action: "allow", wantErr: ErrCircuitOpen, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "成功清零失败次数",
+			steps: []step{
+				{action: "fail", wantState: BreakerClosed},
+				{action: "ok", wantState: BreakerClosed},
+				{action: "fail", wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "超时后半开只放行一个探测请求，探测成功后关闭",
+			steps: []step{
+				{action: "fail"}, {action: "fail", wantState: BreakerOpen},
+				{action: "wait"},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "allow", wantErr: ErrCircuitOpen, wantState: BreakerHalfOpen},
+				{action: "ok", wantState: BreakerClosed},
+				{action: "allow", wantState: BreakerClosed},
+			},
+		},
+		{
+			name: "探测失败后重新打开",
+			steps: []step{
+				{action: "fail"}, {action: "fail", wantState: BreakerOpen},
+				{action: "wait"},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "fail", wantState: BreakerOpen},
+				{action: "allow", wantErr: ErrCircuitOpen, wantState: BreakerOpen},
+			},
+		},
+		{
+			name: "探测中止后允许再次探测",
+			steps: []step{
+				{action: "fail"}, {action: "fail", wantState: BreakerOpen},
+				{action: "wait"},
+				{action: "allow", wantState: BreakerHalfOpen},
+				{action: "cancel", wantState: BreakerHalfOpen},
+				{action: "allow", wantState: BreakerHalfOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(BreakerPolicy{FailureThreshold: 2, OpenTimeout: time.Minute})
+			for i, s := range tt.steps {
+				var err error
+				switch s.action {
+				case "allow":
+					err = b.allow()
+				case "fail":
+					b.record(errDown)
+				case "ok":
+					b.record(nil)
+				case "cancel":
+					b.cancel()
+				case "wait":
+					b.openedAt = b.openedAt.Add(-time.Minute)
+				}
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d %s: error = %v, want %v", i, s.action, err, s.wantErr)
+				}
+				if s.wantState != "" && b.state != s.wantState {
+					t.Fatalf("step %d %s: state = %s, want %s", i, s.action, b.state, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(BreakerPolicy{})
+	for i := 0; i < 10; i++ {
+		b.record(errors.New("503"))
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("allow() = %v, want nil when FailureThreshold is 0", err)
+	}
+}
+
+// 每次 Call 只记录一个结果，重试中的失败和限流不计入熔断
+func TestCallRecordsOneOutcome(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	unavailable := &RetryableError{Err: errors.New("503")}
+	throttled := &RetryableError{Err: errors.New("429"), Throttled: true}
+	tests := []struct {
+		name         string
+		results      []error
+		wantFailures int
+	}{
+		{"重试后成功", []error{unavailable, unavailable, nil}, 0},
+		{"重试用尽计一次失败", []error{unavailable, unavailable, unavailable}, 1},
+		{"限流重试用尽不计失败", []error{throttled, throttled, throttled}, 0},
+		{"不可重试的错误不计失败", []error{errors.New("400")}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOutbound("test", ProviderLimit{}, policy, BreakerPolicy{FailureThreshold: 5, OpenTimeout: time.Minute})
+			calls := 0
+			o.Call(context.Background(), policy, nil, func() error {
+				calls++
+				return tt.results[calls-1]
+			})
+			if got := o.breaker.status("test").Failures; got != tt.wantFailures {
+				t.Errorf("failures = %d, want %d", got, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"限流", &RetryableError{Err: errors.New("429"), Throttled: true}, true},
+		{"服务端错误", &RetryableError{Err: errors.New("503")}, false},
+		{"S3返回429", statusError(http.StatusTooManyRequests), true},
+		{"S3返回500", statusError(http.StatusInternalServerError), false},
+		{"CosyVoice限流", &CosyVoiceError{Code: "Throttling.RateQuota"}, true},
+		{"包装后的限流", errors.Join(ErrProviderUnavailable, &RetryableError{Err: errors.New("429"), Throttled: true}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isThrottled(tt.err); got != tt.want {
+				t.Errorf("isThrottled(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// statusError 带HTTP状态码的错误，与S3 SDK返回的错误一样实现 HTTPStatusCode
+type statusError int
+
+func (e statusError) Error() string {
+	return http.StatusText(int(e))
+}
+
+func (e statusError) HTTPStatusCode() int {
+	return int(e)
+}
+
+func TestBreakerStatusHidesLastError(t *testing.T) {
+	b := newBreaker(BreakerPolicy{FailureThreshold: 1, OpenTimeout: time.Minute})
+	b.record(errors.New("secret upstream body"))
+	status := b.status("test")
+	if status.State != BreakerOpen || status.RetryAt == nil {
+		t.Fatalf("status = %+v, want open with retry_at", status)
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret upstream body") {
+		t.Errorf("status JSON %s exposes the last error", data)
+	}
+}
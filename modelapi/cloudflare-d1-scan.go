@@ -0,0 +1,201 @@
+package modelapi
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ScanD1Rows 将查询结果的每一行扫描为T，T必须是结构体，列与字段的对应规则见 ScanD1Row
+func ScanD1Rows[T any](rows []map[string]interface{}) ([]T, error) {
+	items := make([]T, 0, len(rows))
+	for _, row := range rows {
+		var item T
+		if err := ScanD1Row(row, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Rows 返回第一条语句的结果行，没有结果时返回空切片
+func (r *D1QueryResponse) Rows() []map[string]interface{} {
+	if r == nil || len(r.Result) == 0 {
+		return nil
+	}
+	return r.Result[0].Results
+}
+
+// ScanD1Row 将一行结果扫描到dest指向的结构体。列名依次取自 gorm 的 column 标签、json 标签，
+// 都没有时使用字段名的蛇形形式（如 CreatedAt 对应 created_at），匿名嵌入的结构体（如 gorm.Model）会展开。
+// 结果中不存在或为NULL的列保留零值；time.Time 字段从Unix秒数或RFC3339字符串转换
+func ScanD1Row(row map[string]interface{}, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan d1 row: dest must be a pointer to struct, got %T", dest)
+	}
+	return scanStruct(row, v.Elem())
+}
+
+func scanStruct(row map[string]interface{}, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := scanStruct(row, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		column := columnName(field)
+		if column == "-" {
+			continue
+		}
+		value, ok := row[column]
+		if !ok || value == nil {
+			continue
+		}
+		if err := setField(fv, value); err != nil {
+			return fmt.Errorf("scan d1 column %s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+func columnName(field reflect.StructField) string {
+	for _, part := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if strings.HasPrefix(part, "column:") {
+			return strings.TrimPrefix(part, "column:")
+		}
+	}
+	if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return snakeCase(field.Name)
+}
+
+// snakeCase 字段名转蛇形，连续大写视为一个单词，如 ID -> id、VoiceAACPath -> voice_aac_path
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func setField(fv reflect.Value, value interface{}) error {
+	if fv.Type() == timeType {
+		t, err := toTime(value)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	// 实现了 sql.Scanner 的类型（如 gorm.DeletedAt）：数字按Unix秒数转换为时间后再交给Scan
+	if scanner, ok := fv.Addr().Interface().(sql.Scanner); ok {
+		if _, isNumber := value.(float64); isNumber {
+			if t, err := toTime(value); err == nil && scanner.Scan(t) == nil {
+				return nil
+			}
+		}
+		return scanner.Scan(value)
+	}
+	if fv.Kind() == reflect.Ptr {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setField(ptr.Elem(), value); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		switch x := value.(type) {
+		case string:
+			fv.SetString(x)
+		case float64:
+			fv.SetString(strconv.FormatFloat(x, 'f', -1, 64))
+		default:
+			return fmt.Errorf("cannot convert %T to string", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		n, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	case reflect.Bool:
+		// SQLite 没有布尔类型，以0/1存储
+		switch x := value.(type) {
+		case bool:
+			fv.SetBool(x)
+		case float64:
+			fv.SetBool(x != 0)
+		default:
+			return fmt.Errorf("cannot convert %T to bool", value)
+		}
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// toFloat JSON解码后数字均为float64，文本列中的数字也一并支持
+func toFloat(value interface{}) (float64, error) {
+	switch x := value.(type) {
+	case float64:
+		return x, nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(x, 64)
+	default:
+		return 0, fmt.Errorf("cannot convert %T to number", value)
+	}
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch x := value.(type) {
+	case float64:
+		return time.Unix(int64(x), 0), nil
+	case string:
+		return time.Parse(time.RFC3339, x)
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to time", value)
+	}
+}
@@ -1,12 +1,13 @@
 package modelapi
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"fairytale-creator/logger"
 )
@@ -55,11 +56,69 @@ type D1Result struct {
 	Success bool                     `json:"success"`
 }
 
+// DefaultD1BaseURL Cloudflare API 的默认地址，本地测试时可替换为模拟服务
+const DefaultD1BaseURL = "https://api.cloudflare.com/client/v4"
+
+// D1Error D1 API 返回失败时的错误，携带HTTP状态码和D1错误码
+type D1Error struct {
+	StatusCode int
+	Errors     []D1Message
+}
+
+func (e *D1Error) Error() string {
+	if len(e.Errors) == 0 {
+		return "d1 api error (status: " + strconv.Itoa(e.StatusCode) + ")"
+	}
+	messages := make([]string, 0, len(e.Errors))
+	for _, m := range e.Errors {
+		messages = append(messages, m.Message+" (code: "+strconv.Itoa(m.Code)+")")
+	}
+	return "d1 api error: " + strings.Join(messages, "; ")
+}
+
+// Code 返回第一个D1错误码，没有时返回0
+func (e *D1Error) Code() int {
+	if len(e.Errors) == 0 {
+		return 0
+	}
+	return e.Errors[0].Code
+}
+
+// D1ResultCountError D1 返回的结果条数与执行的语句条数不一致，此时无法按下标读取 Result
+type D1ResultCountError struct {
+	Want int
+	Got  int
+}
+
+func (e *D1ResultCountError) Error() string {
+	return "d1 returned " + strconv.Itoa(e.Got) + " results for " + strconv.Itoa(e.Want) + " statements"
+}
+
+// HasCode 判断err是否为包含指定错误码的 D1Error
+func HasCode(err error, code int) bool {
+	var d1Err *D1Error
+	if !errors.As(err, &d1Err) {
+		return false
+	}
+	for _, m := range d1Err.Errors {
+		if m.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// D1BatchRequest 在一次请求中按顺序执行多条语句
+type D1BatchRequest struct {
+	Batch []D1QueryRequest `json:"batch"`
+}
+
 // D1Client 表示 Cloudflare D1 客户端
 type D1Client struct {
 	AccountID  string
 	DatabaseID string
 	APIKey     string
+	BaseURL    string
 	HTTPClient *http.Client
 }
 
@@ -69,70 +128,92 @@ func NewD1Client(accountID, databaseID, apiKey string) *D1Client {
 		AccountID:  accountID,
 		DatabaseID: databaseID,
 		APIKey:     apiKey,
+		BaseURL:    DefaultD1BaseURL,
 		HTTPClient: &http.Client{},
 	}
 }
 
-// ExecuteQuery 执行 SQL 查询并返回结果
+// ExecuteQuery 执行一条 SQL 语句，成功时 Result 恰好有一项。多条语句使用 ExecuteBatch
 func (c *D1Client) ExecuteQuery(sql string, params []interface{}) (*D1QueryResponse, error) {
-	// 构建请求体
-	requestBody := D1QueryRequest{
-		SQL:    sql,
-		Params: params,
-	}
+	return c.ExecuteQueryContext(context.Background(), sql, params)
+}
 
-	jsonData, err := json.Marshal(requestBody)
+// ExecuteQueryContext 同 ExecuteQuery，可通过ctx取消
+func (c *D1Client) ExecuteQueryContext(ctx context.Context, sql string, params []interface{}) (*D1QueryResponse, error) {
+	response, err := c.do(ctx, D1QueryRequest{SQL: sql, Params: params})
 	if err != nil {
-		logger.Error("failed to marshal request body: " + err.Error())
 		return nil, err
 	}
+	if len(response.Result) != 1 {
+		countErr := &D1ResultCountError{Want: 1, Got: len(response.Result)}
+		logger.Error(countErr.Error())
+		return nil, countErr
+	}
+	return response, nil
+}
 
-	// 构建请求 URL
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/d1/database/%s/query",
-		c.AccountID, c.DatabaseID)
-
-	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// ExecuteBatch 在一次请求中按顺序执行多条带参数的语句，Result 与 queries 一一对应
+func (c *D1Client) ExecuteBatch(ctx context.Context, queries []D1QueryRequest) (*D1QueryResponse, error) {
+	response, err := c.do(ctx, D1BatchRequest{Batch: queries})
 	if err != nil {
-		logger.Error("failed to create request: " + err.Error())
 		return nil, err
 	}
+	if len(response.Result) != len(queries) {
+		countErr := &D1ResultCountError{Want: len(queries), Got: len(response.Result)}
+		logger.Error(countErr.Error())
+		return nil, countErr
+	}
+	return response, nil
+}
 
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-
-	// 发送请求
-	resp, err := c.HTTPClient.Do(req)
+func (c *D1Client) do(ctx context.Context, requestBody interface{}) (*D1QueryResponse, error) {
+	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		logger.Error("failed to send request: " + err.Error())
+		logger.Error("failed to marshal request body: " + err.Error())
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// 读取响应
-	body, err := io.ReadAll(resp.Body)
+	// 构建请求 URL
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultD1BaseURL
+	}
+	url := fmt.Sprintf("%s/accounts/%s/d1/database/%s/query",
+		strings.TrimSuffix(baseURL, "/"), c.AccountID, c.DatabaseID)
+
+	// 发送请求，限流和服务端错误自动重试
+	resp, err := GetOutbound(ProviderD1).Do(ctx, c.HTTPClient, NewJSONRequest(url, c.APIKey, jsonData))
 	if err != nil {
-		logger.Error("failed to read response body: " + err.Error())
+		logger.Error("failed to send request: " + err.Error())
 		return nil, err
 	}
+	body := resp.Body
 
-	// 解析响应
+	// 解析响应，非JSON的错误响应（如网关错误）只保留状态码
 	var response D1QueryResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
+		if resp.StatusCode != http.StatusOK {
+			d1Err := &D1Error{StatusCode: resp.StatusCode}
+			logger.Error(d1Err.Error())
+			return nil, d1Err
+		}
 		logger.Error("failed to unmarshal response: " + err.Error())
 		return nil, err
 	}
 
-	// 检查请求是否成功
-	if !response.Success {
-		if len(response.Errors) > 0 {
-			logger.Error("API error: " + response.Errors[0].Message + " (code: " + strconv.Itoa(response.Errors[0].Code) + ")")
-			return nil, err
+	// 检查请求和每条语句是否成功
+	if !response.Success || resp.StatusCode != http.StatusOK {
+		d1Err := &D1Error{StatusCode: resp.StatusCode, Errors: response.Errors}
+		logger.Error(d1Err.Error())
+		return nil, d1Err
+	}
+	for i, result := range response.Result {
+		if !result.Success {
+			d1Err := &D1Error{StatusCode: resp.StatusCode, Errors: []D1Message{{Message: "statement " + strconv.Itoa(i) + " failed"}}}
+			logger.Error(d1Err.Error())
+			return nil, d1Err
 		}
-		logger.Error("unknown API error")
-		return nil, err
 	}
 
 	return &response, nil
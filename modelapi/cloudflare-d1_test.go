@@ -0,0 +1,78 @@
+package modelapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"fairytale-creator/modelapi"
+	"fairytale-creator/modelapi/d1test"
+)
+
+func TestD1ClientBatch(t *testing.T) {
+	server := d1test.NewServer(t)
+	client := modelapi.NewD1Client("account", "database", "key")
+	client.BaseURL = server.URL
+	if _, err := client.ExecuteQuery("CREATE TABLE item (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, created_at INTEGER)", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		batch     []modelapi.D1QueryRequest
+		wantErr   bool
+		wantCount int
+	}{
+		{
+			name: "按顺序执行并返回每条语句的结果",
+			batch: []modelapi.D1QueryRequest{
+				{SQL: "INSERT INTO item (name, created_at) VALUES (?, ?)", Params: []interface{}{"a", 1700000000}},
+				{SQL: "INSERT INTO item (name, created_at) VALUES (?, ?)", Params: []interface{}{"b", 1700000000}},
+			},
+			wantCount: 2,
+		},
+		{
+			name: "任一语句失败整体回滚",
+			batch: []modelapi.D1QueryRequest{
+				{SQL: "INSERT INTO item (name) VALUES (?)", Params: []interface{}{"c"}},
+				{SQL: "INSERT INTO item (name) VALUES (NULL)"},
+			},
+			wantErr:   true,
+			wantCount: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := client.ExecuteBatch(context.Background(), tt.batch)
+			var d1Err *modelapi.D1Error
+			if tt.wantErr != errors.As(err, &d1Err) {
+				t.Fatalf("ExecuteBatch() error = %v, want D1Error %v", err, tt.wantErr)
+			}
+			if err == nil && (len(response.Result) != len(tt.batch) || response.Result[1].Meta.LastRowID != 2) {
+				t.Errorf("ExecuteBatch() results = %+v", response.Result)
+			}
+			count, err := client.ExecuteQuery("SELECT COUNT(*) AS n FROM item", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := count.Rows()[0]["n"]; n != float64(tt.wantCount) {
+				t.Errorf("item count = %v, want %d", n, tt.wantCount)
+			}
+		})
+	}
+
+	response, err := client.ExecuteQuery("SELECT id, name, created_at FROM item ORDER BY id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type item struct {
+		ID        uint
+		Name      string
+		CreatedAt time.Time
+	}
+	items, err := modelapi.ScanD1Rows[item](response.Rows())
+	if err != nil || len(items) != 2 || items[1].Name != "b" || !items[1].CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("ScanD1Rows() = %+v, %v", items, err)
+	}
+}
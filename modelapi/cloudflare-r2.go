@@ -1,28 +1,44 @@
 package modelapi
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fairytale-creator/logger"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-// R2Uploader 封装了Cloudflare R2上传和预签名URL生成功能
+var (
+	ErrChecksumMismatch = errors.New("uploaded object checksum mismatch")
+	ErrSizeMismatch     = errors.New("uploaded object size mismatch")
+)
+
+// R2Uploader 封装了Cloudflare R2上传和预签名URL生成功能，所有请求都经过R2的出站客户端
 type R2Uploader struct {
 	client     *s3.Client
+	uploader   *manager.Uploader
 	bucketName string
+	HttpClient *http.Client  // UploadFromURL 下载源文件使用的客户端
+	MaxRetries int           // 上传失败后的重试次数
+	RetryDelay time.Duration // 首次重试的等待时间，之后指数增长并加随机抖动
 }
 
 // NewR2Uploader 创建一个新的R2上传器实例
@@ -41,97 +57,275 @@ func NewR2Uploader(accountID, accessKeyID, accessKeySecret, bucketName string) (
 		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountID))
 	})
 
+	// 超过PartSize的对象自动走分片上传，边读边传，不会把整个文件读入内存
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = 16 * 1024 * 1024
+		u.Concurrency = 4
+	})
+
 	return &R2Uploader{
 		client:     client,
+		uploader:   uploader,
 		bucketName: bucketName,
+		HttpClient: &http.Client{Timeout: 180 * time.Second},
+		MaxRetries: 3,
+		RetryDelay: time.Second,
 	}, nil
 }
 
-// UploadFromLocalFile 从本地文件上传到R2
-func (u *R2Uploader) UploadFromLocalFile(localFilePath, objectKey string) error {
-	// 打开本地文件
-	file, err := os.Open(localFilePath)
-	if err != nil {
-		logger.Error("failed to open local file: " + err.Error())
-		return fmt.Errorf("failed to open local file: %v", err)
-	}
-	defer file.Close()
+// UploadFromLocalFile 从本地文件上传到R2，失败时重新打开文件重试
+func (u *R2Uploader) UploadFromLocalFile(ctx context.Context, localFilePath, objectKey string) error {
+	contentType := u.getContentTypeFromExtension(objectKey)
+	return u.withRetry(ctx, objectKey, func() error {
+		file, err := os.Open(localFilePath)
+		if err != nil {
+			return permanent(fmt.Errorf("failed to open local file: %v", err))
+		}
+		defer file.Close()
+		return u.uploadStream(ctx, file, objectKey, contentType)
+	})
+}
 
-	// 获取文件信息以检查文件大小等（可选）
-	fileInfo, err := file.Stat()
-	if err != nil {
-		logger.Error("failed to get file info: " + err.Error())
-		return fmt.Errorf("failed to get file info: %v", err)
-	}
+// UploadFromURL 从URL流式下载并上传到R2，失败时重新下载重试
+func (u *R2Uploader) UploadFromURL(ctx context.Context, sourceURL, objectKey string) error {
+	return u.withRetry(ctx, objectKey, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+		if err != nil {
+			return permanent(err)
+		}
+		resp, err := u.HttpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to download: %v", err)
+		}
+		defer resp.Body.Close()
 
-	// 根据文件扩展名获取内容类型
-	contentType := u.getContentTypeFromExtension(objectKey)
+		if resp.StatusCode != http.StatusOK {
+			err := errors.New("failed to download, status code: " + strconv.Itoa(resp.StatusCode))
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return permanent(err)
+			}
+			return err
+		}
+
+		// 获取内容类型
+		contentType := resp.Header.Get("Content-Type")
+		if contentType == "" {
+			// 根据文件扩展名猜测内容类型
+			contentType = u.getContentTypeFromExtension(objectKey)
+		}
+		return u.uploadStream(ctx, resp.Body, objectKey, contentType)
+	})
+}
+
+// UploadStream 流式上传，大对象自动分片。上传时同步计算CRC32，
+// 完成后与R2返回的校验和比对；分片上传返回的是组合校验和，改为比对对象大小。
+// body只会被读取一次，因此不做重试，需要重试时使用 UploadFromLocalFile 或 UploadFromURL
+func (u *R2Uploader) UploadStream(ctx context.Context, body io.Reader, objectKey, contentType string) error {
+	return u.call(ctx, 0, func() error {
+		return u.uploadStream(ctx, body, objectKey, contentType)
+	})
+}
 
-	// 上传到R2
-	_, err = u.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:        aws.String(u.bucketName),
-		Key:           aws.String(objectKey),
-		Body:          file,
-		ContentType:   aws.String(contentType),
-		ContentLength: aws.Int64(fileInfo.Size()), // 可选，但推荐提供
+// uploadStream 上传一次，调用方需已占用R2的出站额度
+func (u *R2Uploader) uploadStream(ctx context.Context, body io.Reader, objectKey, contentType string) error {
+	reader := &checksumReader{reader: body, hash: crc32.NewIEEE()}
+	output, err := u.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:            aws.String(u.bucketName),
+		Key:               aws.String(objectKey),
+		Body:              reader,
+		ContentType:       aws.String(contentType),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
 	})
 	if err != nil {
-		logger.Error("failed to upload to R2: " + err.Error())
-		return fmt.Errorf("failed to upload to R2: %v", err)
+		return fmt.Errorf("failed to upload to R2: %w", err)
 	}
 
-	logger.Log("Successfully uploaded %s to R2 bucket %s", objectKey, u.bucketName)
+	if err := u.verifyUpload(ctx, objectKey, output, reader); err != nil {
+		return err
+	}
+	logger.Log("Successfully uploaded", objectKey, "to R2 bucket", u.bucketName, "size", strconv.FormatInt(reader.size, 10))
 	return nil
 }
 
-// UploadFromURL 从图片URL下载并上传到R2
-func (u *R2Uploader) UploadFromURL(imageURL, objectKey string) error {
-	// 下载图片
-	resp, err := http.Get(imageURL)
+// verifyUpload 校验上传结果，与上传占用同一份出站额度
+func (u *R2Uploader) verifyUpload(ctx context.Context, objectKey string, output *manager.UploadOutput, reader *checksumReader) error {
+	if output.ChecksumCRC32 != nil && *output.ChecksumCRC32 != "" && !strings.Contains(*output.ChecksumCRC32, "-") {
+		if *output.ChecksumCRC32 != reader.checksum() {
+			return fmt.Errorf("%w: %s local %s remote %s", ErrChecksumMismatch, objectKey, reader.checksum(), *output.ChecksumCRC32)
+		}
+		return nil
+	}
+	head, err := u.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(u.bucketName),
+		Key:    aws.String(objectKey),
+	})
 	if err != nil {
-		logger.Error("failed to download image: " + err.Error())
-		return err
+		return fmt.Errorf("failed to verify upload: %w", err)
+	}
+	if head.ContentLength == nil || *head.ContentLength != reader.size {
+		return fmt.Errorf("%w: %s local %d", ErrSizeMismatch, objectKey, reader.size)
 	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("failed to download image, status code: " + strconv.Itoa(resp.StatusCode))
-		return errors.New("failed to download image, status code: " + strconv.Itoa(resp.StatusCode))
+// withRetry 在R2的出站限流下上传，网络错误、429和5xx以带抖动的指数退避重试，
+// ctx结束或遇到不可重试的错误时立即返回
+func (u *R2Uploader) withRetry(ctx context.Context, objectKey string, upload func() error) error {
+	outbound := GetOutbound(ProviderR2)
+	policy := RetryPolicy{MaxRetries: u.MaxRetries, BaseDelay: u.RetryDelay, MaxDelay: outbound.Retry.MaxDelay}
+	err := outbound.Call(ctx, policy, func(err error) bool {
+		var p *permanentError
+		return !errors.As(err, &p) && isRetryableR2Error(err)
+	}, upload)
+	if err == nil {
+		return nil
 	}
+	var p *permanentError
+	if errors.As(err, &p) {
+		err = p.err
+	}
+	logger.Error("upload", objectKey, "failed:", err.Error())
+	return err
+}
 
-	// 获取内容类型
-	contentType := resp.Header.Get("Content-Type")
-	if contentType == "" {
-		// 根据文件扩展名猜测内容类型
-		contentType = u.getContentTypeFromExtension(objectKey)
+// call 在R2的出站限流下执行一次S3请求，网络错误、429和5xx最多重试 retries 次
+func (u *R2Uploader) call(ctx context.Context, retries int, fn func() error) error {
+	outbound := GetOutbound(ProviderR2)
+	policy := RetryPolicy{MaxRetries: retries, BaseDelay: u.RetryDelay, MaxDelay: outbound.Retry.MaxDelay}
+	return outbound.Call(ctx, policy, isRetryableR2Error, fn)
+}
+
+// isRetryableR2Error 没有HTTP状态码（网络错误）或状态码为429、5xx时可以重试
+func isRetryableR2Error(err error) bool {
+	var response interface{ HTTPStatusCode() int }
+	if !errors.As(err, &response) {
+		return true
 	}
+	status := response.HTTPStatusCode()
+	return status == http.StatusTooManyRequests || status >= 500
+}
 
-	// 读取响应体
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("failed to read response body: " + err.Error())
-		return err
+// permanentError 标记不需要重试的错误
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// checksumReader 在读取时同步计算CRC32和长度
+type checksumReader struct {
+	reader io.Reader
+	hash   hash.Hash32
+	size   int64
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+// checksum 返回与S3 ChecksumCRC32相同格式的校验和：大端序CRC32的base64编码
+func (r *checksumReader) checksum() string {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, r.hash.Sum32())
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// R2Object 对象列表中的一项
+type R2Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects 分页列出指定前缀下的所有对象，对每个对象调用fn
+func (u *R2Uploader) ListObjects(ctx context.Context, prefix string, fn func(R2Object) error) error {
+	paginator := s3.NewListObjectsV2Paginator(u.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(u.bucketName),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		var page *s3.ListObjectsV2Output
+		err := u.call(ctx, u.MaxRetries, func() (err error) {
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, object := range page.Contents {
+			item := R2Object{Key: aws.ToString(object.Key), Size: aws.ToInt64(object.Size)}
+			if object.LastModified != nil {
+				item.LastModified = *object.LastModified
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	// 上传到R2
-	output, err := u.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      aws.String(u.bucketName),
-		Key:         aws.String(objectKey),
-		Body:        bytes.NewReader(body),
-		ContentType: aws.String(contentType),
+// DeleteObject 删除对象，对象不存在时不报错
+func (u *R2Uploader) DeleteObject(ctx context.Context, objectKey string) error {
+	err := u.call(ctx, u.MaxRetries, func() error {
+		_, err := u.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(u.bucketName),
+			Key:    aws.String(objectKey),
+		})
+		return err
 	})
 	if err != nil {
-		logger.Error("failed to upload to R2: " + err.Error())
-		return err
+		return fmt.Errorf("failed to delete object %s: %w", objectKey, err)
 	}
+	return nil
+}
 
-	logger.Log("Successfully uploaded %s to R2 bucket %s", objectKey, u.bucketName)
-	logger.Log(*output.ChecksumCRC32)
+// CopyObject 在同一存储桶内复制对象
+func (u *R2Uploader) CopyObject(ctx context.Context, srcKey, dstKey string) error {
+	err := u.call(ctx, u.MaxRetries, func() error {
+		_, err := u.client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(u.bucketName),
+			CopySource: aws.String(u.bucketName + "/" + (&url.URL{Path: srcKey}).EscapedPath()),
+			Key:        aws.String(dstKey),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy object %s to %s: %w", srcKey, dstKey, err)
+	}
 	return nil
 }
 
+// ObjectExists 判断对象是否存在
+func (u *R2Uploader) ObjectExists(ctx context.Context, objectKey string) (bool, error) {
+	err := u.call(ctx, u.MaxRetries, func() error {
+		_, err := u.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(u.bucketName),
+			Key:    aws.String(objectKey),
+		})
+		return err
+	})
+	if err == nil {
+		return true, nil
+	}
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to head object %s: %w", objectKey, err)
+}
+
 // GeneratePresignedURL 生成预签名URL
-func (u *R2Uploader) GeneratePresignedURL(objectKey string, expiration time.Duration) (string, error) {
+func (u *R2Uploader) GeneratePresignedURL(ctx context.Context, objectKey string, expiration time.Duration) (string, error) {
 	// 创建预签名客户端
 	presignClient := s3.NewPresignClient(u.client)
 
@@ -142,7 +336,7 @@ func (u *R2Uploader) GeneratePresignedURL(objectKey string, expiration time.Dura
 	}
 
 	// 生成预签名URL
-	presignedRequest, err := presignClient.PresignGetObject(context.TODO(), input, func(opts *s3.PresignOptions) {
+	presignedRequest, err := presignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
 		opts.Expires = expiration
 	})
 	if err != nil {
@@ -166,6 +360,14 @@ func (u *R2Uploader) getContentTypeFromExtension(filename string) string {
 		return "image/webp"
 	case ".svg":
 		return "image/svg+xml"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".opus":
+		return "audio/ogg"
+	case ".m4a":
+		return "audio/mp4"
+	case ".mp4":
+		return "video/mp4"
 	default:
 		return "application/octet-stream"
 	}
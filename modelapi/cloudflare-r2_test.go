@@ -0,0 +1,40 @@
+package modelapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestR2UploadRetry(t *testing.T) {
+	restoreOutbound(t)
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"网络错误重试", errors.New("connection reset"), 3},
+		{"429重试", statusError(http.StatusTooManyRequests), 3},
+		{"5xx重试", statusError(http.StatusServiceUnavailable), 3},
+		{"4xx不重试", statusError(http.StatusForbidden), 1},
+		{"标记为不可重试", permanent(errors.New("failed to open local file")), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &R2Uploader{MaxRetries: 2, RetryDelay: time.Millisecond}
+			calls := 0
+			err := u.withRetry(context.Background(), "key", func() error {
+				calls++
+				return tt.err
+			})
+			if err == nil {
+				t.Fatal("withRetry() error = nil, want error")
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("withRetry() made %d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
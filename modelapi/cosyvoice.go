@@ -1,45 +1,126 @@
 package modelapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fairytale-creator/ssml"
+	"fairytale-creator/util"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	TextTypePlain = "PlainText"
+	TextTypeSSML  = "SSML"
+
+	CosyVoiceSampleRate = 22050 // 默认采样率
+)
+
+// 语音合成错误类型，可通过 errors.Is 判断
+var (
+	ErrTTSAuth           = errors.New("语音合成鉴权失败")
+	ErrTTSQuota          = errors.New("语音合成额度不足或请求超限")
+	ErrTTSDataInspection = errors.New("语音合成文本未通过内容审核")
+	ErrTTSTimeout        = errors.New("语音合成超时")
+	ErrTTSTaskFailed     = errors.New("语音合成任务失败")
+	ErrTTSEmptyAudio     = errors.New("语音合成未返回音频")
+)
+
+// CosyVoiceError 服务端返回的任务失败信息
+type CosyVoiceError struct {
+	Code    string
+	Message string
+	Kind    error
+}
+
+func (e *CosyVoiceError) Error() string {
+	return fmt.Sprintf("%v: %s %s", e.Kind, e.Code, e.Message)
+}
+
+func (e *CosyVoiceError) Unwrap() error {
+	return e.Kind
+}
+
+// newCosyVoiceError 根据DashScope错误码归类错误
+func newCosyVoiceError(code, message string) *CosyVoiceError {
+	kind := ErrTTSTaskFailed
+	switch {
+	case code == "InvalidApiKey" || strings.HasPrefix(code, "AccessDenied") || code == "Unauthorized":
+		kind = ErrTTSAuth
+	case strings.HasPrefix(code, "Throttling") || code == "Arrearage" || strings.Contains(code, "Quota"):
+		kind = ErrTTSQuota
+	case code == "DataInspectionFailed" || strings.Contains(code, "DataInspection"):
+		kind = ErrTTSDataInspection
+	}
+	if message == "" {
+		message = "未知原因导致任务失败"
+	}
+	return &CosyVoiceError{Code: code, Message: message, Kind: kind}
+}
+
 // TTSClient 文本转语音客户端
 type CosyVoiceClient struct {
-	APIKey     string
-	OutputFile string
-	Voice      string
-	Format     string
-	SampleRate int
-	Volume     int
-	Rate       int
-	Pitch      int
-	conn       *websocket.Conn
+	APIKey         string
+	OutputFile     string
+	TextType       string
+	Voice          string
+	Format         string
+	SampleRate     int
+	Volume         int
+	Rate           float64
+	Pitch          float64
+	ConnectTimeout time.Duration // 建立连接超时
+	ReadTimeout    time.Duration // 两条服务端消息之间的最长等待时间
+	WriteTimeout   time.Duration // 发送单条指令的超时
+	ChunkSize      int           // 每个片段的最大字数，SSML模式下不计<speak>本身
+	MaxRetries     int           // 会话因网络、超时或限流失败时的重试次数，重试从失败的片段继续
+	// SubmittedCharacters 已发送的文本字数，包括重试时重新发送的，SSML不计标签，用于计费
+	SubmittedCharacters int
+	conn                *websocket.Conn
+	output              *os.File
+	received            int64
+}
+
+// SpeechSegment 一段使用独立声音参数合成的文本，用于多角色朗读
+type SpeechSegment struct {
+	Text  string
+	Voice string
+	Rate  float64
+	Pitch float64
 }
 
 // NewCosyVoiceClient 创建新的TTS客户端
 func NewCosyVoiceClient(apiKey, outputFile string) *CosyVoiceClient {
 	return &CosyVoiceClient{
-		APIKey:     apiKey,
-		OutputFile: outputFile,
-		Voice:      "longyuan_v2", // 默认声音
-		Format:     "mp3",         // 默认格式
-		SampleRate: 22050,         // 默认采样率
-		Volume:     50,            // 默认音量
-		Rate:       1,             // 默认语速
-		Pitch:      1,             // 默认音调
+		APIKey:         apiKey,
+		OutputFile:     outputFile,
+		TextType:       TextTypePlain,       // 默认纯文本
+		Voice:          "longyuan_v2",       // 默认声音
+		Format:         "mp3",               // 默认格式
+		SampleRate:     CosyVoiceSampleRate, // 默认采样率
+		Volume:         50,                  // 默认音量
+		Rate:           1,                   // 默认语速
+		Pitch:          1,                   // 默认音调
+		ConnectTimeout: 10 * time.Second,    // 默认连接超时
+		ReadTimeout:    30 * time.Second,    // 默认读超时
+		WriteTimeout:   10 * time.Second,    // 默认写超时
+		ChunkSize:      300,                 // 默认每片300字
+		MaxRetries:     2,                   // 默认重试2次
 	}
 }
 
 // 设置声音参数
-func (c *CosyVoiceClient) SetVoiceParams(voice, format string, sampleRate, volume, rate, pitch int) {
+func (c *CosyVoiceClient) SetVoiceParams(voice, format string, sampleRate, volume int, rate, pitch float64) {
 	if voice != "" {
 		c.Voice = voice
 	}
@@ -62,53 +143,224 @@ func (c *CosyVoiceClient) SetVoiceParams(voice, format string, sampleRate, volum
 
 // 合成文本为语音
 func (c *CosyVoiceClient) Synthesize(texts []string) error {
-	// 检查并清空输出文件
-	if err := c.clearOutputFile(); err != nil {
-		return fmt.Errorf("清空输出文件失败: %v", err)
+	return c.SynthesizeContext(context.Background(), texts)
+}
+
+// SynthesizeContext 合成文本为语音，ctx取消或超时会中断合成
+func (c *CosyVoiceClient) SynthesizeContext(ctx context.Context, texts []string) error {
+	return c.withOutput(func() error {
+		return c.synthesizeTask(ctx, texts)
+	})
+}
+
+// SynthesizeSegments 按顺序逐段合成，每段使用各自的声音、语速和音调，音频依次追加到输出文件
+func (c *CosyVoiceClient) SynthesizeSegments(segments []SpeechSegment) error {
+	return c.SynthesizeSegmentsContext(context.Background(), segments)
+}
+
+// SynthesizeSegmentsContext 同 SynthesizeSegments，ctx取消或超时会中断合成
+func (c *CosyVoiceClient) SynthesizeSegmentsContext(ctx context.Context, segments []SpeechSegment) error {
+	voice, rate, pitch := c.Voice, c.Rate, c.Pitch
+	defer func() {
+		c.Voice, c.Rate, c.Pitch = voice, rate, pitch
+	}()
+	return c.withOutput(func() error {
+		for i, segment := range segments {
+			if segment.Text == "" {
+				continue
+			}
+			c.Voice, c.Rate, c.Pitch = voice, rate, pitch
+			c.SetVoiceParams(segment.Voice, "", 0, -1, segment.Rate, segment.Pitch)
+			if err := c.synthesizeTask(ctx, []string{segment.Text}); err != nil {
+				return fmt.Errorf("第%d段合成失败: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+}
+
+// withOutput 将音频写入输出文件同目录下的临时文件，只有全部合成成功才重命名为输出文件，
+// 失败时删除临时文件，避免留下空的或不完整的音频
+func (c *CosyVoiceClient) withOutput(synthesize func() error) error {
+	dir := filepath.Dir(c.OutputFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建输出目录失败: %w", err)
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(c.OutputFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	c.output = file
+	c.received = 0
+	defer func() {
+		c.output = nil
+	}()
+
+	err = synthesize()
+	if err == nil && c.received == 0 {
+		err = ErrTTSEmptyAudio
 	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("写入音频失败: %w", closeErr)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), c.OutputFile); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("保存音频失败: %w", err)
+	}
+	return nil
+}
+
+// 执行一次完整的合成，音频追加写入临时文件。
+// 文本按句子边界切分为多个片段（SSML每片用各自的<speak>包裹），在同一个WebSocket连接上依次作为独立任务合成，
+// 收到片段的task-finished即确认该片段，并记下下一片段音频在临时文件中的起始位置；
+// 会话受CosyVoice的出站限流约束，中途失败时将临时文件截断到第一个未确认片段的起始位置，
+// 以退避间隔从该片段继续，已确认的片段不再重新发送
+func (c *CosyVoiceClient) synthesizeTask(ctx context.Context, texts []string) error {
+	var chunks []string
+	for _, text := range texts {
+		if c.TextType != TextTypeSSML {
+			chunks = append(chunks, util.ChunkText(text, c.ChunkSize)...)
+			continue
+		}
+		docs, err := ssml.Split(text, c.ChunkSize)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, docs...)
+	}
+	// next 第一个未确认的片段，offsets[i] 第i个片段的音频在临时文件中的起始位置
+	next := 0
+	offsets := make([]int64, len(chunks)+1)
+	offsets[0] = c.received
+	outbound := GetOutbound(ProviderCosyVoice)
+	policy := RetryPolicy{MaxRetries: c.MaxRetries, BaseDelay: outbound.Retry.BaseDelay, MaxDelay: outbound.Retry.MaxDelay}
+	err := outbound.Call(ctx, policy, isRetryableTTSError, func() error {
+		if err := c.rewindOutput(offsets[next]); err != nil {
+			return err
+		}
+		return c.runTask(ctx, chunks[next:], func() {
+			next++
+			offsets[next] = c.received
+		})
+	})
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ErrTTSTimeout) {
+		return fmt.Errorf("%w: %v", ErrTTSTimeout, err)
+	}
+	return err
+}
 
+// isRetryableTTSError 超时、连接中断和限流可以重试，鉴权、审核等错误重试无意义
+func isRetryableTTSError(err error) bool {
+	var cosyErr *CosyVoiceError
+	if errors.As(err, &cosyErr) {
+		return strings.HasPrefix(cosyErr.Code, "Throttling")
+	}
+	if errors.Is(err, ErrTTSAuth) || errors.Is(err, ErrTTSQuota) || errors.Is(err, ErrTTSDataInspection) {
+		return false
+	}
+	return true
+}
+
+// rewindOutput 将临时文件截断到指定位置，丢弃失败会话写入的音频
+func (c *CosyVoiceClient) rewindOutput(offset int64) error {
+	if err := c.output.Truncate(offset); err != nil {
+		return fmt.Errorf("截断临时文件失败: %w", err)
+	}
+	if _, err := c.output.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("截断临时文件失败: %w", err)
+	}
+	c.received = offset
+	return nil
+}
+
+// runTask 建立一个WebSocket会话，在同一连接上逐片合成，每个片段合成完成后调用acked
+func (c *CosyVoiceClient) runTask(ctx context.Context, texts []string, acked func()) error {
 	// 连接WebSocket服务
-	conn, err := c.connectWebSocket()
+	conn, err := c.connectWebSocket(ctx)
 	if err != nil {
-		return fmt.Errorf("连接WebSocket失败: %v", err)
+		return fmt.Errorf("连接WebSocket失败: %w", err)
 	}
 	c.conn = conn
-	defer c.closeConnection()
 
-	// 启动一个goroutine来接收结果
-	done, taskStarted := c.startResultReceiver()
+	// ctx结束时关闭连接，使阻塞中的读写立即返回
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	// 启动一个goroutine来接收结果，返回前关闭连接并等待其退出，保证不再写入临时文件
+	events, exited := c.startResultReceiver(stop)
+	defer func() {
+		close(stop)
+		c.closeConnection()
+		<-exited
+	}()
+
+	for i, text := range texts {
+		if err := c.runChunk(ctx, events, text); err != nil {
+			return fmt.Errorf("第%d/%d片合成失败: %w", i+1, len(texts), err)
+		}
+		acked()
+	}
+	return nil
+}
 
+// runChunk 在当前连接上以一个任务合成一个片段，收到task-finished时返回
+func (c *CosyVoiceClient) runChunk(ctx context.Context, events <-chan taskEvent, text string) error {
 	// 发送run-task指令
 	taskID, err := c.sendRunTaskCmd()
 	if err != nil {
-		return fmt.Errorf("发送run-task指令失败: %v", err)
+		return c.wrapConnError(ctx, fmt.Errorf("发送run-task指令失败: %w", err))
 	}
 
-	// 等待task-started事件
-	for !*taskStarted {
-		time.Sleep(100 * time.Millisecond)
+	// 等待task-started事件，任务在开始前失败时直接返回
+	if event := <-events; !event.started {
+		if event.err == nil {
+			event.err = ErrTTSTaskFailed
+		}
+		return c.wrapConnError(ctx, event.err)
 	}
 
-	// 发送待合成文本
-	if err := c.sendContinueTaskCmd(taskID, texts); err != nil {
-		return fmt.Errorf("发送待合成文本失败: %v", err)
+	// 发送待合成文本和finish-task指令
+	if err := c.sendContinueTaskCmd(taskID, text); err != nil {
+		return c.wrapConnError(ctx, fmt.Errorf("发送文本失败: %w", err))
 	}
-
-	// 发送finish-task指令
 	if err := c.sendFinishTaskCmd(taskID); err != nil {
-		return fmt.Errorf("发送finish-task指令失败: %v", err)
+		return c.wrapConnError(ctx, fmt.Errorf("发送finish-task指令失败: %w", err))
 	}
 
-	// 等待接收结果的goroutine完成
-	<-done
+	// 等待任务结束
+	return c.wrapConnError(ctx, (<-events).err)
+}
 
-	return nil
+// wrapConnError 连接因ctx结束被关闭时返回ctx的错误，读超时统一归为 ErrTTSTimeout
+func (c *CosyVoiceClient) wrapConnError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %v", ErrTTSTimeout, ctxErr)
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: %v", ErrTTSTimeout, err)
+	}
+	return err
 }
 
 // 内部结构体和方法的实现与示例代码类似，但做了适当调整以支持客户端模式
 // 以下是简化的实现，完整实现需要包含所有必要的方法
 
-const wsURL = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/"
+// wsURL DashScope的WebSocket地址，测试时替换为本地服务
+var wsURL = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/"
 
 // 定义结构体来表示JSON数据
 type Header struct {
@@ -132,13 +384,13 @@ type Payload struct {
 }
 
 type Params struct {
-	TextType   string `json:"text_type"`
-	Voice      string `json:"voice"`
-	Format     string `json:"format"`
-	SampleRate int    `json:"sample_rate"`
-	Volume     int    `json:"volume"`
-	Rate       int    `json:"rate"`
-	Pitch      int    `json:"pitch"`
+	TextType   string  `json:"text_type"`
+	Voice      string  `json:"voice"`
+	Format     string  `json:"format"`
+	SampleRate int     `json:"sample_rate"`
+	Volume     int     `json:"volume"`
+	Rate       float64 `json:"rate"`
+	Pitch      float64 `json:"pitch"`
 }
 
 type Resource struct {
@@ -155,16 +407,22 @@ type Event struct {
 	Payload Payload `json:"payload"`
 }
 
-var dialer = websocket.DefaultDialer
-
 // 连接WebSocket服务
-func (c *CosyVoiceClient) connectWebSocket() (*websocket.Conn, error) {
+func (c *CosyVoiceClient) connectWebSocket(ctx context.Context) (*websocket.Conn, error) {
 	header := make(http.Header)
 	header.Add("X-DashScope-DataInspection", "enable")
 	header.Add("Authorization", fmt.Sprintf("bearer %s", c.APIKey))
-	conn, _, err := dialer.Dial(wsURL, header)
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = c.ConnectTimeout
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
 	if err != nil {
-		return nil, err
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, fmt.Errorf("%w: %s", ErrTTSAuth, resp.Status)
+		}
+		if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+			return nil, fmt.Errorf("%w: %s", ErrTTSQuota, resp.Status)
+		}
+		return nil, c.wrapConnError(ctx, err)
 	}
 	return conn, nil
 }
@@ -184,7 +442,7 @@ func (c *CosyVoiceClient) sendRunTaskCmd() (string, error) {
 			Function:  "SpeechSynthesizer",
 			Model:     "cosyvoice-v2",
 			Parameters: Params{
-				TextType:   "PlainText",
+				TextType:   c.TextType,
 				Voice:      c.Voice,
 				Format:     c.Format,
 				SampleRate: c.SampleRate,
@@ -199,122 +457,142 @@ func (c *CosyVoiceClient) sendRunTaskCmd() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	err = c.conn.WriteMessage(websocket.TextMessage, runTaskCmdJSON)
+	err = c.writeMessage(runTaskCmdJSON)
 	return taskID, err
 }
 
-// 发送待合成文本
-func (c *CosyVoiceClient) sendContinueTaskCmd(taskID string, texts []string) error {
-	for _, text := range texts {
-		runTaskCmd := Event{
-			Header: Header{
-				Action:    "continue-task",
-				TaskID:    taskID,
-				Streaming: "duplex",
-			},
-			Payload: Payload{
-				Input: Input{
-					Text: text,
-				},
-			},
-		}
-		runTaskCmdJSON, err := json.Marshal(runTaskCmd)
+// 发送待合成文本，SSML只计文本内容的字数，不计标签
+func (c *CosyVoiceClient) sendContinueTaskCmd(taskID string, text string) error {
+	content := text
+	if c.TextType == TextTypeSSML {
+		plain, err := ssml.PlainText(text)
 		if err != nil {
 			return err
 		}
+		content = plain
+	}
+	runTaskCmd := Event{
+		Header: Header{
+			Action:    "continue-task",
+			TaskID:    taskID,
+			Streaming: "duplex",
+		},
+		Payload: Payload{
+			Input: Input{
+				Text: text,
+			},
+		},
+	}
+	runTaskCmdJSON, err := json.Marshal(runTaskCmd)
+	if err != nil {
+		return err
+	}
+	if err := c.writeMessage(runTaskCmdJSON); err != nil {
+		return err
+	}
+	c.SubmittedCharacters += utf8.RuneCountInString(content)
+	return nil
+}
 
-		err = c.conn.WriteMessage(websocket.TextMessage, runTaskCmdJSON)
-		if err != nil {
-			return err
-		}
+// writeMessage 带写超时发送文本消息
+func (c *CosyVoiceClient) writeMessage(data []byte) error {
+	if c.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
 	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
 
-	return nil
+// taskEvent 接收协程转发的任务事件：started 表示task-started，否则表示任务结束，err 为nil即task-finished
+type taskEvent struct {
+	started bool
+	err     error
 }
 
-// 启动一个goroutine来接收结果
-func (c *CosyVoiceClient) startResultReceiver() (chan struct{}, *bool) {
-	done := make(chan struct{})
-	taskStarted := new(bool)
-	*taskStarted = false
+// 启动一个goroutine来接收结果：每个任务收到task-started和任务结束时各向events发送一次事件，
+// 任务失败或连接出错后退出，stop关闭时不再发送；goroutine退出时关闭exited
+func (c *CosyVoiceClient) startResultReceiver(stop <-chan struct{}) (<-chan taskEvent, <-chan struct{}) {
+	events := make(chan taskEvent)
+	exited := make(chan struct{})
+	conn := c.conn
 
 	go func() {
-		defer close(done)
+		defer close(exited)
+		send := func(event taskEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-stop:
+				return false
+			}
+		}
+		// 服务端重复发送task-started时每个任务只转发第一次
+		taskStarted := false
 		for {
-			msgType, message, err := c.conn.ReadMessage()
+			if c.ReadTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+			}
+			msgType, message, err := conn.ReadMessage()
 			if err != nil {
-				fmt.Println("解析服务器消息失败：", err)
+				send(taskEvent{err: fmt.Errorf("读取服务器消息失败: %w", err)})
 				return
 			}
 
 			if msgType == websocket.BinaryMessage {
 				// 处理二进制音频流
 				if err := c.writeBinaryDataToFile(message); err != nil {
-					fmt.Println("写入二进制数据失败：", err)
+					send(taskEvent{err: fmt.Errorf("写入音频数据失败: %w", err)})
 					return
 				}
-			} else {
-				// 处理文本消息
-				var event Event
-				err = json.Unmarshal(message, &event)
-				if err != nil {
-					fmt.Println("解析事件失败：", err)
-					continue
+				continue
+			}
+
+			// 处理文本消息
+			var event Event
+			if err := json.Unmarshal(message, &event); err != nil {
+				send(taskEvent{err: fmt.Errorf("解析事件失败: %w", err)})
+				return
+			}
+			switch event.Header.Event {
+			case "task-started":
+				if !taskStarted {
+					taskStarted = true
+					if !send(taskEvent{started: true}) {
+						return
+					}
 				}
-				if c.handleEvent(event, taskStarted) {
+			case "result-generated":
+				// 忽略result-generated事件
+			case "task-finished":
+				// 连接继续用于下一个片段的任务
+				taskStarted = false
+				if !send(taskEvent{}) {
 					return
 				}
+			case "task-failed":
+				send(taskEvent{err: newCosyVoiceError(event.Header.ErrorCode, event.Header.ErrorMessage)})
+				return
+			default:
+				send(taskEvent{err: fmt.Errorf("%w: 预料之外的事件%s", ErrTTSTaskFailed, event.Header.Event)})
+				return
 			}
 		}
 	}()
 
-	return done, taskStarted
-}
-
-// 处理事件
-func (c *CosyVoiceClient) handleEvent(event Event, taskStarted *bool) bool {
-	switch event.Header.Event {
-	case "task-started":
-		*taskStarted = true
-	case "result-generated":
-		// 忽略result-generated事件
-		return false
-	case "task-finished":
-		return true
-	case "task-failed":
-		c.handleTaskFailed(event)
-		return true
-	default:
-		fmt.Printf("预料之外的事件：%v\n", event)
-	}
-	return false
-}
-
-// 处理任务失败事件
-func (c *CosyVoiceClient) handleTaskFailed(event Event) {
-	if event.Header.ErrorMessage != "" {
-		fmt.Printf("任务失败：%s\n", event.Header.ErrorMessage)
-	} else {
-		fmt.Println("未知原因导致任务失败")
-	}
+	return events, exited
 }
 
 // 关闭连接
 func (c *CosyVoiceClient) closeConnection() {
 	if c.conn != nil {
 		c.conn.Close()
+		c.conn = nil
 	}
 }
 
-// 写入二进制数据到文件
+// 写入二进制数据到临时文件
 func (c *CosyVoiceClient) writeBinaryDataToFile(data []byte) error {
-	file, err := os.OpenFile(c.OutputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	_, err = file.Write(data)
+	n, err := c.output.Write(data)
+	c.received += int64(n)
 	return err
 }
 
@@ -334,16 +612,7 @@ func (c *CosyVoiceClient) sendFinishTaskCmd(taskID string) error {
 	if err != nil {
 		return err
 	}
-	return c.conn.WriteMessage(websocket.TextMessage, finishTaskCmdJSON)
-}
-
-// 清空输出文件
-func (c *CosyVoiceClient) clearOutputFile() error {
-	file, err := os.OpenFile(c.OutputFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	return file.Close()
+	return c.writeMessage(finishTaskCmdJSON)
 }
 
 // 使用示例
@@ -364,8 +633,10 @@ func test() {
 	// 要转换的文本
 	texts := []string{"床前明月光", "疑是地上霜", "举头望明月", "低头思故乡"}
 
-	// 执行文本转语音
-	err := client.Synthesize(texts)
+	// 执行文本转语音，最长等待两分钟
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	err := client.SynthesizeContext(ctx, texts)
 	if err != nil {
 		fmt.Printf("语音合成失败: %v\n", err)
 		return
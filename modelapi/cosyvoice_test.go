@@ -0,0 +1,137 @@
+package modelapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeCosyVoice 模拟DashScope的WebSocket接口：每个任务把收到的文本原样作为音频返回，
+// dropOn 中的文本第一次出现时只返回一半音频就断开连接
+type fakeCosyVoice struct {
+	mu        sync.Mutex
+	dropOn    map[string]bool
+	submitted []string
+}
+
+func (f *fakeCosyVoice) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var text string
+	for {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			return
+		}
+		reply := Event{Header: Header{TaskID: event.Header.TaskID}}
+		switch event.Header.Action {
+		case "run-task":
+			reply.Header.Event = "task-started"
+		case "continue-task":
+			text = event.Payload.Input.Text
+			f.mu.Lock()
+			f.submitted = append(f.submitted, text)
+			drop := f.dropOn[text]
+			delete(f.dropOn, text)
+			f.mu.Unlock()
+			if drop {
+				conn.WriteMessage(websocket.BinaryMessage, []byte(text[:len(text)/2]))
+				return
+			}
+			continue
+		case "finish-task":
+			conn.WriteMessage(websocket.BinaryMessage, []byte(text))
+			reply.Header.Event = "task-finished"
+		}
+		if err := conn.WriteJSON(reply); err != nil {
+			return
+		}
+	}
+}
+
+// newFakeCosyVoice 启动模拟服务并让客户端连接它，重试间隔缩短为1毫秒，测试结束后恢复
+func newFakeCosyVoice(t *testing.T, dropOn ...string) *fakeCosyVoice {
+	restoreOutbound(t)
+	if err := ConfigureOutbound("", RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, BreakerPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeCosyVoice{dropOn: map[string]bool{}}
+	for _, text := range dropOn {
+		fake.dropOn[text] = true
+	}
+	server := httptest.NewServer(http.HandlerFunc(fake.handle))
+	prev := wsURL
+	wsURL = "ws" + strings.TrimPrefix(server.URL, "http")
+	t.Cleanup(func() {
+		wsURL = prev
+		server.Close()
+	})
+	return fake
+}
+
+func TestCosyVoiceResumeFromFailedChunk(t *testing.T) {
+	chunks := []string{"第一句话。", "第二句话。", "第三句话。"}
+	tests := []struct {
+		name          string
+		dropOn        []string
+		wantSubmitted []string
+	}{
+		{"全部成功", nil, chunks},
+		{"只重发失败的片段", []string{"第二句话。"}, []string{"第一句话。", "第二句话。", "第二句话。", "第三句话。"}},
+		{"第一个片段失败", []string{"第一句话。"}, []string{"第一句话。", "第一句话。", "第二句话。", "第三句话。"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeCosyVoice(t, tt.dropOn...)
+			output := filepath.Join(t.TempDir(), "voice.mp3")
+			client := NewCosyVoiceClient("key", output)
+			client.ChunkSize = 5
+			if err := client.Synthesize([]string{strings.Join(chunks, "")}); err != nil {
+				t.Fatalf("Synthesize() error = %v", err)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := string(data), strings.Join(chunks, ""); got != want {
+				t.Errorf("audio = %q, want %q", got, want)
+			}
+			got, _ := json.Marshal(fake.submitted)
+			want, _ := json.Marshal(tt.wantSubmitted)
+			if string(got) != string(want) {
+				t.Errorf("submitted = %s, want %s", got, want)
+			}
+			wantChars := 0
+			for _, text := range tt.wantSubmitted {
+				wantChars += len([]rune(text))
+			}
+			if client.SubmittedCharacters != wantChars {
+				t.Errorf("SubmittedCharacters = %d, want %d", client.SubmittedCharacters, wantChars)
+			}
+		})
+	}
+}
+
+func TestCosyVoiceCountsSSMLText(t *testing.T) {
+	newFakeCosyVoice(t)
+	client := NewCosyVoiceClient("key", filepath.Join(t.TempDir(), "voice.mp3"))
+	client.TextType = TextTypeSSML
+	doc := `<speak rate="1.1">从前&lt;有&gt;座山。<break time="1s"/><phoneme alphabet="py" ph="hao3">好</phoneme></speak>`
+	if err := client.Synthesize([]string{doc}); err != nil {
+		t.Fatalf("Synthesize() error = %v", err)
+	}
+	if want := len([]rune("从前<有>座山。好")); client.SubmittedCharacters != want {
+		t.Errorf("SubmittedCharacters = %d, want %d", client.SubmittedCharacters, want)
+	}
+}
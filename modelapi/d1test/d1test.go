@@ -0,0 +1,187 @@
+// Package d1test 用本地SQLite模拟 Cloudflare D1 的查询接口，供测试使用。
+// 与D1一样，一个批次中的语句在一个事务中执行，任一语句失败整体回滚
+package d1test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"fairytale-creator/modelapi"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	logger2 "gorm.io/gorm/logger"
+)
+
+// Server 模拟的D1服务，URL 作为 D1Client.BaseURL 使用
+type Server struct {
+	*httptest.Server
+	DB *sql.DB
+
+	mu sync.Mutex
+	// fail 不为nil时在执行批次前调用，返回的状态码不为0时以该状态码和错误信息拒绝请求
+	fail func(batch []modelapi.D1QueryRequest) (int, string)
+}
+
+// NewServer 启动一个空数据库的模拟D1服务，测试结束时关闭
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "d1.db")), &gorm.Config{
+		Logger: logger2.Default.LogMode(logger2.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{DB: sqlDB}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(func() {
+		s.Close()
+		sqlDB.Close()
+	})
+	return s
+}
+
+// SetFail 设置拒绝请求的条件，nil 表示全部正常执行
+func (s *Server) SetFail(fail func(batch []modelapi.D1QueryRequest) (int, string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/query") {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		modelapi.D1QueryRequest
+		Batch []modelapi.D1QueryRequest `json:"batch"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	batch := body.Batch
+	if batch == nil {
+		batch = []modelapi.D1QueryRequest{body.D1QueryRequest}
+	}
+	s.mu.Lock()
+	fail := s.fail
+	s.mu.Unlock()
+	if fail != nil {
+		if status, message := fail(batch); status != 0 {
+			writeError(w, status, message)
+			return
+		}
+	}
+	results, err := s.execute(batch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, modelapi.D1QueryResponse{Success: true, Result: results})
+}
+
+func (s *Server) execute(batch []modelapi.D1QueryRequest) ([]modelapi.D1Result, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	results := make([]modelapi.D1Result, 0, len(batch))
+	for _, q := range batch {
+		params := make([]interface{}, len(q.Params))
+		for i, p := range q.Params {
+			params[i] = param(p)
+		}
+		var result modelapi.D1Result
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(q.SQL)), "SELECT") {
+			result.Results, err = query(tx, q.SQL, params)
+		} else {
+			var res sql.Result
+			if res, err = tx.Exec(q.SQL, params...); err == nil {
+				changes, _ := res.RowsAffected()
+				lastID, _ := res.LastInsertId()
+				result.Meta.Changes = int(changes)
+				result.Meta.LastRowID = int(lastID)
+				result.Meta.ChangedDB = changes > 0
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		result.Success = true
+		results = append(results, result)
+	}
+	return results, tx.Commit()
+}
+
+func query(tx *sql.Tx, stmt string, params []interface{}) ([]map[string]interface{}, error) {
+	rows, err := tx.Query(stmt, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	list := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		list = append(list, row)
+	}
+	return list, rows.Err()
+}
+
+// param 把JSON中的数字还原为整数或浮点数，与D1的参数绑定一致
+func param(p interface{}) interface{} {
+	n, ok := p.(json.Number)
+	if !ok {
+		return p
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, modelapi.D1QueryResponse{Errors: []modelapi.D1Message{{Code: 7500, Message: message}}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
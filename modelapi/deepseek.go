@@ -1,20 +1,28 @@
 package modelapi
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fairytale-creator/logger"
 	"fairytale-creator/response"
 	"fairytale-creator/util"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 type DeepSeekClient struct {
 	APIKey     string
 	BaseURL    string
 	HttpClient *http.Client
+	Usage      DeepSeekUsage // 最近一次调用消耗的tokens，用于计费
+}
+
+// DeepSeekUsage 一次对话补全消耗的tokens
+type DeepSeekUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 func NewDeepSeekClient(apiKey string, baseURL string) *DeepSeekClient {
@@ -26,6 +34,12 @@ func NewDeepSeekClient(apiKey string, baseURL string) *DeepSeekClient {
 }
 
 func (c *DeepSeekClient) GenerateFairyTale(theme string, date string, style []string) (*response.Story, error) {
+	return c.GenerateFairyTaleContext(context.Background(), theme, date, style)
+}
+
+// GenerateFairyTaleContext 同 GenerateFairyTale，限流等待和重试不会超过ctx的截止时间
+func (c *DeepSeekClient) GenerateFairyTaleContext(ctx context.Context, theme string, date string, style []string) (*response.Story, error) {
+	c.Usage = DeepSeekUsage{}
 	systemPrompt := `
 # 角色
 你是一位**绘本创作大师**。
@@ -47,7 +61,13 @@ func (c *DeepSeekClient) GenerateFairyTale(theme string, date string, style []st
 6.  **故事总结 ("description"字段):**
     * 创作一句**不超过30个汉字**的总结。
     * 总结需高度凝练故事的核心思想与情感价值。	
-7. 整合输出：将所有内容按指定 JSON 格式整理输出。
+7.  **角色与对白 ("characters"、"segments"字段):**
+    * 在"characters"中列出故事里所有会开口说话的角色，注明名字、性别(male/female)、年龄段(child/adult/elder)和一句话形象描述。
+    * 将每个分镜的文案按说话人顺序拆分为"segments"，叙述部分的speaker固定为"旁白"，对白部分的speaker为角色名（必须与"characters"中的名字一致）。
+    * 所有segments的text按顺序拼接后必须与"content"完全一致。
+8.  **读音标注 ("pronunciations"字段):**
+    * 列出角色名、地名中容易被读错的词语（如多音字），并给出带数字声调的拼音，音节之间用空格分隔，如"行者"对应"xing2 zhe3"。没有则输出空数组。
+9. 整合输出：将所有内容按指定 JSON 格式整理输出。
 ## 安全限制
 生成的内容必须严格遵守以下规定：
 1.  **禁止暴力与血腥:** 不得包含任何详细的暴力、伤害、血腥或令人不适的画面描述。
@@ -62,10 +82,30 @@ func (c *DeepSeekClient) GenerateFairyTale(theme string, date string, style []st
 	"author": "作者(可以虚构)",
 	"description": "故事总结",
 	"music_style": "背景音乐风格描述",
+	"characters": [
+		{
+			"name": "角色名",
+			"gender": "male或female",
+			"age": "child或adult或elder",
+			"description": "角色形象描述"
+		}
+	],
+	"pronunciations": [
+		{
+			"word": "词语",
+			"pinyin": "数字声调拼音"
+		}
+	],
 	"chapters": [
 		{
 			"title": "章节标题",
 			"content": "章节内容",
+			"segments": [
+				{
+					"speaker": "旁白或角色名",
+					"text": "该说话人的文本"
+				}
+			],
 			"image_prompt": "图片描述",
 			"chapter_number": "章节序号(int类型)"
 		}
@@ -90,17 +130,25 @@ func (c *DeepSeekClient) GenerateFairyTale(theme string, date string, style []st
 		"max_tokens":  8000,
 	})
 
-	req, _ := http.NewRequest("POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(requestBody))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-
-	resp, err := c.HttpClient.Do(req)
+	resp, err := GetOutbound(ProviderDeepSeek).Do(ctx, c.HttpClient, NewJSONRequest(c.BaseURL+"/chat/completions", c.APIKey, requestBody))
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("deepseek generate fairy tale - status", strconv.Itoa(resp.StatusCode), string(resp.Body))
+		if resp.Unavailable() {
+			return nil, fmt.Errorf("%w: deepseek returned HTTP %d", ErrProviderUnavailable, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("deepseek returned HTTP %d", resp.StatusCode)
+	}
+	body := resp.Body
 
-	body, _ := ioutil.ReadAll(resp.Body)
+	// 先记录用量，后续解析失败时tokens同样已经计费
+	var meta struct {
+		Usage DeepSeekUsage `json:"usage"`
+	}
+	json.Unmarshal(body, &meta)
+	c.Usage = meta.Usage
 
 	// 解析DeepSeek返回的JSON，提取故事内容
 	var result map[string]interface{}
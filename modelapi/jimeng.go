@@ -2,16 +2,18 @@ package modelapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fairytale-creator/logger"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -26,6 +28,7 @@ type JimengClient struct {
 	Region          string
 	Action          string
 	Version         string
+	HttpClient      *http.Client
 }
 
 // 任务提交请求结构
@@ -37,6 +40,7 @@ type SubmitTaskRequest struct {
 	Height           int                    `json:"height,omitempty"`
 	ImageNum         int                    `json:"image_num,omitempty"`
 	ImageUrls        []string               `json:"image_urls,omitempty"`
+	BinaryDataBase64 []string               `json:"binary_data_base64,omitempty"`
 	AdditionalParams map[string]interface{} `json:"additional_params,omitempty"`
 }
 
@@ -70,7 +74,7 @@ type QueryTaskResponse struct {
 	Message string `json:"message"`
 	Data    struct {
 		Status           string   `json:"status"`
-		BinaryDataBase64 string   `json:"binary_data_base64"`
+		BinaryDataBase64 []string `json:"binary_data_base64"`
 		ImageUrls        []string `json:"image_urls"`
 	} `json:"data"`
 	TimeElapsed string `json:"time_elapsed"`
@@ -84,6 +88,8 @@ const (
 	ErrorPostTextRiskNotPass = "后文本风险不通过"
 	ErrorAPILimit            = "QPS超限"
 	ErrorConcurrentLimit     = "并发超限"
+	CodeAPILimit             = 50429
+	CodeConcurrentLimit      = 50430
 	ErrorInternal            = "内部错误"
 	ErrorInternalRPC         = "内部RPC错误"
 
@@ -101,6 +107,7 @@ func NewJimengClient(accessKeyID, secretAccessKey string) *JimengClient {
 		Path:            "/",
 		Service:         "cv",
 		Region:          "cn-north-1",
+		HttpClient:      &http.Client{Timeout: 60 * time.Second},
 	}
 }
 
@@ -129,17 +136,59 @@ func hashSHA256(data []byte) []byte {
 	return hash.Sum(nil)
 }
 
-// 执行请求
-func (c *JimengClient) doRequest(method string, action string, version string, queries url.Values, body []byte) ([]byte, int, error) {
-	logger.Log("requestBody:", string(body))
+// jimengThrottled QPS或并发超限，稍后重试即可
+func jimengThrottled(body []byte) bool {
+	var response struct {
+		Code    int    `json:"code"`
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &response) != nil {
+		return false
+	}
+	for _, code := range []int{response.Code, response.Status} {
+		if code == CodeAPILimit || code == CodeConcurrentLimit {
+			return true
+		}
+	}
+	return strings.Contains(response.Message, ErrorAPILimit) || strings.Contains(response.Message, ErrorConcurrentLimit)
+}
+
+// 执行请求，限流和服务端错误由出站客户端重试，每次重试重新签名
+func (c *JimengClient) doRequest(ctx context.Context, method string, action string, version string, queries url.Values, body []byte) ([]byte, int, error) {
+	logger.Log("requestBody:", logBody(body))
 	// 构建请求URL
 	queries.Set("Action", action)
 	queries.Set("Version", version)
 	requestAddr := fmt.Sprintf("%s%s?%s", c.Addr, c.Path, queries.Encode())
 
-	request, err := http.NewRequest(method, requestAddr, bytes.NewBuffer(body))
+	response, err := GetOutbound(ProviderJimeng).Do(ctx, c.HttpClient, func(ctx context.Context) (*http.Request, error) {
+		return c.signRequest(ctx, method, requestAddr, queries, body)
+	})
 	if err != nil {
-		return nil, 0, fmt.Errorf("bad request: %w", err)
+		return nil, 0, fmt.Errorf("do request err: %w", err)
+	}
+
+	logger.Log("doRequest", method, "response status", strconv.Itoa(response.StatusCode), "response body", logBody(response.Body))
+
+	return response.Body, response.StatusCode, nil
+}
+
+// logBodyLimit 日志中请求体和响应体的最大长度，图生图的参考图和返回的图片以base64传输，可达数MB
+const logBodyLimit = 2048
+
+func logBody(body []byte) string {
+	if len(body) > logBodyLimit {
+		return string(body[:logBodyLimit]) + "...(" + strconv.Itoa(len(body)) + " bytes)"
+	}
+	return string(body)
+}
+
+// signRequest 构建带签名的请求
+func (c *JimengClient) signRequest(ctx context.Context, method, requestAddr string, queries url.Values, body []byte) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, requestAddr, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("bad request: %w", err)
 	}
 
 	// 构建签名材料
@@ -194,28 +243,11 @@ func (c *JimengClient) doRequest(method string, action string, version string, q
 		", Signature=" + signature
 
 	request.Header.Set("Authorization", authorization)
-
-	// 发起请求
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, 0, fmt.Errorf("do request err: %w", err)
-	}
-	defer response.Body.Close()
-
-	// 读取响应
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, 0, fmt.Errorf("read response body err: %w", err)
-	}
-
-	logger.Log("doRequest", method, "response status", response.Status, "response body", string(bodyBytes))
-
-	return bodyBytes, response.StatusCode, nil
+	return request, nil
 }
 
 // 提交文生图、图生图任务
-func (c *JimengClient) SubmitTask(prompt string, options map[string]interface{},
-	action string, version string, reqKey string) (string, error) {
+func (c *JimengClient) SubmitTask(ctx context.Context, prompt string, options map[string]interface{}, reqKey string) (string, error) {
 	// 构建请求体
 	reqBody := SubmitTaskRequest{
 		ReqKey: reqKey,
@@ -237,7 +269,12 @@ func (c *JimengClient) SubmitTask(prompt string, options map[string]interface{},
 	}
 	if imageUrls, ok := options["image_urls"].([]string); ok {
 		if len(imageUrls) == 1 {
-			reqBody.ImageUrls = imageUrls
+			// data URL 形式的参考图（如上一次即梦返回的base64图片）改用 binary_data_base64 提交
+			if _, data, ok := strings.Cut(imageUrls[0], ";base64,"); ok && strings.HasPrefix(imageUrls[0], "data:") {
+				reqBody.BinaryDataBase64 = []string{data}
+			} else {
+				reqBody.ImageUrls = imageUrls
+			}
 		}
 	}
 
@@ -247,7 +284,7 @@ func (c *JimengClient) SubmitTask(prompt string, options map[string]interface{},
 	}
 
 	// 发送请求
-	responseBody, statusCode, err := c.doRequest("POST", SubmitAction, Version, url.Values{}, reqBodyStr)
+	responseBody, statusCode, err := c.doRequest(ctx, "POST", SubmitAction, Version, url.Values{}, reqBodyStr)
 	if err != nil {
 		return "", fmt.Errorf("request error: %w", err)
 	}
@@ -272,12 +309,15 @@ func (c *JimengClient) SubmitTask(prompt string, options map[string]interface{},
 	return response.Data.TaskID, nil
 }
 
-func (c *JimengClient) QueryTaskInCircle(reqKey string, taskID string) (string, error) {
+// QueryTaskInCircle 每2秒查询一次任务直到返回图片，不会超过ctx的截止时间
+func (c *JimengClient) QueryTaskInCircle(ctx context.Context, reqKey string, taskID string) (string, error) {
 	startTime := time.Now()
-	time.Sleep(2 * time.Second)
 	logger.Log("QueryTaskInCircle", reqKey, taskID, "starttime", startTime.Format("2006-01-02 15:04:05"))
 	for {
-		queryTaskResponse, err := c.QueryTask(reqKey, taskID)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return "", fmt.Errorf("wait jimeng task %s: %w", taskID, err)
+		}
+		queryTaskResponse, err := c.QueryTask(ctx, reqKey, taskID)
 		if err != nil {
 			return "", err
 		}
@@ -285,12 +325,11 @@ func (c *JimengClient) QueryTaskInCircle(reqKey string, taskID string) (string,
 			logger.Log("QueryTaskInCircle", reqKey, taskID, "remaintime", time.Since(startTime).String())
 			return queryTaskResponse.Data.ImageUrls[0], nil
 		}
-		time.Sleep(2 * time.Second)
 	}
 }
 
 // 查询任务状态
-func (c *JimengClient) QueryTask(reqKey string, taskID string) (*QueryTaskResponse, error) {
+func (c *JimengClient) QueryTask(ctx context.Context, reqKey string, taskID string) (*QueryTaskResponse, error) {
 	// 构建请求体
 	reqBody := QueryTaskRequest{
 		ReqKey:  reqKey,
@@ -304,7 +343,7 @@ func (c *JimengClient) QueryTask(reqKey string, taskID string) (*QueryTaskRespon
 	}
 
 	// 发送请求
-	responseBody, statusCode, err := c.doRequest("POST", QueryAction, Version, url.Values{}, reqBodyStr)
+	responseBody, statusCode, err := c.doRequest(ctx, "POST", QueryAction, Version, url.Values{}, reqBodyStr)
 	if err != nil {
 		return nil, fmt.Errorf("request error: %w", err)
 	}
@@ -328,3 +367,58 @@ func (c *JimengClient) QueryTask(reqKey string, taskID string) (*QueryTaskRespon
 
 	return &response, nil
 }
+
+// 即梦任务状态，in_queue 和 generating 之外的状态都是终态
+const (
+	JimengTaskInQueue    = "in_queue"
+	JimengTaskGenerating = "generating"
+	JimengTaskDone       = "done"
+	JimengTaskNotFound   = "not_found"
+	JimengTaskExpired    = "expired"
+)
+
+// GenerateImageContext 提交文生图（imageURL为空时）或图生图任务并轮询结果，返回图片链接；
+// 结果只有 binary_data_base64 时返回 data URL。imageURL 可以是 data URL。
+// 用作Seedream不可用时的备用出图，调用方需用ctx限制总时长，轮询不会超过ctx的截止时间
+func (c *JimengClient) GenerateImageContext(ctx context.Context, reqKey string, prompt string, imageURL string) (string, error) {
+	options := map[string]interface{}{"width": 1152, "height": 2048}
+	if imageURL != "" {
+		options["image_urls"] = []string{imageURL}
+	}
+	taskID, err := c.SubmitTask(ctx, prompt, options, reqKey)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return "", fmt.Errorf("wait jimeng task %s: %w", taskID, err)
+		}
+		response, err := c.QueryTask(ctx, reqKey, taskID)
+		if err != nil {
+			return "", err
+		}
+		switch response.Data.Status {
+		case JimengTaskInQueue, JimengTaskGenerating:
+		case JimengTaskDone:
+			if len(response.Data.ImageUrls) > 0 {
+				return response.Data.ImageUrls[0], nil
+			}
+			if len(response.Data.BinaryDataBase64) > 0 {
+				return jimengDataURL(response.Data.BinaryDataBase64[0])
+			}
+			return "", fmt.Errorf("jimeng task %s returned no images", taskID)
+		default:
+			// not_found、expired 以及文档之外的状态，继续轮询不会有结果
+			return "", fmt.Errorf("jimeng task %s %s", taskID, response.Data.Status)
+		}
+	}
+}
+
+// jimengDataURL 把返回的base64图片转为 data URL，MIME类型按内容判断
+func jimengDataURL(data string) (string, error) {
+	image, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("decode jimeng image: %w", err)
+	}
+	return "data:" + http.DetectContentType(image) + ";base64," + data, nil
+}
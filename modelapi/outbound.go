@@ -0,0 +1,363 @@
+package modelapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fairytale-creator/logger"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 服务商名称，每个服务商使用独立的出站限流
+const (
+	ProviderDeepSeek  = "deepseek"
+	ProviderSeedream  = "seedream"
+	ProviderJimeng    = "jimeng"
+	ProviderCosyVoice = "cosyvoice"
+	ProviderVolcTTS   = "volctts"
+	ProviderD1        = "d1"
+	ProviderR2        = "r2"
+)
+
+var ErrInvalidProviderLimit = errors.New("invalid provider limit")
+
+// ProviderLimit 调用服务商的速率和并发上限，为0表示不限制
+type ProviderLimit struct {
+	QPS         float64
+	Concurrency int
+}
+
+// RetryPolicy 重试策略：第n次重试前等待 BaseDelay*2^n（不超过MaxDelay）并加最多一半的随机抖动
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy 未调用 ConfigureOutbound 时的重试策略
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// backoff 第attempt次重试（从0开始）前的等待时间
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// Outbound 调用一个服务商的出站客户端：限制QPS和并发，可重试的失败按退避间隔重试，
+// 等待不会超过ctx的截止时间；持续失败时熔断，直接返回 ErrCircuitOpen。
+// HTTP客户端通过 Do 调用，CosyVoice的WebSocket会话和R2的S3请求通过 Call 调用
+type Outbound struct {
+	Name  string
+	Retry RetryPolicy
+	// Throttled 判断响应体是否为服务商的限流错误（部分服务商限流时仍返回200），为nil时只按状态码判断
+	Throttled func(body []byte) bool
+	limit     ProviderLimit
+	breaker   *Breaker
+	sem       chan struct{}
+	mu        sync.Mutex
+	next      time.Time // 下一个请求最早可以发出的时间
+}
+
+func newOutbound(name string, limit ProviderLimit, retry RetryPolicy, breaker BreakerPolicy) *Outbound {
+	o := &Outbound{Name: name, Retry: retry, limit: limit, Throttled: throttleDetectors[name], breaker: newBreaker(breaker)}
+	if limit.Concurrency > 0 {
+		o.sem = make(chan struct{}, limit.Concurrency)
+	}
+	return o
+}
+
+// throttleDetectors 在响应体中返回限流错误的服务商
+var throttleDetectors = map[string]func(body []byte) bool{
+	ProviderJimeng: jimengThrottled,
+}
+
+var (
+	outboundMu     sync.Mutex
+	outbounds      = map[string]*Outbound{}
+	outboundLimits = map[string]ProviderLimit{}
+	outboundRetry  = DefaultRetryPolicy
+	breakerPolicy  = DefaultBreakerPolicy
+)
+
+// ConfigureOutbound 设置各服务商的限流以及统一的重试和熔断策略，需在调用服务商之前执行。
+// limits 格式为逗号分隔的 服务商=QPS[:并发]，如 deepseek=5:4,seedream=2:2，未列出的服务商不限制
+func ConfigureOutbound(limits string, retry RetryPolicy, breaker BreakerPolicy) error {
+	parsed := map[string]ProviderLimit{}
+	for _, item := range strings.Split(limits, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidProviderLimit, item)
+		}
+		qps, concurrency, hasConcurrency := strings.Cut(value, ":")
+		var limit ProviderLimit
+		var err error
+		if limit.QPS, err = strconv.ParseFloat(qps, 64); err != nil || limit.QPS < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidProviderLimit, item)
+		}
+		if hasConcurrency {
+			if limit.Concurrency, err = strconv.Atoi(concurrency); err != nil || limit.Concurrency < 0 {
+				return fmt.Errorf("%w: %s", ErrInvalidProviderLimit, item)
+			}
+		}
+		parsed[strings.TrimSpace(name)] = limit
+	}
+	if retry.MaxRetries < 0 || retry.BaseDelay <= 0 {
+		return fmt.Errorf("%w: invalid retry policy", ErrInvalidProviderLimit)
+	}
+	if breaker.FailureThreshold < 0 || (breaker.FailureThreshold > 0 && breaker.OpenTimeout <= 0) {
+		return fmt.Errorf("%w: invalid breaker policy", ErrInvalidProviderLimit)
+	}
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+	outboundLimits, outboundRetry, breakerPolicy = parsed, retry, breaker
+	outbounds = map[string]*Outbound{}
+	return nil
+}
+
+// GetOutbound 返回服务商的出站客户端，同一服务商共用限流
+func GetOutbound(name string) *Outbound {
+	outboundMu.Lock()
+	defer outboundMu.Unlock()
+	o, ok := outbounds[name]
+	if !ok {
+		o = newOutbound(name, outboundLimits[name], outboundRetry, breakerPolicy)
+		outbounds[name] = o
+	}
+	return o
+}
+
+// Acquire 等待QPS和并发额度，返回释放并发额度的函数。等待超过ctx的截止时间时直接返回错误
+func (o *Outbound) Acquire(ctx context.Context) (func(), error) {
+	if o.sem != nil {
+		select {
+		case o.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		if o.sem != nil {
+			<-o.sem
+		}
+	}
+	if o.limit.QPS > 0 {
+		o.mu.Lock()
+		now := time.Now()
+		slot := o.next
+		if slot.Before(now) {
+			slot = now
+		}
+		o.next = slot.Add(time.Duration(float64(time.Second) / o.limit.QPS))
+		o.mu.Unlock()
+		if err := sleepContext(ctx, slot.Sub(now)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// RetryableError 可以重试的失败，RetryAfter 为服务商要求的最短等待时间，
+// Throttled 表示服务商限流，服务商本身可用，不计入熔断
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+	Throttled  bool
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Call 在限流下执行fn，retryable 判断失败是否可以重试（为nil时只重试 RetryableError），
+// 按policy退避重试。熔断器打开时直接返回 ErrCircuitOpen；
+// 重试用尽或剩余时间不足以等待下一次重试时返回包装了 ErrProviderUnavailable 的最后一次错误。
+// 每次 Call 只向熔断器记录一个结果：重试用尽才计为失败，限流和不可重试的错误说明服务商可用，计为成功
+func (o *Outbound) Call(ctx context.Context, policy RetryPolicy, retryable func(error) bool, fn func() error) error {
+	if err := o.breaker.allow(); err != nil {
+		return fmt.Errorf("%w: %s", err, o.Name)
+	}
+	err := o.call(ctx, policy, retryable, fn)
+	switch {
+	case err == nil:
+		o.breaker.record(nil)
+	case ctx.Err() != nil:
+		o.breaker.cancel()
+	case errors.Is(err, ErrProviderUnavailable) && !isThrottled(err):
+		o.breaker.record(err)
+	default:
+		// 服务商正常响应了请求（如参数或鉴权错误，或者限流），不计为不可用
+		o.breaker.record(nil)
+	}
+	return err
+}
+
+func (o *Outbound) call(ctx context.Context, policy RetryPolicy, retryable func(error) bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		release, err := o.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn()
+		release()
+		if err == nil {
+			return nil
+		}
+		var retryErr *RetryableError
+		canRetry := errors.As(err, &retryErr)
+		if retryable != nil {
+			canRetry = retryable(err)
+		}
+		if ctx.Err() != nil || !canRetry {
+			return err
+		}
+		if attempt >= policy.MaxRetries {
+			return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+		}
+		wait := policy.backoff(attempt)
+		if retryErr != nil && retryErr.RetryAfter > wait {
+			wait = retryErr.RetryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			logger.Error(o.Name, "call failed, no time left to retry:", err.Error())
+			return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+		}
+		logger.Error(o.Name, "call failed, retry in", wait.String()+":", err.Error())
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// isThrottled 失败是否为服务商限流：Do 返回的限流错误、HTTP 429（如S3请求）或CosyVoice的Throttling错误
+func isThrottled(err error) bool {
+	var retryErr *RetryableError
+	if errors.As(err, &retryErr) && retryErr.Throttled {
+		return true
+	}
+	var response interface{ HTTPStatusCode() int }
+	if errors.As(err, &response) && response.HTTPStatusCode() == http.StatusTooManyRequests {
+		return true
+	}
+	var cosyErr *CosyVoiceError
+	return errors.As(err, &cosyErr) && strings.HasPrefix(cosyErr.Code, "Throttling")
+}
+
+// OutboundResponse 读取完毕的HTTP响应
+type OutboundResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Unavailable 状态码表示服务商限流或服务端错误，Do 重试用尽后仍可能返回这样的响应
+func (r *OutboundResponse) Unavailable() bool {
+	return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500
+}
+
+// Do 发送HTTP请求，网络错误、429、5xx和服务商的限流错误按 o.Retry 重试。
+// newRequest 每次重试都会调用以重新构建请求体。重试用尽时返回最后一次的响应，由调用方按状态码处理
+func (o *Outbound) Do(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) (*OutboundResponse, error) {
+	var last *OutboundResponse
+	err := o.Call(ctx, o.Retry, nil, func() error {
+		last = nil
+		req, err := newRequest(ctx)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			return &RetryableError{Err: err}
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return &RetryableError{Err: err}
+		}
+		last = &OutboundResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+		if last.Unavailable() {
+			return &RetryableError{Err: errors.New(o.Name + " returned " + resp.Status), RetryAfter: parseRetryAfter(resp.Header),
+				Throttled: resp.StatusCode == http.StatusTooManyRequests}
+		}
+		if o.Throttled != nil && o.Throttled(body) {
+			return &RetryableError{Err: errors.New(o.Name + " throttled: " + string(body)), RetryAfter: parseRetryAfter(resp.Header), Throttled: true}
+		}
+		return nil
+	})
+	var retryErr *RetryableError
+	if errors.As(err, &retryErr) && last != nil && ctx.Err() == nil {
+		return last, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// NewJSONRequest 构建带Bearer鉴权的JSON POST请求，供 Do 使用
+func NewJSONRequest(url, apiKey string, body []byte) func(ctx context.Context) (*http.Request, error) {
+	return func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		return req, nil
+	}
+}
+
+// parseRetryAfter 解析秒数或HTTP日期格式的 Retry-After
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package modelapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration // 不含抖动的等待时间，抖动不超过一半
+	}{
+		{"首次重试", RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, 0, time.Second},
+		{"指数增长", RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, 3, 8 * time.Second},
+		{"不超过上限", RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, 10, 30 * time.Second},
+		{"次数很大时不溢出", RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, 1000, 30 * time.Second},
+		{"没有上限", RetryPolicy{BaseDelay: 100 * time.Millisecond}, 4, 1600 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 50; i++ {
+				got := tt.policy.backoff(tt.attempt)
+				if got < tt.want || got > tt.want+tt.want/2 {
+					t.Fatalf("backoff(%d) = %v, want in [%v, %v]", tt.attempt, got, tt.want, tt.want+tt.want/2)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"未设置", "", 0, 0},
+		{"秒数", "5", 5 * time.Second, 5 * time.Second},
+		{"零秒", "0", 0, 0},
+		{"负数", "-3", 0, 0},
+		{"无法解析", "soon", 0, 0},
+		{"HTTP日期", time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			if got := parseRetryAfter(header); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want in [%v, %v]", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// restoreOutbound 测试结束后恢复全局的出站配置
+func restoreOutbound(t *testing.T) {
+	outboundMu.Lock()
+	limits, retry, breaker := outboundLimits, outboundRetry, breakerPolicy
+	outboundMu.Unlock()
+	t.Cleanup(func() {
+		outboundMu.Lock()
+		outboundLimits, outboundRetry, breakerPolicy = limits, retry, breaker
+		outbounds = map[string]*Outbound{}
+		outboundMu.Unlock()
+	})
+}
+
+func TestConfigureOutbound(t *testing.T) {
+	restoreOutbound(t)
+	retry := RetryPolicy{MaxRetries: 2, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		name    string
+		limits  string
+		retry   RetryPolicy
+		breaker BreakerPolicy
+		want    map[string]ProviderLimit
+		wantErr bool
+	}{
+		{name: "空配置", limits: "", retry: retry, want: map[string]ProviderLimit{}},
+		{
+			name:   "QPS和并发",
+			limits: "deepseek=5:4, seedream=0.5 ,r2=20:8",
+			retry:  retry,
+			want: map[string]ProviderLimit{
+				"deepseek": {QPS: 5, Concurrency: 4},
+				"seedream": {QPS: 0.5},
+				"r2":       {QPS: 20, Concurrency: 8},
+			},
+		},
+		{name: "缺少等号", limits: "deepseek", retry: retry, wantErr: true},
+		{name: "QPS无法解析", limits: "deepseek=x", retry: retry, wantErr: true},
+		{name: "QPS为负数", limits: "deepseek=-1", retry: retry, wantErr: true},
+		{name: "并发无法解析", limits: "deepseek=1:x", retry: retry, wantErr: true},
+		{name: "重试次数为负数", retry: RetryPolicy{MaxRetries: -1, BaseDelay: time.Second}, wantErr: true},
+		{name: "重试间隔为0", retry: RetryPolicy{MaxRetries: 1}, wantErr: true},
+		{name: "熔断阈值为负数", retry: retry, breaker: BreakerPolicy{FailureThreshold: -1}, wantErr: true},
+		{name: "熔断缺少打开时长", retry: retry, breaker: BreakerPolicy{FailureThreshold: 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ConfigureOutbound(tt.limits, tt.retry, tt.breaker)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidProviderLimit) {
+					t.Fatalf("ConfigureOutbound() error = %v, want ErrInvalidProviderLimit", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigureOutbound() error = %v", err)
+			}
+			for name, want := range tt.want {
+				o := GetOutbound(name)
+				if o.limit != want || o.Retry != tt.retry {
+					t.Errorf("GetOutbound(%s) = %+v %+v, want %+v %+v", name, o.limit, o.Retry, want, tt.retry)
+				}
+			}
+			if o := GetOutbound(ProviderJimeng); tt.want[ProviderJimeng] == (ProviderLimit{}) && o.limit != (ProviderLimit{}) {
+				t.Errorf("unlisted provider limit = %+v, want none", o.limit)
+			}
+		})
+	}
+}
+
+func TestOutboundCall(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	errBad := errors.New("bad request")
+	tests := []struct {
+		name      string
+		results   []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"成功", []error{nil}, 1, nil},
+		{"重试后成功", []error{&RetryableError{Err: errBad}, nil}, 2, nil},
+		{"不可重试的错误", []error{errBad}, 1, errBad},
+		{"重试用尽", []error{&RetryableError{Err: errBad}, &RetryableError{Err: errBad}, &RetryableError{Err: errBad}}, 3, ErrProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOutbound("test", ProviderLimit{}, policy, BreakerPolicy{})
+			calls := 0
+			err := o.Call(context.Background(), policy, nil, func() error {
+				calls++
+				return tt.results[calls-1]
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("Call() made %d calls, want %d", calls, tt.wantCalls)
+			}
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("Call() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
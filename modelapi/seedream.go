@@ -1,9 +1,9 @@
 package modelapi
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"io"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -16,6 +16,7 @@ type DoubaoSeedreamClient struct {
 	APIKey     string
 	BaseURL    string
 	HttpClient *http.Client
+	Usage      SeedreamUsage // 最近一次调用的用量，用于计费
 }
 
 // ImageGenerationRequest 图像生成请求结构体
@@ -48,11 +49,14 @@ type ImageGenerationResponse struct {
 		URL  string `json:"url"`
 		Size string `json:"size"`
 	} `json:"data"`
-	Usage struct {
-		GeneratedImages int `json:"generated_images"`
-		OutputTokens    int `json:"output_tokens"`
-		TotalTokens     int `json:"total_tokens"`
-	} `json:"usage"`
+	Usage SeedreamUsage `json:"usage"`
+}
+
+// SeedreamUsage 一次生成的用量，按生成的图片数计费
+type SeedreamUsage struct {
+	GeneratedImages int `json:"generated_images"`
+	OutputTokens    int `json:"output_tokens"`
+	TotalTokens     int `json:"total_tokens"`
 }
 
 // NewDoubaoSeedreamClient 创建新的 Doubao Seedream 客户端
@@ -66,6 +70,12 @@ func NewDoubaoSeedreamClient(apiKey string) *DoubaoSeedreamClient {
 
 // GenerateImage 生成图像（支持可选 image 参数）
 func (c *DoubaoSeedreamClient) GenerateImage(prompt string, imageURL *string) (*ImageGenerationResponse, error) {
+	return c.GenerateImageContext(context.Background(), prompt, imageURL)
+}
+
+// GenerateImageContext 同 GenerateImage，限流等待和重试不会超过ctx的截止时间
+func (c *DoubaoSeedreamClient) GenerateImageContext(ctx context.Context, prompt string, imageURL *string) (*ImageGenerationResponse, error) {
+	c.Usage = SeedreamUsage{}
 	// 构建请求体
 	var requestBody interface{}
 	if imageURL != nil && *imageURL != "" {
@@ -97,38 +107,22 @@ func (c *DoubaoSeedreamClient) GenerateImage(prompt string, imageURL *string) (*
 		return nil, err
 	}
 
-	// 创建 HTTP 请求
-	req, err := http.NewRequest("POST", c.BaseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.Error("failed to create request: " + err.Error())
-		return nil, err
-	}
-
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-
-	// 发送请求
-	resp, err := c.HttpClient.Do(req)
+	// 发送请求，限流和服务端错误自动重试
+	resp, err := GetOutbound(ProviderSeedream).Do(ctx, c.HttpClient, NewJSONRequest(c.BaseURL, c.APIKey, jsonData))
 	if err != nil {
 		logger.Error("failed to send request: " + err.Error())
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	// 检查响应状态码
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Error("API request failed with status " + strconv.Itoa(resp.StatusCode) + ": " + string(body))
-		return nil, err
-	}
-
-	// 读取响应体
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logger.Error("failed to read response body: " + err.Error())
-		return nil, err
+		logger.Error("API request failed with status " + strconv.Itoa(resp.StatusCode) + ": " + string(resp.Body))
+		if resp.Unavailable() {
+			return nil, fmt.Errorf("%w: seedream returned HTTP %d", ErrProviderUnavailable, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("seedream returned HTTP %d", resp.StatusCode)
 	}
+	body := resp.Body
 	logger.Log("generate image response", string(body))
 
 	// 解析响应
@@ -138,11 +132,12 @@ func (c *DoubaoSeedreamClient) GenerateImage(prompt string, imageURL *string) (*
 		logger.Error("failed to unmarshal response: " + err.Error())
 		return nil, err
 	}
+	c.Usage = response.Usage
 
 	// 检查是否有生成的图像
 	if len(response.Data) == 0 {
 		logger.Error("no images generated")
-		return nil, err
+		return nil, fmt.Errorf("seedream returned no images")
 	}
 
 	return &response, nil
@@ -160,7 +155,12 @@ func (c *DoubaoSeedreamClient) GenerateImageFromPromptAndImage(prompt string, im
 
 // GenerateImageAndGetURL 生成图像并返回 URL（简化方法）
 func (c *DoubaoSeedreamClient) GenerateImageAndGetURL(prompt string, imageURL *string) (string, error) {
-	response, err := c.GenerateImage(prompt, imageURL)
+	return c.GenerateImageAndGetURLContext(context.Background(), prompt, imageURL)
+}
+
+// GenerateImageAndGetURLContext 同 GenerateImageAndGetURL，可通过ctx取消
+func (c *DoubaoSeedreamClient) GenerateImageAndGetURLContext(ctx context.Context, prompt string, imageURL *string) (string, error) {
+	response, err := c.GenerateImageContext(ctx, prompt, imageURL)
 	if err != nil {
 		return "", err
 	}
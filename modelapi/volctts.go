@@ -0,0 +1,162 @@
+package modelapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fairytale-creator/util"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// VolcTTSSuccessCode 火山引擎语音合成成功的返回码
+const VolcTTSSuccessCode = 3000
+
+// VolcTTSClient 火山引擎（豆包）语音合成HTTP接口客户端，用作CosyVoice不可用时的备用朗读
+type VolcTTSClient struct {
+	AppID       string
+	AccessToken string
+	Cluster     string
+	Voice       string
+	BaseURL     string
+	ChunkSize   int // 单次请求的最大字数，接口限制为1024字节
+	HttpClient  *http.Client
+	// SubmittedCharacters 已提交的文本字数，包括重试时重新提交的，用于计费
+	SubmittedCharacters int
+}
+
+type volcTTSRequest struct {
+	App struct {
+		AppID   string `json:"appid"`
+		Token   string `json:"token"`
+		Cluster string `json:"cluster"`
+	} `json:"app"`
+	User struct {
+		UID string `json:"uid"`
+	} `json:"user"`
+	Audio struct {
+		VoiceType  string  `json:"voice_type"`
+		Encoding   string  `json:"encoding"`
+		SpeedRatio float64 `json:"speed_ratio"`
+		PitchRatio float64 `json:"pitch_ratio"`
+	} `json:"audio"`
+	Request struct {
+		ReqID     string `json:"reqid"`
+		Text      string `json:"text"`
+		Operation string `json:"operation"`
+	} `json:"request"`
+}
+
+type volcTTSResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"` // base64编码的音频
+}
+
+func NewVolcTTSClient(appID, accessToken, cluster, voice string) *VolcTTSClient {
+	return &VolcTTSClient{
+		AppID:       appID,
+		AccessToken: accessToken,
+		Cluster:     cluster,
+		Voice:       voice,
+		BaseURL:     "https://openspeech.bytedance.com/api/v1/tts",
+		ChunkSize:   300,
+		HttpClient:  &http.Client{},
+	}
+}
+
+// SynthesizeSegmentsContext 按顺序合成各段文本并拼接为一个mp3文件。备用朗读统一使用 c.Voice，
+// 只保留各段的语速和音调。全部成功才写入outputFile
+func (c *VolcTTSClient) SynthesizeSegmentsContext(ctx context.Context, segments []SpeechSegment, outputFile string) error {
+	dir := filepath.Dir(outputFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建输出目录失败: %w", err)
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(outputFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(file.Name())
+	received := 0
+	for i, segment := range segments {
+		for _, text := range util.ChunkText(segment.Text, c.ChunkSize) {
+			audio, err := c.synthesize(ctx, text, segment.Rate, segment.Pitch)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("第%d段合成失败: %w", i+1, err)
+			}
+			if _, err := file.Write(audio); err != nil {
+				file.Close()
+				return fmt.Errorf("写入音频失败: %w", err)
+			}
+			received += len(audio)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("写入音频失败: %w", err)
+	}
+	if received == 0 {
+		return ErrTTSEmptyAudio
+	}
+	if err := os.Rename(file.Name(), outputFile); err != nil {
+		return fmt.Errorf("保存音频失败: %w", err)
+	}
+	return nil
+}
+
+// synthesize 合成一段不超过接口长度限制的文本，返回mp3音频
+func (c *VolcTTSClient) synthesize(ctx context.Context, text string, rate, pitch float64) ([]byte, error) {
+	var req volcTTSRequest
+	req.App.AppID, req.App.Token, req.App.Cluster = c.AppID, c.AccessToken, c.Cluster
+	req.User.UID = "fairytale-creator"
+	req.Audio.VoiceType, req.Audio.Encoding = c.Voice, "mp3"
+	req.Audio.SpeedRatio, req.Audio.PitchRatio = ratio(rate), ratio(pitch)
+	req.Request.ReqID, req.Request.Text, req.Request.Operation = uuid.NewString(), text, "query"
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := GetOutbound(ProviderVolcTTS).Do(ctx, c.HttpClient, func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := NewJSONRequest(c.BaseURL, "", body)(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// 每次重试都重新提交文本，都计入字数
+		c.SubmittedCharacters += utf8.RuneCountInString(text)
+		// 鉴权头的格式为 "Bearer;token"
+		httpReq.Header.Set("Authorization", "Bearer;"+c.AccessToken)
+		return httpReq, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Unavailable() {
+		return nil, fmt.Errorf("%w: volctts returned HTTP %d", ErrProviderUnavailable, resp.StatusCode)
+	}
+	var result volcTTSResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		return nil, fmt.Errorf("%w: HTTP %d", ErrTTSTaskFailed, resp.StatusCode)
+	}
+	if result.Code != VolcTTSSuccessCode {
+		kind := ErrTTSTaskFailed
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			kind = ErrTTSAuth
+		}
+		return nil, fmt.Errorf("%w: %d %s", kind, result.Code, result.Message)
+	}
+	return base64.StdEncoding.DecodeString(result.Data)
+}
+
+// ratio 语速、音调为0时按1处理
+func ratio(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	return v
+}
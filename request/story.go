@@ -1,6 +1,32 @@
 package request
 
+type DeleteStoryReq struct {
+	ID uint `json:"id"`
+}
+
+type PublishStoryReq struct {
+	ID        uint `json:"id"`
+	Published bool `json:"published"` // false 表示撤回发布
+}
+
 type GenerateVoiceReq struct {
 	Text     string `json:"text"`
 	Filename string `json:"filename"`
 }
+
+type ReorderChaptersReq struct {
+	StoryID    uint   `json:"story_id"`
+	ChapterIDs []uint `json:"chapter_ids"`
+}
+
+type InsertChapterReq struct {
+	StoryID  uint   `json:"story_id"`
+	Position int    `json:"position"` // 从1开始，超出范围时追加到末尾
+	Title    string `json:"title"`
+	Content  string `json:"content"`
+}
+
+type DeleteChapterReq struct {
+	StoryID   uint `json:"story_id"`
+	ChapterID uint `json:"chapter_id"`
+}
@@ -4,3 +4,49 @@ type LoginReq struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+type RegisterReq struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordReq struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ResetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type CreateInviteReq struct {
+	Role        string `json:"role"`
+	WorkspaceID uint   `json:"workspace_id"` // 注册后加入的工作区，为0时为默认工作区
+}
+
+type CreateResetTokenReq struct {
+	UserID uint `json:"user_id"`
+}
+
+type SetRoleReq struct {
+	UserID uint   `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type RevokeSessionReq struct {
+	ID     uint `json:"id"`      // 为0时撤销除当前会话外的所有会话
+	UserID uint `json:"user_id"` // 管理员可以指定用户，为0时为当前用户
+}
+
+type CreateAPIKeyReq struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`           // read / generate / admin
+	ExpiresInDays int    `json:"expires_in_days"` // 为0时不过期
+}
+
+type RevokeAPIKeyReq struct {
+	ID     uint `json:"id"`
+	UserID uint `json:"user_id"` // 管理员可以指定用户，为0时为当前用户
+}
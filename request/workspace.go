@@ -0,0 +1,33 @@
+package request
+
+import "encoding/json"
+
+type CreateWorkspaceReq struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// UpdateWorkspaceReq 为nil的字段不修改，字符串置空表示恢复使用全局配置
+type UpdateWorkspaceReq struct {
+	Name                  *string          `json:"name"`
+	Themes                *[]string        `json:"themes"`
+	Styles                *[]string        `json:"styles"`
+	VoiceCasting          *json.RawMessage `json:"voice_casting"`
+	DailyStoryQuota       *int             `json:"daily_story_quota"`
+	MonthlyStoryQuota     *int             `json:"monthly_story_quota"`
+	MonthlyCostQuota      *float64         `json:"monthly_cost_quota"`
+	DeepSeekAPIKey        *string          `json:"deepseek_api_key"`
+	DoubaoSeedreamAPIKey  *string          `json:"doubao_seedream_api_key"`
+	CosyVoiceAPIKey       *string          `json:"cosy_voice_api_key"`
+	JimengAccessKeyID     *string          `json:"jimeng_access_key_id"`
+	JimengSecretAccessKey *string          `json:"jimeng_secret_access_key"`
+}
+
+type SetMemberReq struct {
+	UserID uint   `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type RemoveMemberReq struct {
+	UserID uint `json:"user_id"`
+}
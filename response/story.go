@@ -1,19 +1,82 @@
 package response
 
 type Story struct {
-	Title       string    `json:"title"`
-	Author      string    `json:"author"`
-	Description string    `json:"description"`
-	Chapters    []Chapter `json:"chapters"`
-	MusicStyle  string    `json:"music_style"` // 背景音乐风格描述
-	CreatedAt   string    `json:"created_at"`  // 创建日期，用于确保唯一性
+	Title          string          `json:"title"`
+	Author         string          `json:"author"`
+	Description    string          `json:"description"`
+	Characters     []Character     `json:"characters,omitempty"`     // 故事中出现的角色，用于分配配音
+	Pronunciations []Pronunciation `json:"pronunciations,omitempty"` // 容易读错的词语及其拼音
+	Chapters       []Chapter       `json:"chapters"`
+	MusicStyle     string          `json:"music_style"` // 背景音乐风格描述
+	CreatedAt      string          `json:"created_at"`  // 创建日期，用于确保唯一性
+}
+
+type Character struct {
+	Name        string `json:"name"`
+	Gender      string `json:"gender"` // male / female
+	Age         string `json:"age"`    // child / adult / elder
+	Description string `json:"description"`
 }
 
 type Chapter struct {
+	Title         string    `json:"title"`
+	Content       string    `json:"content"`
+	Segments      []Segment `json:"segments,omitempty"` // 按说话人拆分的朗读片段
+	ImagePrompt   string    `json:"image_prompt"`
+	ChapterNumber int       `json:"chapter_number"`
+	ImagePath     string    `json:"image_path,omitempty"`
+	ImageHash     string    `json:"image_hash,omitempty"` // 图片内容SHA-256
+	ImageMime     string    `json:"image_mime,omitempty"`
+	ImageWidth    int       `json:"image_width,omitempty"`
+	ImageHeight   int       `json:"image_height,omitempty"`
+	ImageProvider string    `json:"image_provider,omitempty"` // 实际出图的服务商
+	VoicePath     string    `json:"voice_path,omitempty"`
+	VoiceOpusPath string    `json:"voice_opus_path,omitempty"`
+	VoiceAACPath  string    `json:"voice_aac_path,omitempty"`
+	VoiceProvider string    `json:"voice_provider,omitempty"` // 实际合成语音的服务商
+	DurationMs    int64     `json:"duration_ms,omitempty"`    // 语音时长（毫秒）
+}
+
+type Segment struct {
+	Speaker string `json:"speaker"` // 旁白 或 角色名
+	Text    string `json:"text"`
+}
+
+type Pronunciation struct {
+	Word   string `json:"word"`
+	Pinyin string `json:"pinyin"` // 数字声调拼音，如 "xing2"
+}
+
+// StoryDetail 故事详情接口返回的结构
+type StoryDetail struct {
+	ID          uint            `json:"id"`
+	Title       string          `json:"title"`
+	Author      string          `json:"author"`
+	Description string          `json:"description"`
+	MusicStyle  string          `json:"music_style"`
+	Status      int             `json:"status"`
+	CreatedAt   int64           `json:"created_at"`
+	Chapters    []ChapterDetail `json:"chapters"`
+}
+
+type ChapterDetail struct {
+	ID            uint   `json:"id"`
+	ChapterNumber int    `json:"chapter_number"`
 	Title         string `json:"title"`
 	Content       string `json:"content"`
-	ImagePrompt   string `json:"image_prompt"`
-	ChapterNumber int    `json:"chapter_number"`
-	ImagePath     string `json:"image_path,omitempty"`
-	VoicePath     string `json:"voice_path,omitempty"`
+	Image         Image  `json:"image"`
+	VoicePath     string `json:"voice_path"`
+	VoiceOpus     string `json:"voice_opus,omitempty"`
+	VoiceAAC      string `json:"voice_aac,omitempty"`
+	DurationMs    int64  `json:"duration_ms"`
+}
+
+// Image 章节图片的原图及各尺寸版本
+type Image struct {
+	Original string `json:"original"`
+	Thumb    string `json:"thumb,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	WebP     string `json:"webp,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
 }
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"fairytale-creator/modelapi"
+	"fairytale-creator/util"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type AssetService struct {
+}
+
+func NewAssetService() *AssetService {
+	return &AssetService{}
+}
+
+// DeleteStory 软删除工作区中的故事和章节，并删除只被该故事引用的本地文件和R2对象
+func (s *AssetService) DeleteStory(ctx context.Context, workspaceID, storyID uint) error {
+	if _, err := loadWorkspaceStory(workspaceID, storyID); err != nil {
+		return err
+	}
+	storyDao := database.NewStoryDao()
+	deleteStory := storyDao.SoftDeleteStoryFromD1
+	if flag.StoryStore == "mysql" {
+		deleteStory = storyDao.SoftDeleteStory
+	}
+	if err := deleteStory(storyID); err != nil {
+		return err
+	}
+	assetDao := database.NewAssetDao()
+	assets, err := assetDao.ListAssetsByStory(storyID)
+	if err != nil {
+		return err
+	}
+	// 先删除资源记录，剩下的有效记录就是其他故事的引用；文件删除中途失败时留给垃圾回收
+	if err := assetDao.DeleteAssetsByStory(storyID); err != nil {
+		return err
+	}
+	uploader, err := newR2Uploader()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	for _, asset := range assets {
+		if asset.ObjectKey != "" {
+			if inUse, err := assetDao.ObjectKeyInUse(asset.ObjectKey); err != nil || inUse {
+				continue
+			}
+			if err := uploader.DeleteObject(ctx, asset.ObjectKey); err != nil {
+				// 删除失败的对象会在下次垃圾回收时被清理
+				logger.Error(err.Error())
+			}
+		}
+		if asset.LocalPath != "" {
+			if inUse, err := assetDao.LocalPathInUse(asset.LocalPath); err != nil || inUse {
+				continue
+			}
+			if err := util.DeleteFileIfExist(asset.LocalPath); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// GCReport 垃圾回收结果
+type GCReport struct {
+	LocalFiles []string
+	Objects    []string
+	Bytes      int64
+}
+
+// CollectGarbage 找出未被任何有效资源记录或章节引用的本地文件和R2对象，dryRun为false时删除。
+// 修改时间在minAge以内的文件跳过，避免误删正在生成中的故事文件
+func (s *AssetService) CollectGarbage(ctx context.Context, minAge time.Duration, dryRun bool) (*GCReport, error) {
+	localRefs, localNames, objectRefs, err := s.references()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-minAge)
+	report := &GCReport{}
+
+	for _, root := range []string{flag.VoiceRoot, flag.ImageRoot} {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.ModTime().After(cutoff) || localRefs[filepath.Clean(p)] || localNames[filepath.Base(p)] {
+				return nil
+			}
+			report.LocalFiles = append(report.LocalFiles, p)
+			report.Bytes += info.Size()
+			if !dryRun {
+				if err := os.Remove(p); err != nil {
+					logger.Error(err.Error())
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uploader, err := newR2Uploader()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+	err = uploader.ListObjects(ctx, "", func(object modelapi.R2Object) error {
+		if objectRefs[object.Key] || object.LastModified.After(cutoff) {
+			return nil
+		}
+		report.Objects = append(report.Objects, object.Key)
+		report.Bytes += object.Size
+		if !dryRun {
+			if err := uploader.DeleteObject(ctx, object.Key); err != nil {
+				logger.Error(err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Log("gc dry-run:", strconv.FormatBool(dryRun), "local files:", strconv.Itoa(len(report.LocalFiles)),
+		"objects:", strconv.Itoa(len(report.Objects)), "bytes:", strconv.FormatInt(report.Bytes, 10))
+	return report, nil
+}
+
+// references 汇总仍被引用的本地文件和对象键：未删除的资源记录，
+// 以及D1中未删除章节的文件（兼容资源表建立之前上传的对象）。资源表建立之前的本地文件没有记录路径，
+// 按文件名判断：图片以内容哈希命名，早期对象键直接使用本地文件名
+func (s *AssetService) references() (localRefs, localNames, objectRefs map[string]bool, err error) {
+	localRefs, localNames, objectRefs = map[string]bool{}, map[string]bool{}, map[string]bool{}
+	assets, err := database.NewAssetDao().ListLiveAssets()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, asset := range assets {
+		if asset.LocalPath != "" {
+			localRefs[filepath.Clean(asset.LocalPath)] = true
+		}
+		if asset.ObjectKey != "" {
+			objectRefs[asset.ObjectKey] = true
+		}
+	}
+	chapters, err := database.NewChapterDao().ListAllChapterPathsFromD1()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, c := range chapters {
+		for _, key := range []string{c.ImagePath, c.VoicePath, c.VoiceOpusPath, c.VoiceAACPath} {
+			if key != "" {
+				objectRefs[key] = true
+				localNames[path.Base(key)] = true
+			}
+		}
+		if c.ImagePath != "" {
+			for _, key := range util.ImageDerivativeKeys(c.ImagePath) {
+				objectRefs[key] = true
+			}
+			// 尚未执行 migrate-keys 的旧故事，WebP衍生图仍在旧键下
+			if key := util.LegacyWebPKey(c.ImagePath); key != "" {
+				objectRefs[key] = true
+			}
+		}
+		if c.ImageHash != "" {
+			image := c.ImageHash + path.Ext(c.ImagePath)
+			localNames[image] = true
+			for _, name := range util.ImageDerivativeKeys(image) {
+				localNames[name] = true
+			}
+		}
+	}
+	return localRefs, localNames, objectRefs, nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"errors"
+	"fairytale-creator/flag"
+	"fairytale-creator/modelapi"
+	"fairytale-creator/util"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// newR2Uploader 按配置的存储桶创建R2上传器
+func newR2Uploader() (*modelapi.R2Uploader, error) {
+	return modelapi.NewR2Uploader(flag.CfAccountID, flag.R2AccessKeyID, flag.R2AccessKeySecret, flag.R2Bucket)
+}
+
+// objectKey 按模板生成对象键。n为章节序号（从1开始），name和ext取自原文件名，
+// 如模板 "workspaces/{workspace_id}/stories/{story_id}/chapters/{n}/image{ext}" 生成 "workspaces/1/stories/12/chapters/3/image.png"
+func objectKey(template string, workspaceID, storyID uint, n int, filename string) string {
+	ext := path.Ext(filename)
+	return strings.NewReplacer(
+		"{workspace_id}", strconv.FormatUint(uint64(workspaceID), 10),
+		"{story_id}", strconv.FormatUint(uint64(storyID), 10),
+		"{n}", strconv.Itoa(n),
+		"{name}", strings.TrimSuffix(path.Base(filename), ext),
+		"{ext}", ext,
+	).Replace(template)
+}
+
+// imageObjectKey 章节图片的对象键
+func imageObjectKey(workspaceID, storyID uint, n int, filename string) string {
+	return objectKey(flag.R2ImageKeyTemplate, workspaceID, storyID, n, filename)
+}
+
+// voiceObjectKey 章节语音的对象键，不同格式靠扩展名区分
+func voiceObjectKey(workspaceID, storyID uint, n int, filename string) string {
+	return objectKey(flag.R2VoiceKeyTemplate, workspaceID, storyID, n, filename)
+}
+
+var ErrKeyTemplateCollision = errors.New("对象键模板会生成重复的键")
+
+// ValidateKeyTemplates 检查图片和语音模板在不同故事、章节以及原图和衍生图之间不会生成相同的键。
+// 本地图片按内容哈希命名，不同故事可能是同一个文件，因此样例使用相同的文件名；
+// 模板缺少 {story_id}、{n} 时上传会互相覆盖，写库失败时也会删掉其他故事的对象
+func ValidateKeyTemplates() error {
+	seen := map[string]string{}
+	add := func(key, desc string) error {
+		if prev, ok := seen[key]; ok {
+			return fmt.Errorf("%w: %s 与 %s 都是 %s", ErrKeyTemplateCollision, prev, desc, key)
+		}
+		seen[key] = desc
+		return nil
+	}
+	for storyID := uint(1); storyID <= 2; storyID++ {
+		for n := 1; n <= 2; n++ {
+			name := fmt.Sprintf("故事%d章节%d", storyID, n)
+			image := imageObjectKey(1, storyID, n, "hash.png")
+			if err := add(image, "图片 "+name); err != nil {
+				return err
+			}
+			for kind, key := range util.ImageDerivativeKeys(image) {
+				if err := add(key, "图片 "+name+" 的 "+kind); err != nil {
+					return err
+				}
+			}
+			for _, ext := range []string{".mp3", ".opus", ".m4a"} {
+				if err := add(voiceObjectKey(1, storyID, n, "hash"+ext), "语音 "+name+"（"+ext+"）"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
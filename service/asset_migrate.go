@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"fairytale-creator/database"
+	"fairytale-creator/logger"
+	"fairytale-creator/util"
+	"strconv"
+)
+
+// KeyMove 一次对象键迁移
+type KeyMove struct {
+	ChapterID uint
+	From      string
+	To        string
+}
+
+// MigrateKeys 将D1中已有章节的对象按当前的键模板迁移：复制到新键、更新章节和资源记录后删除旧对象，
+// 旧命名的WebP衍生图一并迁移到新的衍生图键。
+// 已经符合模板的对象会跳过，因此中断后可以重复执行。dryRun为true时只返回迁移计划
+func (s *AssetService) MigrateKeys(ctx context.Context, dryRun bool) ([]KeyMove, error) {
+	chapterDao := database.NewChapterDao()
+	assetDao := database.NewAssetDao()
+	chapters, err := chapterDao.ListAllChapterPathsFromD1()
+	if err != nil {
+		return nil, err
+	}
+	stories, err := database.NewStoryDao().ListAllStoriesFromD1()
+	if err != nil {
+		return nil, err
+	}
+	workspaces := make(map[uint]uint, len(stories))
+	for _, story := range stories {
+		workspaces[story.ID] = story.WorkspaceID
+	}
+	uploader, err := newR2Uploader()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil, err
+	}
+
+	var moves []KeyMove
+	numbers := map[uint]int{}
+	for _, c := range chapters {
+		// 章节按id升序返回，与上传时的章节序号一致
+		numbers[c.StoryID]++
+		n := numbers[c.StoryID]
+		workspaceID := workspaces[c.StoryID]
+
+		var chapterMoves []KeyMove
+		plan := func(from, to string) {
+			if from != "" && from != to {
+				chapterMoves = append(chapterMoves, KeyMove{ChapterID: c.ID, From: from, To: to})
+			}
+		}
+		newImage := imageObjectKey(workspaceID, c.StoryID, n, c.ImagePath)
+		if c.ImagePath != "" {
+			plan(c.ImagePath, newImage)
+			newDerivatives := util.ImageDerivativeKeys(newImage)
+			for name, key := range util.ImageDerivativeKeys(c.ImagePath) {
+				plan(key, newDerivatives[name])
+			}
+			// 早期版本的WebP衍生图键与原图只差扩展名，迁移到带版本名的新键
+			plan(util.LegacyWebPKey(c.ImagePath), newDerivatives["webp"])
+		}
+		plan(c.VoicePath, voiceObjectKey(workspaceID, c.StoryID, n, c.VoicePath))
+		plan(c.VoiceOpusPath, voiceObjectKey(workspaceID, c.StoryID, n, c.VoiceOpusPath))
+		plan(c.VoiceAACPath, voiceObjectKey(workspaceID, c.StoryID, n, c.VoiceAACPath))
+		if len(chapterMoves) == 0 {
+			continue
+		}
+		if dryRun {
+			moves = append(moves, chapterMoves...)
+			continue
+		}
+
+		// 源对象不存在时（如旧故事没有衍生图）保留原键
+		moved := map[string]string{}
+		for _, m := range chapterMoves {
+			exists, err := uploader.ObjectExists(ctx, m.From)
+			if err != nil {
+				return moves, err
+			}
+			if !exists {
+				logger.Log("migrate keys: object not found, skip", m.From)
+				continue
+			}
+			if err := uploader.CopyObject(ctx, m.From, m.To); err != nil {
+				return moves, err
+			}
+			moved[m.From] = m.To
+		}
+		rename := func(key string) string {
+			if to, ok := moved[key]; ok {
+				return to
+			}
+			return key
+		}
+		updated := c
+		updated.ImagePath = rename(c.ImagePath)
+		updated.VoicePath = rename(c.VoicePath)
+		updated.VoiceOpusPath = rename(c.VoiceOpusPath)
+		updated.VoiceAACPath = rename(c.VoiceAACPath)
+		if err := chapterDao.UpdateChapterPathsInD1(&updated); err != nil {
+			return moves, err
+		}
+		for _, m := range chapterMoves {
+			if _, ok := moved[m.From]; !ok {
+				continue
+			}
+			if err := assetDao.UpdateObjectKey(m.From, m.To); err != nil {
+				return moves, err
+			}
+			// 数据库已指向新键，旧对象删除失败时留给垃圾回收
+			if err := uploader.DeleteObject(ctx, m.From); err != nil {
+				logger.Error(err.Error())
+			}
+			moves = append(moves, m)
+		}
+	}
+	logger.Log("migrate keys dry-run:", strconv.FormatBool(dryRun), "objects:", strconv.Itoa(len(moves)))
+	return moves, nil
+}
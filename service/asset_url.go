@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/modelapi"
+	"strings"
+	"sync"
+	"time"
+)
+
+type presignedURL struct {
+	url       string
+	expiresAt time.Time
+}
+
+var (
+	presignMutex  sync.Mutex
+	presignCache  = map[string]presignedURL{}
+	assetUploader *modelapi.R2Uploader
+)
+
+// getAssetUploader 复用同一个R2客户端生成预签名URL
+func getAssetUploader() (*modelapi.R2Uploader, error) {
+	presignMutex.Lock()
+	defer presignMutex.Unlock()
+	if assetUploader != nil {
+		return assetUploader, nil
+	}
+	uploader, err := newR2Uploader()
+	if err != nil {
+		return nil, err
+	}
+	assetUploader = uploader
+	return assetUploader, nil
+}
+
+// AssetURL 返回对象的访问地址。配置了 AssetBaseURL 时直接拼接CDN地址，否则返回预签名GET地址，
+// 签名结果会缓存，直到距过期不足 PresignRefreshMargin 时重新签名。空键返回空字符串
+func (s *AssetService) AssetURL(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	if flag.AssetBaseURL != "" {
+		return strings.TrimSuffix(flag.AssetBaseURL, "/") + "/" + key, nil
+	}
+	now := time.Now()
+	presignMutex.Lock()
+	cached, ok := presignCache[key]
+	presignMutex.Unlock()
+	if ok && now.Before(cached.expiresAt.Add(-flag.PresignRefreshMargin)) {
+		return cached.url, nil
+	}
+
+	uploader, err := getAssetUploader()
+	if err != nil {
+		return "", err
+	}
+	url, err := uploader.GeneratePresignedURL(ctx, key, flag.PresignTTL)
+	if err != nil {
+		return "", err
+	}
+	presignMutex.Lock()
+	presignCache[key] = presignedURL{url: url, expiresAt: now.Add(flag.PresignTTL)}
+	// 顺带清理已过期的缓存，避免无限增长
+	for k, v := range presignCache {
+		if now.After(v.expiresAt) {
+			delete(presignCache, k)
+		}
+	}
+	presignMutex.Unlock()
+	return url, nil
+}
+
+// PublishedAssetURL 供匿名访问，按 canReadStory 的规则仅当对象所属故事已发布时返回预签名地址
+func (s *AssetService) PublishedAssetURL(ctx context.Context, key string) (string, error) {
+	storyID, err := s.storyIDByKey(key)
+	if err != nil {
+		return "", err
+	}
+	story, err := loadStory(storyID)
+	if err != nil {
+		return "", err
+	}
+	if !canReadStory(story, 0) {
+		return "", database.RecordNotFoundError
+	}
+	return s.AssetURL(ctx, key)
+}
+
+// storyIDByKey 先查资源表，资源表建立之前上传的对象再到D1章节中查找
+func (s *AssetService) storyIDByKey(key string) (uint, error) {
+	asset, err := database.NewAssetDao().GetAssetByObjectKey(key)
+	if err == nil {
+		return asset.StoryID, nil
+	}
+	if !errors.Is(err, database.RecordNotFoundError) {
+		return 0, err
+	}
+	return database.NewChapterDao().GetStoryIDByPathFromD1(key)
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"fairytale-creator/flag"
+	"fairytale-creator/util"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// VoiceAssets 后处理后的章节语音文件
+type VoiceAssets struct {
+	MP3Path    string
+	OpusPath   string
+	AACPath    string
+	DurationMs int64
+}
+
+// ProcessVoice 对合成的语音做后处理：去除首尾静音、响度归一化（原地替换MP3），
+// 按配置生成Opus/AAC版本，并探测时长。失败时删除已生成的Opus/AAC文件，原始MP3保留
+func (s *StoryService) ProcessVoice(voicePath string, sampleRate int) (_ *VoiceAssets, err error) {
+	assets := &VoiceAssets{MP3Path: voicePath}
+	var outputs []string
+	defer func() {
+		if err != nil {
+			for _, output := range outputs {
+				util.DeleteFileIfExist(output)
+			}
+		}
+	}()
+	if err := util.NormalizeAudio(voicePath, voicePath, flag.AudioTargetLUFS, sampleRate); err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(voicePath, filepath.Ext(voicePath))
+	for _, format := range strings.Split(flag.AudioFormats, ",") {
+		format = strings.TrimSpace(format)
+		if format == "" {
+			continue
+		}
+		if format != "opus" && format != "aac" {
+			return nil, fmt.Errorf("不支持的音频格式: %s", format)
+		}
+		output := base + util.AudioExtension(format)
+		if err := util.EncodeAudio(voicePath, output, format); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+		if format == "opus" {
+			assets.OpusPath = output
+		} else {
+			assets.AACPath = output
+		}
+	}
+	duration, err := util.ProbeAudioDuration(voicePath)
+	if err != nil {
+		return nil, err
+	}
+	assets.DurationMs = duration.Milliseconds()
+	return assets, nil
+}
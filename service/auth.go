@@ -0,0 +1,282 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"fairytale-creator/request"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	ContextRole           = "role"
+	ContextAuthMethod     = "auth_method"
+	ContextCredentialRole = "credential_role" // API Key或访问令牌的范围，会话登录时为 admin（不限制）
+	ContextBoundWorkspace = "bound_workspace" // API Key或访问令牌限定的工作区，0为不限定
+	ContextAPIKeyID       = "api_key_id"      // 使用API Key认证时为Key的id，用于按Key限流
+)
+
+const (
+	AuthMethodSession = "session"
+	AuthMethodAPIKey  = "api_key"
+	AuthMethodJWT     = "jwt"
+)
+
+// APIKeyPrefix API Key的固定前缀，用于和JWT区分
+const APIKeyPrefix = "fc_"
+
+const jwtIssuer = "fairytale-creator"
+
+var (
+	ErrUnauthenticated   = errors.New("unauthenticated")
+	ErrInvalidCredential = errors.New("invalid api key or access token")
+	ErrInvalidScope      = errors.New("invalid api key scope")
+	ErrScopeTooBroad     = errors.New("api key scope exceeds current role")
+	ErrTokenFromToken    = errors.New("access token cannot be exchanged for another token")
+)
+
+// 角色等级，高等级包含低等级的权限
+var roleRank = map[string]int{
+	database.RoleReader: 1,
+	database.RoleEditor: 2,
+	database.RoleAdmin:  3,
+}
+
+// scopeRoles API Key的范围对应的角色
+var scopeRoles = map[string]string{
+	database.APIKeyScopeRead:     database.RoleReader,
+	database.APIKeyScopeGenerate: database.RoleEditor,
+	database.APIKeyScopeAdmin:    database.RoleAdmin,
+}
+
+// RoleAtLeast 判断role是否不低于min
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] >= roleRank[min]
+}
+
+// lowerRole 返回两个角色中较低的一个
+func lowerRole(a, b string) string {
+	if roleRank[a] <= roleRank[b] {
+		return a
+	}
+	return b
+}
+
+// CurrentRole 返回本次请求的有效角色。经过工作区中间件时为在当前工作区的角色，否则为全局角色；
+// API Key和访问令牌的权限不超过签发时的范围
+func CurrentRole(c *gin.Context) string {
+	return c.GetString(ContextRole)
+}
+
+// Authenticate 认证请求。带 Authorization: Bearer 或 X-API-Key 头时只按API Key或JWT认证，
+// 否则使用会话。成功后把用户、有效角色和认证方式放入上下文
+func Authenticate(c *gin.Context) error {
+	credential := c.GetHeader("X-API-Key")
+	if credential == "" {
+		if auth := c.GetHeader("Authorization"); auth != "" {
+			scheme, value, _ := strings.Cut(auth, " ")
+			if !strings.EqualFold(scheme, "Bearer") || value == "" {
+				return ErrInvalidCredential
+			}
+			credential = strings.TrimSpace(value)
+		}
+	}
+
+	var userID, workspaceID, keyID uint
+	role, method := database.RoleAdmin, AuthMethodSession
+	switch {
+	case strings.HasPrefix(credential, APIKeyPrefix):
+		key, err := database.NewAPIKeyDao().GetAPIKeyByHash(hashToken(credential))
+		if errors.Is(err, database.RecordNotFoundError) {
+			return ErrInvalidCredential
+		}
+		if err != nil {
+			return err
+		}
+		if now := time.Now(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > sessionTouchInterval {
+			database.NewAPIKeyDao().TouchAPIKey(key.ID, now)
+		}
+		userID, role, method = key.UserID, scopeRoles[key.Scope], AuthMethodAPIKey
+		workspaceID, keyID = key.WorkspaceID, key.ID
+	case credential != "":
+		claims, err := parseAccessToken(credential)
+		if err != nil {
+			return ErrInvalidCredential
+		}
+		id, err := strconv.ParseUint(claims.Subject, 10, 64)
+		if err != nil {
+			return ErrInvalidCredential
+		}
+		userID, role, method = uint(id), claims.Role, AuthMethodJWT
+		workspaceID = claims.WorkspaceID
+	default:
+		userID = CurrentUserID(c)
+		if userID == 0 {
+			return ErrUnauthenticated
+		}
+	}
+
+	// 每次都重新读取用户，角色调整和删除用户立即生效
+	user, err := database.NewUserDao().GetUser(userID)
+	if errors.Is(err, database.RecordNotFoundError) {
+		return ErrUnauthenticated
+	}
+	if err != nil {
+		return err
+	}
+	c.Set(ContextUser, user)
+	c.Set(ContextRole, lowerRole(role, user.Role))
+	c.Set(ContextAuthMethod, method)
+	c.Set(ContextCredentialRole, role)
+	c.Set(ContextBoundWorkspace, workspaceID)
+	c.Set(ContextAPIKeyID, keyID)
+	return nil
+}
+
+// AccessClaims JWT访问令牌的声明，Subject 为用户id，Role 为权限范围，实际权限还受用户当前角色限制
+type AccessClaims struct {
+	Role        string `json:"role"`
+	WorkspaceID uint   `json:"workspace_id,omitempty"` // 限定的工作区，为空时可访问用户所属的所有工作区
+	jwt.RegisteredClaims
+}
+
+// AccessToken 签发的访问令牌
+type AccessToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // 秒
+}
+
+var (
+	jwtKeysOnce sync.Once
+	jwtKeys     [][]byte
+)
+
+// accessTokenKeys 读取JWT签名密钥，第一个用于签名，全部用于验证以便轮换；
+// 未配置时生成随机密钥，重启后已签发的令牌失效
+func accessTokenKeys() [][]byte {
+	jwtKeysOnce.Do(func() {
+		raw := flag.JWTKeys
+		if raw == "" {
+			raw = os.Getenv("JWT_KEYS")
+		}
+		for _, key := range strings.Split(raw, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				jwtKeys = append(jwtKeys, []byte(key))
+			}
+		}
+		if len(jwtKeys) == 0 {
+			logger.Error("未配置 -jwt-keys，使用随机密钥，重启后访问令牌失效")
+			jwtKeys = append(jwtKeys, securecookie.GenerateRandomKey(32))
+		}
+	})
+	return jwtKeys
+}
+
+// IssueAccessToken 为当前请求的用户签发短期访问令牌，权限范围和限定的工作区与本次请求的凭证相同。
+// 只能通过会话或API Key换取，令牌撤销前已签发的访问令牌在过期前仍然有效
+func IssueAccessToken(c *gin.Context) (*AccessToken, error) {
+	if c.GetString(ContextAuthMethod) == AuthMethodJWT {
+		return nil, ErrTokenFromToken
+	}
+	now := time.Now()
+	claims := AccessClaims{
+		Role:        c.GetString(ContextCredentialRole),
+		WorkspaceID: c.GetUint(ContextBoundWorkspace),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    jwtIssuer,
+			Subject:   strconv.FormatUint(uint64(CurrentUser(c).ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(flag.JWTTTL)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(accessTokenKeys()[0])
+	if err != nil {
+		return nil, err
+	}
+	return &AccessToken{AccessToken: signed, TokenType: "Bearer", ExpiresIn: int(flag.JWTTTL.Seconds())}, nil
+}
+
+func parseAccessToken(token string) (*AccessClaims, error) {
+	keySet := jwt.VerificationKeySet{}
+	for _, key := range accessTokenKeys() {
+		keySet.Keys = append(keySet.Keys, key)
+	}
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return keySet, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(jwtIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := roleRank[claims.Role]; !ok {
+		return nil, ErrInvalidCredential
+	}
+	return &claims, nil
+}
+
+type APIKeyService struct {
+}
+
+func NewAPIKeyService() APIKeyService {
+	return APIKeyService{}
+}
+
+// CreatedAPIKey 新建的API Key，Key 为密钥原文，只在创建时返回
+type CreatedAPIKey struct {
+	Key    string           `json:"key"`
+	APIKey *database.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey 为当前用户创建限定于当前工作区的API Key，范围不能超过在工作区中的有效角色
+func (p *APIKeyService) CreateAPIKey(c *gin.Context, req request.CreateAPIKeyReq) (*CreatedAPIKey, error) {
+	role, ok := scopeRoles[req.Scope]
+	if !ok {
+		return nil, ErrInvalidScope
+	}
+	if !RoleAtLeast(CurrentRole(c), role) {
+		return nil, ErrScopeTooBroad
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	key := APIKeyPrefix + hex.EncodeToString(raw)
+	apiKey := &database.APIKey{
+		UserID:      CurrentUser(c).ID,
+		WorkspaceID: CurrentWorkspace(c).ID,
+		Name:        truncate(req.Name, 64),
+		Prefix:      key[:len(APIKeyPrefix)+8],
+		KeyHash:     hashToken(key),
+		Scope:       req.Scope,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err := database.NewAPIKeyDao().AddAPIKey(apiKey); err != nil {
+		return nil, err
+	}
+	return &CreatedAPIKey{Key: key, APIKey: apiKey}, nil
+}
+
+// ListAPIKeys 列出用户在工作区中的API Key
+func (p *APIKeyService) ListAPIKeys(workspaceID, userID uint) ([]database.APIKey, error) {
+	return database.NewAPIKeyDao().ListAPIKeysByUser(workspaceID, userID)
+}
+
+// RevokeAPIKey 撤销用户在工作区中的API Key，不存在时返回 database.RecordNotFoundError
+func (p *APIKeyService) RevokeAPIKey(workspaceID, userID, id uint) error {
+	return database.NewAPIKeyDao().DeleteAPIKey(workspaceID, userID, id)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"encoding/json"
+	"fairytale-creator/modelapi"
+	"fairytale-creator/response"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const NarratorSpeaker = "旁白"
+
+// VoiceProfile 一个角色的配音参数
+type VoiceProfile struct {
+	Voice  string  `json:"voice"`
+	Rate   float64 `json:"rate"`
+	Pitch  float64 `json:"pitch"`
+	Gender string  `json:"gender,omitempty"` // 仅用于声音池匹配
+	Age    string  `json:"age,omitempty"`    // 仅用于声音池匹配
+}
+
+// VoiceCasting 配音选角表：旁白、指定角色，以及为未指定角色自动分配的声音池
+type VoiceCasting struct {
+	Narrator   VoiceProfile            `json:"narrator"`
+	Characters map[string]VoiceProfile `json:"characters"`
+	Pool       []VoiceProfile          `json:"pool"`
+}
+
+// DefaultVoiceCasting 内置选角表
+func DefaultVoiceCasting() *VoiceCasting {
+	return &VoiceCasting{
+		Narrator:   VoiceProfile{Voice: "longyuan_v2", Rate: 1, Pitch: 1},
+		Characters: map[string]VoiceProfile{},
+		Pool: []VoiceProfile{
+			{Voice: "longjielidou_v2", Rate: 1.05, Pitch: 1.1, Gender: "male", Age: "child"},
+			{Voice: "longhua_v2", Rate: 1.05, Pitch: 1.1, Gender: "female", Age: "child"},
+			{Voice: "longcheng_v2", Rate: 1, Pitch: 1, Gender: "male", Age: "adult"},
+			{Voice: "longxiaochun_v2", Rate: 1, Pitch: 1, Gender: "female", Age: "adult"},
+			{Voice: "longshu_v2", Rate: 0.95, Pitch: 0.9, Gender: "male", Age: "elder"},
+			{Voice: "longwan_v2", Rate: 0.95, Pitch: 0.95, Gender: "female", Age: "elder"},
+		},
+	}
+}
+
+// LoadVoiceCasting 从JSON文件加载选角表，路径为空时返回内置选角表
+func LoadVoiceCasting(filename string) (*VoiceCasting, error) {
+	casting := DefaultVoiceCasting()
+	if filename == "" {
+		return casting, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取选角表失败: %w", err)
+	}
+	return ParseVoiceCasting(data)
+}
+
+// ParseVoiceCasting 解析JSON格式的选角表，未给出的字段使用内置选角表的值
+func ParseVoiceCasting(data []byte) (*VoiceCasting, error) {
+	casting := DefaultVoiceCasting()
+	if err := json.Unmarshal(data, casting); err != nil {
+		return nil, fmt.Errorf("解析选角表失败: %w", err)
+	}
+	if casting.Characters == nil {
+		casting.Characters = map[string]VoiceProfile{}
+	}
+	return casting, nil
+}
+
+// Cast 为故事中的角色分配声音：优先使用选角表中的指定声音，
+// 其余角色按性别和年龄段从声音池中挑选尚未使用的声音，池用尽后循环复用
+func (v *VoiceCasting) Cast(characters []response.Character) map[string]VoiceProfile {
+	cast := map[string]VoiceProfile{NarratorSpeaker: v.Narrator}
+	used := map[string]bool{v.Narrator.Voice: true}
+	for _, character := range characters {
+		if profile, ok := v.Characters[character.Name]; ok {
+			cast[character.Name] = profile
+			used[profile.Voice] = true
+		}
+	}
+	next := 0
+	for _, character := range characters {
+		if _, ok := cast[character.Name]; ok {
+			continue
+		}
+		if len(v.Pool) == 0 {
+			cast[character.Name] = v.Narrator
+			continue
+		}
+		profile, ok := v.pick(character, used, true)
+		if !ok {
+			profile, ok = v.pick(character, used, false)
+		}
+		if !ok {
+			profile = v.Pool[next%len(v.Pool)]
+			next++
+		}
+		cast[character.Name] = profile
+		used[profile.Voice] = true
+	}
+	return cast
+}
+
+// pick 从声音池中挑选未使用的声音，strict为true时要求性别和年龄段都匹配，否则只匹配性别
+func (v *VoiceCasting) pick(character response.Character, used map[string]bool, strict bool) (VoiceProfile, bool) {
+	for _, profile := range v.Pool {
+		if used[profile.Voice] {
+			continue
+		}
+		if profile.Gender != "" && profile.Gender != character.Gender {
+			continue
+		}
+		if strict && profile.Age != "" && profile.Age != character.Age {
+			continue
+		}
+		return profile, true
+	}
+	return VoiceProfile{}, false
+}
+
+// Segments 将章节转换为待合成的片段，相邻且声音相同的片段会合并为一段。
+// 模型给出的片段拼接后与正文不一致（漏句、改写或为空）时，整章改用旁白朗读正文
+func (v *VoiceCasting) Segments(chapter response.Chapter, cast map[string]VoiceProfile) []modelapi.SpeechSegment {
+	var segments []modelapi.SpeechSegment
+	var joined strings.Builder
+	for _, s := range chapter.Segments {
+		if s.Text == "" {
+			continue
+		}
+		joined.WriteString(s.Text)
+		profile, ok := cast[s.Speaker]
+		if !ok {
+			profile = v.Narrator
+		}
+		n := len(segments)
+		if n > 0 && segments[n-1].Voice == profile.Voice && segments[n-1].Rate == profile.Rate && segments[n-1].Pitch == profile.Pitch {
+			segments[n-1].Text += s.Text
+			continue
+		}
+		segments = append(segments, v.segment(s.Text, profile))
+	}
+	if len(segments) == 0 || joined.String() != chapter.Content {
+		return []modelapi.SpeechSegment{v.segment(chapter.Content, v.Narrator)}
+	}
+	return segments
+}
+
+func (v *VoiceCasting) segment(text string, profile VoiceProfile) modelapi.SpeechSegment {
+	return modelapi.SpeechSegment{
+		Text:  text,
+		Voice: profile.Voice,
+		Rate:  profile.Rate,
+		Pitch: profile.Pitch,
+	}
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fairytale-creator/modelapi"
+	"fairytale-creator/response"
+	"reflect"
+	"testing"
+)
+
+func TestCastingSegments(t *testing.T) {
+	narrator := VoiceProfile{Voice: "narrator", Rate: 1, Pitch: 1}
+	fox := VoiceProfile{Voice: "fox", Rate: 1.1, Pitch: 1.2}
+	v := &VoiceCasting{Narrator: narrator}
+	cast := map[string]VoiceProfile{"狐狸": fox}
+	content := "从前有只狐狸。“你好。”它说。"
+	whole := []modelapi.SpeechSegment{{Text: content, Voice: "narrator", Rate: 1, Pitch: 1}}
+	tests := []struct {
+		name     string
+		segments []response.Segment
+		want     []modelapi.SpeechSegment
+	}{
+		{"没有片段", nil, whole},
+		{
+			name: "按说话人合成，相邻同声音合并",
+			segments: []response.Segment{
+				{Speaker: NarratorSpeaker, Text: "从前有只狐狸。"},
+				{Speaker: "狐狸", Text: "“你好。”"},
+				{Speaker: "未知角色", Text: "它"},
+				{Speaker: NarratorSpeaker, Text: "说。"},
+			},
+			want: []modelapi.SpeechSegment{
+				{Text: "从前有只狐狸。", Voice: "narrator", Rate: 1, Pitch: 1},
+				{Text: "“你好。”", Voice: "fox", Rate: 1.1, Pitch: 1.2},
+				{Text: "它说。", Voice: "narrator", Rate: 1, Pitch: 1},
+			},
+		},
+		{"片段全为空", []response.Segment{{Speaker: "狐狸", Text: ""}}, whole},
+		{
+			name: "漏句时改用旁白朗读正文",
+			segments: []response.Segment{
+				{Speaker: NarratorSpeaker, Text: "从前有只狐狸。"},
+				{Speaker: "狐狸", Text: "“你好。”"},
+			},
+			want: whole,
+		},
+		{
+			name: "改写时改用旁白朗读正文",
+			segments: []response.Segment{
+				{Speaker: NarratorSpeaker, Text: "从前有只小狐狸。"},
+				{Speaker: "狐狸", Text: "“你好。”它说。"},
+			},
+			want: whole,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := v.Segments(response.Chapter{Content: content, Segments: tt.segments}, cast)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Segments() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
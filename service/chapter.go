@@ -0,0 +1,54 @@
+package service
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+)
+
+// ErrChapterEditUnsupported 直接写入D1时不支持编辑章节
+var ErrChapterEditUnsupported = errors.New("chapter editing requires mysql story store")
+
+type ChapterService struct {
+}
+
+func NewChapterService() *ChapterService {
+	return &ChapterService{}
+}
+
+// ReorderChapters 按给定的章节id顺序重新编号
+func (s *ChapterService) ReorderChapters(workspaceID, storyID uint, chapterIDs []uint) error {
+	if err := checkEditable(workspaceID, storyID); err != nil {
+		return err
+	}
+	return database.NewChapterDao().ReorderChapters(workspaceID, storyID, chapterIDs)
+}
+
+// InsertChapter 在position处插入一个只有文字的章节，返回新章节
+func (s *ChapterService) InsertChapter(workspaceID, storyID uint, position int, title, content string) (*database.Chapter, error) {
+	if err := checkEditable(workspaceID, storyID); err != nil {
+		return nil, err
+	}
+	chapter := &database.Chapter{StoryID: storyID, Title: title, Content: content}
+	if err := database.NewChapterDao().InsertChapter(workspaceID, chapter, position); err != nil {
+		return nil, err
+	}
+	return chapter, nil
+}
+
+// DeleteChapter 删除章节并重新编号。章节的文件仍由资源记录引用，随故事删除时一并清理
+func (s *ChapterService) DeleteChapter(workspaceID, storyID, chapterID uint) error {
+	if err := checkEditable(workspaceID, storyID); err != nil {
+		return err
+	}
+	return database.NewChapterDao().DeleteChapter(workspaceID, storyID, chapterID)
+}
+
+// checkEditable 检查章节可以编辑且故事属于该工作区
+func checkEditable(workspaceID, storyID uint) error {
+	if flag.StoryStore != "mysql" {
+		return ErrChapterEditUnsupported
+	}
+	_, err := loadWorkspaceStory(workspaceID, storyID)
+	return err
+}
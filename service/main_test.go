@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/modelapi/d1test"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+)
+
+// TestMain 使用临时目录中的SQLite数据库运行测试，各测试使用不同的故事避免互相影响
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "service-test")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	code := 1
+	if err := database.Open(sqlite.Open(filepath.Join(dir, "test.db"))); err == nil {
+		if err := database.MigrateUp(); err == nil {
+			code = m.Run()
+		}
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// openTestD1 启动执行了全部D1迁移的模拟D1服务并让D1客户端指向它，测试结束后恢复
+func openTestD1(t *testing.T) *d1test.Server {
+	t.Helper()
+	server := d1test.NewServer(t)
+	prev := flag.D1BaseURL
+	flag.D1BaseURL = server.URL
+	t.Cleanup(func() { flag.D1BaseURL = prev })
+	migrator, err := database.NewMigrator(database.MigrationTargetD1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
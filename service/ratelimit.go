@@ -0,0 +1,343 @@
+package service
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitDefaultRoute 未单独配置的接口共用的限流配置
+const rateLimitDefaultRoute = "*"
+
+var (
+	ErrInvalidRateLimit = errors.New("invalid rate limit")
+	ErrLoginLocked      = errors.New("too many failed logins")
+)
+
+// LoginLockedError 登录失败次数过多，RetryAfter 后可以再次尝试
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrLoginLocked, e.RetryAfter)
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// Limit 令牌桶参数：每秒补充 Rate 个令牌，最多存 Burst 个。Rate 为0表示不限流
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit 解析 次数/单位[:突发] 格式的限流配置，如 10/m:5 表示每分钟10次、最多连续5次，
+// 未给出突发时等于次数。off 表示不限流
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return Limit{}, nil
+	}
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return Limit{}, ErrInvalidRateLimit
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, ErrInvalidRateLimit
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if per == 0 {
+		return Limit{}, ErrInvalidRateLimit
+	}
+	limit := Limit{Rate: float64(n) / per.Seconds(), Burst: n}
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return Limit{}, ErrInvalidRateLimit
+		}
+	}
+	return limit, nil
+}
+
+// take 按经过的时间补充令牌后取n个，n为0时只检查是否还有令牌。
+// 返回剩余令牌数和需要等待的时间，令牌不足时不扣减
+func (l Limit) take(tokens float64, elapsed time.Duration, n int) (float64, time.Duration) {
+	tokens = math.Min(float64(l.Burst), tokens+elapsed.Seconds()*l.Rate)
+	need := math.Max(float64(n), 1)
+	if tokens < need {
+		return tokens, time.Duration((need - tokens) / l.Rate * float64(time.Second))
+	}
+	return tokens - float64(n), 0
+}
+
+// fullAt 令牌桶补满的时间，之后可以丢弃该桶
+func (l Limit) fullAt(now time.Time, tokens float64) time.Time {
+	return now.Add(time.Duration((float64(l.Burst) - tokens) / l.Rate * float64(time.Second)))
+}
+
+// RateLimitStore 令牌桶的存储。多实例部署时需要共享存储，可通过 SetRateLimitStore 替换
+type RateLimitStore interface {
+	// Take 从key对应的令牌桶取n个令牌（n为0时只检查），令牌不足时返回需要等待的时间
+	Take(key string, limit Limit, n int) (time.Duration, error)
+	// Reset 删除令牌桶，下次使用时为满
+	Reset(key string) error
+}
+
+// rateLimitSweepInterval 清理已补满的令牌桶的间隔
+const rateLimitSweepInterval = time.Minute
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	fullAt  time.Time
+}
+
+// MemoryRateLimitStore 进程内的令牌桶，重启后清空，只适用于单实例部署
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit Limit, n int) (time.Duration, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) > rateLimitSweepInterval {
+		for k, b := range s.buckets {
+			if now.After(b.fullAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	var wait time.Duration
+	b.tokens, wait = limit.take(b.tokens, now.Sub(b.updated), n)
+	b.updated = now
+	b.fullAt = limit.fullAt(now, b.tokens)
+	return wait, nil
+}
+
+func (s *MemoryRateLimitStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.buckets, key)
+	return nil
+}
+
+// DBRateLimitStore 保存在数据库中的令牌桶，多个实例共享
+type DBRateLimitStore struct {
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewDBRateLimitStore() *DBRateLimitStore {
+	return &DBRateLimitStore{lastSweep: time.Now()}
+}
+
+func (s *DBRateLimitStore) Take(key string, limit Limit, n int) (time.Duration, error) {
+	s.sweep()
+	var wait time.Duration
+	err := database.NewRateLimitDao().UpdateBucket(key, func(b *database.RateLimitBucket, found bool) {
+		now := time.Now()
+		if !found {
+			b.Tokens, b.UpdatedAt = float64(limit.Burst), now
+		}
+		b.Tokens, wait = limit.take(b.Tokens, now.Sub(b.UpdatedAt), n)
+		b.UpdatedAt = now
+		b.FullAt = limit.fullAt(now, b.Tokens)
+	})
+	return wait, err
+}
+
+func (s *DBRateLimitStore) Reset(key string) error {
+	return database.NewRateLimitDao().DeleteBucket(key)
+}
+
+// sweep 定期删除已补满的令牌桶
+func (s *DBRateLimitStore) sweep() {
+	s.mu.Lock()
+	now := time.Now()
+	due := now.Sub(s.lastSweep) > rateLimitSweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+	if due {
+		database.NewRateLimitDao().DeleteFullBuckets(now)
+	}
+}
+
+var (
+	rateLimitStore RateLimitStore = NewMemoryRateLimitStore()
+	routeLimits                   = map[string]Limit{}
+	clientLimit    Limit
+)
+
+// InitRateLimit 按 -rate-limits、-client-rate-limit 和 -rate-limit-store 初始化限流，需在处理请求之前调用
+func InitRateLimit() error {
+	client, err := ParseLimit(flag.ClientRateLimit)
+	if err != nil {
+		return fmt.Errorf("%w: -client-rate-limit %s", err, flag.ClientRateLimit)
+	}
+	limits := map[string]Limit{}
+	for _, item := range strings.Split(flag.RateLimits, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		route, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrInvalidRateLimit, item)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, item)
+		}
+		limits[strings.TrimSpace(route)] = limit
+	}
+	switch flag.RateLimitStore {
+	case "memory":
+		SetRateLimitStore(NewMemoryRateLimitStore())
+	case "db":
+		SetRateLimitStore(NewDBRateLimitStore())
+	default:
+		return fmt.Errorf("unknown rate limit store: %s", flag.RateLimitStore)
+	}
+	routeLimits = limits
+	clientLimit = client
+	return nil
+}
+
+// SetRateLimitStore 替换限流和登录锁定使用的存储，用于接入其他共享存储
+func SetRateLimitStore(store RateLimitStore) {
+	rateLimitStore = store
+}
+
+// rateLimitIdentity 限流对象：API Key请求按Key，其他已登录请求按用户，未登录按客户端IP
+func rateLimitIdentity(c *gin.Context) string {
+	if id := c.GetUint(ContextAPIKeyID); id != 0 {
+		return "key:" + strconv.FormatUint(uint64(id), 10)
+	}
+	if user := CurrentUser(c); user != nil {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// TakeRateLimit 为本次请求取一个令牌，返回需要等待的时间，为0时放行。
+// 单独配置了的接口使用各自的令牌桶，其余接口共用默认令牌桶。存储出错时放行
+func TakeRateLimit(c *gin.Context) time.Duration {
+	route := c.FullPath()
+	limit, ok := routeLimits[route]
+	if !ok {
+		route = rateLimitDefaultRoute
+		limit = routeLimits[route]
+	}
+	if limit.Rate <= 0 {
+		return 0
+	}
+	wait, err := rateLimitStore.Take("route:"+route+":"+rateLimitIdentity(c), limit, 1)
+	if err != nil {
+		logger.Error("限流检查失败：", err.Error())
+		return 0
+	}
+	return wait
+}
+
+// TakeClientRateLimit 认证之前按客户端IP取一个令牌，返回需要等待的时间，为0时放行。
+// 与 TakeRateLimit 的令牌桶分开，限制的是携带无效凭证反复尝试的请求。存储出错时放行
+func TakeClientRateLimit(c *gin.Context) time.Duration {
+	if clientLimit.Rate <= 0 {
+		return 0
+	}
+	wait, err := rateLimitStore.Take("client:"+c.ClientIP(), clientLimit, 1)
+	if err != nil {
+		logger.Error("限流检查失败：", err.Error())
+		return 0
+	}
+	return wait
+}
+
+// loginBucket 登录失败计数的一个令牌桶：最多连续失败 failures 次，之后每隔 锁定时长/失败次数 恢复一次
+type loginBucket struct {
+	key   string
+	limit Limit
+}
+
+// loginBuckets 同一用户名在同一IP按 -login-max-failures 计数，在所有IP按更宽松的 -login-max-user-failures 计数，
+// 他人从别的IP输错密码不会很快锁定该用户，分散到多个IP的猜测也有上限
+func loginBuckets(username, ip string) []loginBucket {
+	if flag.LoginLockout <= 0 {
+		return nil
+	}
+	username = strings.ToLower(username)
+	var buckets []loginBucket
+	for _, b := range []struct {
+		key      string
+		failures int
+	}{
+		{"login:" + username + ":" + ip, flag.LoginMaxFailures},
+		{"login:" + username, flag.LoginMaxUserFailures},
+	} {
+		if b.failures > 0 {
+			buckets = append(buckets, loginBucket{key: b.key, limit: Limit{Rate: float64(b.failures) / flag.LoginLockout.Seconds(), Burst: b.failures}})
+		}
+	}
+	return buckets
+}
+
+// checkLoginLocked 用户名因连续登录失败被锁定时返回 LoginLockedError，两个令牌桶都锁定时取较长的等待时间
+func checkLoginLocked(username, ip string) error {
+	var longest time.Duration
+	for _, b := range loginBuckets(username, ip) {
+		wait, err := rateLimitStore.Take(b.key, b.limit, 0)
+		if err != nil {
+			logger.Error("登录锁定检查失败：", err.Error())
+			continue
+		}
+		longest = max(longest, wait)
+	}
+	if longest > 0 {
+		return &LoginLockedError{RetryAfter: longest}
+	}
+	return nil
+}
+
+// recordLoginFailure 记录一次登录失败
+func recordLoginFailure(username, ip string) {
+	for _, b := range loginBuckets(username, ip) {
+		if _, err := rateLimitStore.Take(b.key, b.limit, 1); err != nil {
+			logger.Error("记录登录失败报错：", err.Error())
+		}
+	}
+}
+
+// resetLoginFailures 登录成功后清除该用户名在该IP和所有IP累计的失败记录
+func resetLoginFailures(username, ip string) {
+	for _, b := range loginBuckets(username, ip) {
+		if err := rateLimitStore.Reset(b.key); err != nil {
+			logger.Error("清除登录失败记录报错：", err.Error())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"errors"
+	"fairytale-creator/flag"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "off", want: Limit{}},
+		{value: " off ", want: Limit{}},
+		{value: "10/s", want: Limit{Rate: 10, Burst: 10}},
+		{value: "60/m", want: Limit{Rate: 1, Burst: 60}},
+		{value: "6/h:2", want: Limit{Rate: 6.0 / 3600, Burst: 2}},
+		{value: "30/m:10", want: Limit{Rate: 0.5, Burst: 10}},
+		{value: "", wantErr: true},
+		{value: "10", wantErr: true},
+		{value: "10/d", wantErr: true},
+		{value: "0/s", wantErr: true},
+		{value: "-1/s", wantErr: true},
+		{value: "x/s", wantErr: true},
+		{value: "10/s:0", wantErr: true},
+		{value: "10/s:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseLimit(tt.value)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRateLimit) {
+					t.Fatalf("ParseLimit() error = %v, want ErrInvalidRateLimit", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLimit() error = %v", err)
+			}
+			if math.Abs(got.Rate-tt.want.Rate) > 1e-12 || got.Burst != tt.want.Burst {
+				t.Errorf("ParseLimit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitTake(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 4}
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		n          int
+		wantTokens float64
+		wantWait   time.Duration
+	}{
+		{"满桶取一个", 4, 0, 1, 3, 0},
+		{"补充不超过上限", 3, time.Hour, 1, 3, 0},
+		{"按经过的时间补充", 0, 500 * time.Millisecond, 1, 0, 0},
+		{"令牌不足时不扣减", 0.5, 0, 1, 0.5, 250 * time.Millisecond},
+		{"一次取多个", 4, 0, 3, 1, 0},
+		{"取多个不足", 2, 0, 3, 2, 500 * time.Millisecond},
+		{"只检查不扣减", 2, 0, 0, 2, 0},
+		{"只检查且已耗尽", 0, 0, 0, 0, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, wait := limit.take(tt.tokens, tt.elapsed, tt.n)
+			if math.Abs(tokens-tt.wantTokens) > 1e-9 || wait != tt.wantWait {
+				t.Errorf("take() = (%v, %v), want (%v, %v)", tokens, wait, tt.wantTokens, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := Limit{Rate: 1, Burst: 2}
+	for i, want := range []bool{true, true, false} {
+		wait, err := store.Take("k", limit, 1)
+		if err != nil {
+			t.Fatalf("Take() error = %v", err)
+		}
+		if (wait == 0) != want {
+			t.Errorf("Take() #%d wait = %v, allowed want %v", i, wait, want)
+		}
+	}
+	if wait, _ := store.Take("other", limit, 1); wait != 0 {
+		t.Errorf("Take() on another key wait = %v, want 0", wait)
+	}
+	if err := store.Reset("k"); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if wait, _ := store.Take("k", limit, 1); wait != 0 {
+		t.Errorf("Take() after Reset wait = %v, want 0", wait)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	defer func(failures, userFailures int, lockout time.Duration, store RateLimitStore) {
+		flag.LoginMaxFailures, flag.LoginMaxUserFailures, flag.LoginLockout = failures, userFailures, lockout
+		SetRateLimitStore(store)
+	}(flag.LoginMaxFailures, flag.LoginMaxUserFailures, flag.LoginLockout, rateLimitStore)
+	flag.LoginMaxFailures, flag.LoginMaxUserFailures, flag.LoginLockout = 2, 3, time.Hour
+	SetRateLimitStore(NewMemoryRateLimitStore())
+
+	tests := []struct {
+		name       string
+		action     func()
+		ip         string
+		wantLocked bool
+	}{
+		{"初始未锁定", func() {}, "1.1.1.1", false},
+		{"同一IP失败一次", func() { recordLoginFailure("Alice", "1.1.1.1") }, "1.1.1.1", false},
+		{"同一IP失败两次后锁定", func() { recordLoginFailure("alice", "1.1.1.1") }, "1.1.1.1", true},
+		{"其他IP不受影响", func() {}, "2.2.2.2", false},
+		{"所有IP累计失败后锁定", func() { recordLoginFailure("alice", "3.3.3.3") }, "2.2.2.2", true},
+		{"登录成功清除锁定", func() { resetLoginFailures("alice", "2.2.2.2") }, "2.2.2.2", false},
+		{"累计记录清除后其他IP不再锁定", func() {}, "3.3.3.3", false},
+		{"其他IP自己的失败记录保留", func() {}, "1.1.1.1", true},
+	}
+	for _, tt := range tests {
+		tt.action()
+		err := checkLoginLocked("alice", tt.ip)
+		var locked *LoginLockedError
+		if errors.As(err, &locked) != tt.wantLocked {
+			t.Errorf("%s: checkLoginLocked() = %v, locked want %v", tt.name, err, tt.wantLocked)
+		}
+		if locked != nil && locked.RetryAfter <= 0 {
+			t.Errorf("%s: RetryAfter = %v, want > 0", tt.name, locked.RetryAfter)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"os"
+	"strings"
+)
+
+// secretPrefix 加密后的密钥以此开头，没有前缀的是加密上线前保存的明文
+const secretPrefix = "enc:v1:"
+
+var (
+	ErrSecretKeyMissing = errors.New("未配置 -secret-keys，无法保存服务商密钥")
+	ErrSecretUndecrypt  = errors.New("无法用已配置的 -secret-keys 解密服务商密钥")
+)
+
+// secretKeys 读取加密密钥，第一个用于加密，全部用于解密以便轮换。配置的字符串经SHA-256得到AES-256密钥
+func secretKeys() [][]byte {
+	raw := flag.SecretKeys
+	if raw == "" {
+		raw = os.Getenv("SECRET_KEYS")
+	}
+	var keys [][]byte
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			sum := sha256.Sum256([]byte(key))
+			keys = append(keys, sum[:])
+		}
+	}
+	return keys
+}
+
+// encryptSecret 用AES-GCM加密工作区的服务商密钥，空字符串保持为空
+func encryptSecret(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	keys := secretKeys()
+	if len(keys) == 0 {
+		return "", ErrSecretKeyMissing
+	}
+	gcm, err := newGCM(keys[0])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 解密 encryptSecret 的结果，没有前缀的明文原样返回
+func decryptSecret(stored string) (string, error) {
+	if !strings.HasPrefix(stored, secretPrefix) {
+		return stored, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, secretPrefix))
+	if err != nil {
+		return "", ErrSecretUndecrypt
+	}
+	for _, key := range secretKeys() {
+		gcm, err := newGCM(key)
+		if err != nil || len(sealed) < gcm.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+		if plain, err := gcm.Open(nil, nonce, ciphertext, nil); err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", ErrSecretUndecrypt
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// workspaceSecretColumns 工作区中加密保存的列
+var workspaceSecretColumns = []string{"deepseek_api_key", "doubao_seedream_api_key", "cosy_voice_api_key", "jimeng_access_key_id", "jimeng_secret_access_key"}
+
+// workspaceSecrets 返回工作区各加密列的字段指针，顺序与 workspaceSecretColumns 相同
+func workspaceSecrets(w *database.Workspace) []*string {
+	return []*string{&w.DeepSeekAPIKey, &w.DoubaoSeedreamAPIKey, &w.CosyVoiceAPIKey, &w.JimengAccessKeyID, &w.JimengSecretAccessKey}
+}
+
+// EncryptWorkspaceSecrets 加密所有工作区中仍为明文的服务商密钥，用当前第一个密钥重新加密已加密的密钥，
+// 返回更新的工作区数。用于开启加密或轮换密钥之后
+func EncryptWorkspaceSecrets() (int, error) {
+	workspaceDao := database.NewWorkspaceDao()
+	workspaces, err := workspaceDao.ListWorkspaces()
+	if err != nil {
+		return 0, err
+	}
+	updated := 0
+	for i := range workspaces {
+		fields := map[string]interface{}{}
+		for j, value := range workspaceSecrets(&workspaces[i]) {
+			if *value == "" {
+				continue
+			}
+			plain, err := decryptSecret(*value)
+			if err != nil {
+				return updated, err
+			}
+			if fields[workspaceSecretColumns[j]], err = encryptSecret(plain); err != nil {
+				return updated, err
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if err := workspaceDao.UpdateWorkspace(workspaces[i].ID, fields); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// 会话最近访问时间的更新间隔，避免每个请求都写数据库
+const sessionTouchInterval = time.Minute
+
+// SessionStore 基于数据库的会话存储。Cookie中只保存签名后的随机会话令牌，
+// 会话数据和令牌的SHA-256保存在 user_session 表，多个实例共享同一个数据库即可共享会话。
+// 只有登录用户的会话会被保存，用户id为0时保存即删除会话
+type SessionStore struct {
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+}
+
+// NewSessionStore 按启动参数创建会话存储。签名密钥按顺序使用第一个签名，
+// 全部用于验证，轮换时把新密钥放在最前面，旧密钥保留到已签发的Cookie过期
+func NewSessionStore() *SessionStore {
+	var pairs [][]byte
+	for _, key := range sessionKeys() {
+		pairs = append(pairs, key, nil)
+	}
+	s := &SessionStore{codecs: securecookie.CodecsFromPairs(pairs...)}
+	s.Options(sessions.Options{
+		Path:     "/",
+		MaxAge:   int(flag.SessionMaxAge.Seconds()),
+		Secure:   flag.SessionSecure,
+		HttpOnly: true,
+		SameSite: sameSiteMode(flag.SessionSameSite),
+	})
+	return s
+}
+
+// sessionKeys 读取签名密钥，未配置时生成随机密钥，重启后所有会话失效
+func sessionKeys() [][]byte {
+	raw := flag.SessionKeys
+	if raw == "" {
+		raw = os.Getenv("SESSION_KEYS")
+	}
+	var keys [][]byte
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+	if len(keys) == 0 {
+		logger.Error("未配置 -session-keys，使用随机密钥，重启后需要重新登录")
+		keys = append(keys, securecookie.GenerateRandomKey(32))
+	}
+	return keys
+}
+
+func sameSiteMode(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Options 设置Cookie选项，签名的有效期与Cookie一致
+func (s *SessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+}
+
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New 根据Cookie加载会话。Cookie无效、会话已撤销或过期时返回新的空会话
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.codecs...); err != nil {
+		return session, nil
+	}
+	row, err := database.NewSessionDao().GetSessionByTokenHash(hashToken(token))
+	if errors.Is(err, database.RecordNotFoundError) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(row.Data)).Decode(&session.Values); err != nil {
+		logger.Error("解析会话报错：", err.Error())
+		return session, nil
+	}
+	session.ID = token
+	session.IsNew = false
+	if now := time.Now(); now.Sub(row.LastSeenAt) > sessionTouchInterval {
+		database.NewSessionDao().TouchSession(row.ID, now)
+	}
+	return session, nil
+}
+
+// Save 保存会话并写入Cookie。登录用户变化时更换会话令牌，防止会话固定攻击
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	sessionDao := database.NewSessionDao()
+	userID, _ := session.Values[SessionUserID].(uint)
+	if session.Options.MaxAge < 0 || userID == 0 {
+		if session.ID != "" {
+			if err := sessionDao.DeleteSessionByTokenHash(hashToken(session.ID)); err != nil {
+				return err
+			}
+		}
+		expired := *session.Options
+		expired.MaxAge = -1
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &expired))
+		return nil
+	}
+
+	var row *database.UserSession
+	if session.ID != "" {
+		existing, err := sessionDao.GetSessionByTokenHash(hashToken(session.ID))
+		if err != nil && !errors.Is(err, database.RecordNotFoundError) {
+			return err
+		}
+		if existing != nil && existing.UserID == userID {
+			row = existing
+		} else if existing != nil {
+			if err := sessionDao.DeleteSessionByTokenHash(existing.TokenHash); err != nil {
+				return err
+			}
+		}
+	}
+	now := time.Now()
+	if row == nil {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return err
+		}
+		session.ID = hex.EncodeToString(raw)
+		row = &database.UserSession{
+			TokenHash: hashToken(session.ID),
+			IP:        requestIP(r),
+			UserAgent: truncate(r.UserAgent(), 255),
+		}
+	}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	maxAge := session.Options.MaxAge
+	if maxAge == 0 {
+		maxAge = int(flag.SessionMaxAge.Seconds())
+	}
+	row.UserID = userID
+	row.Data = data.Bytes()
+	row.LastSeenAt = now
+	row.ExpiresAt = now.Add(time.Duration(maxAge) * time.Second)
+	if err := sessionDao.SaveSession(row); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// requestIP 记录会话来源，优先取反向代理设置的 X-Forwarded-For，仅用于展示
+func requestIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		ip, _, _ := strings.Cut(forwarded, ",")
+		return truncate(strings.TrimSpace(ip), 64)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return truncate(r.RemoteAddr, 64)
+	}
+	return host
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+// SessionInfo 会话列表中的一项，Current 表示发起请求的会话
+type SessionInfo struct {
+	database.UserSession
+	Current bool `json:"current"`
+}
+
+type SessionService struct {
+}
+
+func NewSessionService() SessionService {
+	return SessionService{}
+}
+
+// currentTokenHash 返回当前请求会话令牌的SHA-256，未登录时为空
+func currentTokenHash(c *gin.Context) string {
+	if id := sessions.Default(c).ID(); id != "" {
+		return hashToken(id)
+	}
+	return ""
+}
+
+// ListSessions 列出用户的有效会话
+func (p *SessionService) ListSessions(c *gin.Context, userID uint) ([]SessionInfo, error) {
+	rows, err := database.NewSessionDao().ListSessionsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	current := currentTokenHash(c)
+	list := make([]SessionInfo, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, SessionInfo{UserSession: row, Current: row.TokenHash == current})
+	}
+	return list, nil
+}
+
+// RevokeSession 撤销用户的某个会话，会话不属于该用户时返回 database.RecordNotFoundError
+func (p *SessionService) RevokeSession(userID, sessionID uint) error {
+	return database.NewSessionDao().DeleteUserSession(userID, sessionID)
+}
+
+// RevokeOtherSessions 撤销用户除当前请求外的所有会话，返回撤销的数量
+func (p *SessionService) RevokeOtherSessions(c *gin.Context, userID uint) (int64, error) {
+	return database.NewSessionDao().DeleteUserSessions(userID, currentTokenHash(c))
+}
+
+// StartSessionCleanup 每小时清理过期会话，直到ctx结束
+func (p *SessionService) StartSessionCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := database.NewSessionDao().DeleteExpiredSessions()
+		if err != nil {
+			continue
+		}
+		if n > 0 {
+			logger.Log("清理过期会话:", strconv.FormatInt(n, 10))
+		}
+	}
+}
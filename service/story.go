@@ -1,18 +1,21 @@
 package service
 
 import (
+	"context"
 	"fairytale-creator/database"
 	"fairytale-creator/flag"
 	"fairytale-creator/logger"
 	"fairytale-creator/modelapi"
 	"fairytale-creator/response"
+	"fairytale-creator/ssml"
 	"fairytale-creator/util"
-	"os"
 	"path"
-	"strings"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 const (
@@ -23,50 +26,143 @@ const (
 )
 
 type StoryService struct {
+	WorkspaceID           uint
 	DeepSeekAPIKey        string
 	DeepSeekUrl           string
+	DoubaoSeedreamAPIKey  string
+	CosyVoiceAPIKey       string
 	JimengAccessKeyID     string
 	JimengSecretAccessKey string
+	Themes                []string    // 为空时使用内置主题
+	Styles                []string    // 为空时使用内置画风
+	VoiceCasting          string      // 选角表JSON，为空时使用 -voice-casting-file
+	Meter                 *UsageMeter // 记录服务商用量，为nil时不记录
 }
 
+// NewStoryService 使用全局参数创建故事服务，故事属于默认工作区
 func NewStoryService() *StoryService {
 	return &StoryService{
+		WorkspaceID:           database.DefaultWorkspaceID,
 		DeepSeekAPIKey:        flag.DeepSeekAPIKey,
 		DeepSeekUrl:           flag.DeepSeekUrl,
+		DoubaoSeedreamAPIKey:  flag.DoubaoSeedreamAPIKey,
+		CosyVoiceAPIKey:       flag.CosyVoiceAPIKey,
 		JimengAccessKeyID:     flag.JimengAccessKeyID,
 		JimengSecretAccessKey: flag.JimengSecretAccessKey,
 	}
 }
 
-func (s *StoryService) GenerateStory() *response.Story {
+// NewStoryServiceForWorkspace 创建工作区的故事服务，工作区配置了的服务商密钥、主题、画风和选角覆盖全局参数
+func NewStoryServiceForWorkspace(workspace *database.Workspace) *StoryService {
+	s := NewStoryService()
+	s.WorkspaceID = workspace.ID
+	for _, o := range []struct {
+		field *string
+		value string
+	}{
+		{&s.DeepSeekAPIKey, workspace.DeepSeekAPIKey},
+		{&s.DoubaoSeedreamAPIKey, workspace.DoubaoSeedreamAPIKey},
+		{&s.CosyVoiceAPIKey, workspace.CosyVoiceAPIKey},
+		{&s.JimengAccessKeyID, workspace.JimengAccessKeyID},
+		{&s.JimengSecretAccessKey, workspace.JimengSecretAccessKey},
+	} {
+		value, err := decryptSecret(o.value)
+		if err != nil {
+			// 不回退到全局密钥，避免用平台的账号为工作区计费
+			logger.Error("工作区", strconv.FormatUint(uint64(workspace.ID), 10), err.Error())
+			*o.field = ""
+			continue
+		}
+		if value != "" {
+			*o.field = value
+		}
+	}
+	s.Themes = decodeList(workspace.Themes)
+	s.Styles = decodeList(workspace.Styles)
+	s.VoiceCasting = workspace.VoiceCasting
+	return s
+}
+
+// voiceCasting 返回工作区的选角表，未配置时读取 -voice-casting-file
+func (s *StoryService) voiceCasting() (*VoiceCasting, error) {
+	if s.VoiceCasting != "" {
+		return ParseVoiceCasting([]byte(s.VoiceCasting))
+	}
+	return LoadVoiceCasting(flag.VoiceCastingFile)
+}
+
+// GenerateStory 生成故事文本、章节图片和语音，ctx结束时不再等待限流或重试
+func (s *StoryService) GenerateStory(ctx context.Context) *response.Story {
 	currentDate := time.Now().Format("2006-01-02")
-	theme := util.GenerateDailyTheme(currentDate)
+	theme := util.GenerateDailyThemeFrom(currentDate, s.Themes)
+	styles := s.Styles
+	if len(styles) == 0 {
+		styles = util.GetStyleArray()
+	}
 	client := modelapi.NewDeepSeekClient(s.DeepSeekAPIKey, s.DeepSeekUrl)
-	story, err := client.GenerateFairyTale(theme, currentDate, util.GetStyleArray())
+	story, err := client.GenerateFairyTaleContext(ctx, theme, currentDate, styles)
+	s.Meter.RecordText(client.Usage)
 	if err != nil {
 		logger.Error(err.Error())
 		return nil
 	}
 	logger.Log("deepseek end", story.Description)
 	// return story
-	doubaoSeedreamClient := modelapi.NewDoubaoSeedreamClient(flag.DoubaoSeedreamAPIKey)
+	casting, err := s.voiceCasting()
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
+	cast := casting.Cast(story.Characters)
+	dict, err := s.PronunciationDictionary(story)
+	if err != nil {
+		logger.Error(err.Error())
+		return nil
+	}
 	firstImageUrl := ""
 	for i, chapter := range story.Chapters {
-		imgUrl := ""
-		if firstImageUrl != "" {
-			imgUrl, err = doubaoSeedreamClient.GenerateImageFromPromptAndGetURL(chapter.ImagePrompt)
-		} else {
-			imgUrl, err = doubaoSeedreamClient.GenerateImageFromPromptAndImageAndGetURL(chapter.ImagePrompt, firstImageUrl)
+		// 每章都会调用出图和语音合成，超出费用配额时停止，已产生的用量仍然计入
+		if err := s.Meter.CheckCost(); err != nil {
+			logger.Error("停止生成故事：", err.Error())
+			return nil
 		}
+		// 第一章文生图，之后的章节以第一章的图片为参考图生图，保持画风和角色一致
+		imgUrl, imageProvider, err := s.generateImage(ctx, chapter.ImagePrompt, firstImageUrl)
 		if err != nil {
 			logger.Error(err.Error())
 			return nil
 		}
-		story.Chapters[i].ImagePath = imgUrl
-		logger.Log("chapter image", imgUrl)
+		// 服务商返回的是临时链接，生成后立即下载归档，后续上传使用本地文件
+		imageInfo, err := util.ArchiveImage(ctx, imgUrl, flag.ImageRoot)
+		if err != nil {
+			logger.Error("archive image", err.Error())
+			return nil
+		}
+		story.Chapters[i].ImagePath = imageInfo.Path
+		story.Chapters[i].ImageHash = imageInfo.Hash
+		story.Chapters[i].ImageMime = imageInfo.MimeType
+		story.Chapters[i].ImageWidth = imageInfo.Width
+		story.Chapters[i].ImageHeight = imageInfo.Height
+		story.Chapters[i].ImageProvider = imageProvider
+		logger.Log("chapter image", imageProvider, imageInfo.Path)
 		voicePath := path.Join(flag.VoiceRoot, uuid.NewString()+currentDate+".mp3")
-		if ok := s.GenerateVoice(chapter.Content, voicePath); ok {
-			story.Chapters[i].VoicePath = voicePath
+		voiceProvider, err := s.GenerateChapterVoice(ctx, casting.Segments(chapter, cast), dict, voicePath)
+		if err != nil {
+			logger.Error("chapter voice", err.Error())
+			return nil
+		}
+		story.Chapters[i].VoicePath = voicePath
+		story.Chapters[i].VoiceProvider = voiceProvider
+		if flag.AudioPostProcess {
+			// 后处理失败时保留原始音频，不影响故事生成
+			assets, err := s.ProcessVoice(voicePath, modelapi.CosyVoiceSampleRate)
+			if err != nil {
+				logger.Error("process voice", err.Error())
+			} else {
+				story.Chapters[i].VoiceOpusPath = assets.OpusPath
+				story.Chapters[i].VoiceAACPath = assets.AACPath
+				story.Chapters[i].DurationMs = assets.DurationMs
+			}
 		}
 
 		if firstImageUrl == "" {
@@ -89,68 +185,487 @@ func (s *StoryService) GenerateStory() *response.Story {
 	return story
 }
 
-func (s *StoryService) AddStory(story *response.Story) error {
-	currentDate := time.Now().Format("2006-01-02")
-	storyDao := database.NewStoryDao()
+// AddStory 上传故事的图片和语音并写入故事和章节。先以软删除状态插入故事占用id，对象键都在该id之下，
+// 上传完成后章节在一个事务（MySQL）或一个批次（D1）中写入并恢复故事。
+// 任一步失败时删除本次上传的对象，占位的故事保持软删除，不会留下缺章节的故事或孤儿对象
+func (s *StoryService) AddStory(ctx context.Context, story *response.Story) error {
+	uploader, err := newR2Uploader()
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	storyID, err := s.saveStory(ctx, uploader, story)
+	if err != nil {
+		return err
+	}
+	s.Meter.SetStory(storyID)
+	return nil
+}
+
+// saveStory 见 AddStory，通过uploader上传对象，返回故事id
+func (s *StoryService) saveStory(ctx context.Context, uploader objectUploader, story *response.Story) (uint, error) {
 	storyModel := database.Story{
 		Title:       story.Title,
 		Author:      story.Author,
 		Description: story.Description,
 		MusicStyle:  story.MusicStyle,
-		Status:      0,
+		Status:      database.StoryStatusPending,
+		WorkspaceID: s.WorkspaceID,
+	}
+	// 按模型返回的章节序号排序，入库时重新从1连续编号
+	sort.SliceStable(story.Chapters, func(i, j int) bool {
+		return story.Chapters[i].ChapterNumber < story.Chapters[j].ChapterNumber
+	})
+	staged := &stagedUploads{uploader: uploader}
+	var err error
+	if flag.StoryStore == "mysql" {
+		err = s.addStoryToMySQL(ctx, staged, &storyModel, story)
+	} else {
+		err = s.addStoryToD1(ctx, staged, &storyModel, story)
 	}
-	response, err := storyDao.AddStoryToD1(&storyModel)
 	if err != nil {
 		logger.Error(err.Error())
+		staged.cleanup(ctx)
+		return 0, err
+	}
+	return storyModel.ID, nil
+}
+
+// addStoryToMySQL 插入占位的故事取得id，上传对象后在事务中插入章节、资源记录和待同步到D1的变更并恢复故事。
+// 上传期间不持有事务
+func (s *StoryService) addStoryToMySQL(ctx context.Context, staged *stagedUploads, storyModel *database.Story, story *response.Story) error {
+	if err := database.NewStoryDao().ReserveStory(storyModel); err != nil {
 		return err
 	}
-	storyModel.ID = uint(response.Result[0].Meta.LastRowID)
-	chapterDao := database.NewChapterDao()
-	for _, chapter := range story.Chapters {
-		imageName := uuid.NewString() + currentDate + ".png"
-		uploader, err := modelapi.NewR2Uploader(flag.CfAccountID, flag.R2AccessKeyID, flag.R2AccessKeySecret, "fairytale")
-		if err != nil {
-			logger.Error(err.Error())
+	chapters, assets, err := s.stageChapters(ctx, staged, storyModel.ID, story)
+	if err != nil {
+		return err
+	}
+	return database.GetDB().Transaction(func(tx *gorm.DB) error {
+		chapterDao := database.NewChapterDao()
+		chapterDao.Transaction(tx)
+		var all []database.Asset
+		for i := range chapters {
+			if err := chapterDao.AddChapter(&chapters[i]); err != nil {
+				return err
+			}
+			for j := range assets[i] {
+				assets[i][j].ChapterID = chapters[i].ID
+			}
+			all = append(all, assets[i]...)
+		}
+		assetDao := database.NewAssetDao()
+		assetDao.Transaction(tx)
+		if err := assetDao.AddAssets(all); err != nil {
 			return err
 		}
-		err = uploader.UploadFromURL(chapter.ImagePath, imageName)
+		storyDao := database.NewStoryDao()
+		storyDao.Transaction(tx)
+		if err := storyDao.RestoreStory(storyModel); err != nil {
+			return err
+		}
+		outboxDao := database.NewOutboxDao()
+		outboxDao.Transaction(tx)
+		return outboxDao.Enqueue(database.OutboxEntityStory, storyModel.ID)
+	})
+}
+
+// addStoryToD1 在D1中插入占位的故事取得id，上传对象后在一个批次中插入所有章节并恢复故事
+func (s *StoryService) addStoryToD1(ctx context.Context, staged *stagedUploads, storyModel *database.Story, story *response.Story) error {
+	storyDao := database.NewStoryDao()
+	if err := storyDao.ReserveStoryInD1(storyModel); err != nil {
+		return err
+	}
+	chapters, assets, err := s.stageChapters(ctx, staged, storyModel.ID, story)
+	if err != nil {
+		return err
+	}
+	if err := storyDao.AddChaptersToReservedStoryInD1(storyModel, chapters); err != nil {
+		return err
+	}
+	var all []database.Asset
+	for i := range chapters {
+		for j := range assets[i] {
+			assets[i][j].ChapterID = chapters[i].ID
+		}
+		all = append(all, assets[i]...)
+	}
+	// 资源记录在MySQL中，无法与D1批次一起提交。故事已写入，对象仍被D1章节引用，
+	// 垃圾回收不会误删，因此这里只记录错误
+	if err := database.NewAssetDao().AddAssets(all); err != nil {
+		logger.Error("记录故事资源失败：", err.Error())
+	}
+	return nil
+}
+
+// stageChapters 上传每个章节的图片、衍生图和语音，返回待写入的章节和对应的资源记录（ChapterID待回填）
+func (s *StoryService) stageChapters(ctx context.Context, staged *stagedUploads, storyID uint, story *response.Story) ([]database.Chapter, [][]database.Asset, error) {
+	chapters := make([]database.Chapter, 0, len(story.Chapters))
+	assets := make([][]database.Asset, 0, len(story.Chapters))
+	for i, chapter := range story.Chapters {
+		var chapterAssets []database.Asset
+		var upload assetUpload = func(kind, localPath, key string) (string, error) {
+			if localPath == "" {
+				return "", nil
+			}
+			if err := staged.upload(ctx, localPath, key); err != nil {
+				return "", err
+			}
+			provider := chapter.ImageProvider
+			if kind == database.AssetKindVoice || kind == database.AssetKindVoiceOpus || kind == database.AssetKindVoiceAAC {
+				provider = chapter.VoiceProvider
+			}
+			chapterAssets = append(chapterAssets, database.Asset{StoryID: storyID, Kind: kind, LocalPath: localPath, ObjectKey: key, Provider: provider})
+			return key, nil
+		}
+
+		n := i + 1
+		imageName, err := upload(database.AssetKindImage, chapter.ImagePath, imageObjectKey(s.WorkspaceID, storyID, n, chapter.ImagePath))
 		if err != nil {
-			logger.Error(err.Error())
+			return nil, nil, err
+		}
+		if err := s.uploadImageDerivatives(upload, chapter.ImagePath, imageName); err != nil {
+			return nil, nil, err
+		}
+		voiceName, err := upload(database.AssetKindVoice, chapter.VoicePath, voiceObjectKey(s.WorkspaceID, storyID, n, chapter.VoicePath))
+		if err != nil {
+			return nil, nil, err
+		}
+		opusName, err := s.uploadVoiceVariant(upload, database.AssetKindVoiceOpus, storyID, n, chapter.VoiceOpusPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		aacName, err := s.uploadVoiceVariant(upload, database.AssetKindVoiceAAC, storyID, n, chapter.VoiceAACPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		chapters = append(chapters, database.Chapter{
+			StoryID:       storyID,
+			ChapterNumber: n,
+			Title:         chapter.Title,
+			Content:       chapter.Content,
+			ImagePrompt:   chapter.ImagePrompt,
+			ImagePath:     imageName,
+			ImageHash:     chapter.ImageHash,
+			ImageMime:     chapter.ImageMime,
+			ImageWidth:    chapter.ImageWidth,
+			ImageHeight:   chapter.ImageHeight,
+			VoicePath:     voiceName,
+			VoiceOpusPath: opusName,
+			VoiceAACPath:  aacName,
+			DurationMs:    chapter.DurationMs,
+		})
+		assets = append(assets, chapterAssets)
+	}
+	return chapters, assets, nil
+}
+
+// assetUpload 上传一个文件并记录资源，localPath为空时跳过并返回空字符串，否则返回对象键
+type assetUpload func(kind, localPath, key string) (string, error)
+
+// uploadImageDerivatives 生成缩略图、中图和WebP版本，上传到原图旁边的对象键，章节没有图片时跳过
+func (s *StoryService) uploadImageDerivatives(upload assetUpload, localPath, imageName string) error {
+	if localPath == "" {
+		return nil
+	}
+	files, err := util.GenerateImageDerivatives(localPath)
+	if err != nil {
+		logger.Error(err.Error())
+		return err
+	}
+	keys := util.ImageDerivativeKeys(imageName)
+	for name, kind := range map[string]string{"thumb": database.AssetKindImageThumb, "medium": database.AssetKindImageMid, "webp": database.AssetKindImageWebP} {
+		if _, err := upload(kind, files[name], keys[name]); err != nil {
 			return err
 		}
-		voiceTemp := strings.Split(chapter.VoicePath, "/")
-		voiceName := voiceTemp[len(voiceTemp)-1]
-		err = uploader.UploadFromLocalFile(chapter.VoicePath, voiceName)
+	}
+	return nil
+}
+
+// uploadVoiceVariant 上传语音的其他格式版本，未生成该格式时返回空字符串
+func (s *StoryService) uploadVoiceVariant(upload assetUpload, kind string, storyID uint, n int, localPath string) (string, error) {
+	return upload(kind, localPath, voiceObjectKey(s.WorkspaceID, storyID, n, localPath))
+}
+
+// objectUploader 上传和删除对象，由 *modelapi.R2Uploader 实现
+type objectUploader interface {
+	UploadFromLocalFile(ctx context.Context, localFilePath, objectKey string) error
+	DeleteObject(ctx context.Context, objectKey string) error
+}
+
+// stagedUploads 记录本次已上传的对象，写库失败时一并删除。对象键都在本次占用的故事id之下
+// （ValidateKeyTemplates 保证不同故事的键不会相同），不会删到其他故事的对象
+type stagedUploads struct {
+	uploader objectUploader
+	keys     []string
+}
+
+func (u *stagedUploads) upload(ctx context.Context, localPath, key string) error {
+	if err := u.uploader.UploadFromLocalFile(ctx, localPath, key); err != nil {
+		return err
+	}
+	u.keys = append(u.keys, key)
+	return nil
+}
+
+// cleanup 删除已上传的对象。请求可能已被取消，因此不继承ctx的取消；删除失败的对象留给垃圾回收
+func (u *stagedUploads) cleanup(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	for _, key := range u.keys {
+		if err := u.uploader.DeleteObject(ctx, key); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+	u.keys = nil
+}
+
+// ListStories 分页列出工作区的故事，按创建时间倒序
+func (s *StoryService) ListStories(offset, limit int) ([]database.Story, error) {
+	if flag.StoryStore == "mysql" {
+		return database.NewStoryDao().ListStories(s.WorkspaceID, offset, limit)
+	}
+	return database.NewStoryDao().ListStoriesFromD1(s.WorkspaceID, offset, limit)
+}
+
+// GetStory 获取工作区中的故事详情，图片和语音返回预签名地址，图片同时返回各尺寸版本
+func (s *StoryService) GetStory(ctx context.Context, id uint) (*response.StoryDetail, error) {
+	// 只返回本工作区的故事，按 canReadStory 的规则成员可以看到未发布的故事
+	story, err := loadWorkspaceStory(s.WorkspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := loadChapters(id)
+	if err != nil {
+		return nil, err
+	}
+	detail := &response.StoryDetail{
+		ID:          story.ID,
+		Title:       story.Title,
+		Author:      story.Author,
+		Description: story.Description,
+		MusicStyle:  story.MusicStyle,
+		Status:      story.Status,
+		CreatedAt:   story.CreatedAt.Unix(),
+		Chapters:    make([]response.ChapterDetail, 0, len(chapters)),
+	}
+	assetService := NewAssetService()
+	var signErr error
+	sign := func(key string) string {
+		url, err := assetService.AssetURL(ctx, key)
 		if err != nil {
 			logger.Error(err.Error())
-			return err
+			signErr = err
 		}
-		chapterModel := database.Chapter{
-			StoryID:     storyModel.ID,
-			Title:       chapter.Title,
-			Content:     chapter.Content,
-			ImagePrompt: chapter.ImagePrompt,
-			ImagePath:   imageName,
-			VoicePath:   voiceName,
+		return url
+	}
+	for _, c := range chapters {
+		var derivatives map[string]string
+		if c.ImagePath != "" {
+			derivatives = util.ImageDerivativeKeys(c.ImagePath)
 		}
-		_, err = chapterDao.AddChapterToD1(&chapterModel)
+		detail.Chapters = append(detail.Chapters, response.ChapterDetail{
+			ID:            c.ID,
+			ChapterNumber: c.ChapterNumber,
+			Title:         c.Title,
+			Content:       c.Content,
+			Image: response.Image{
+				Original: sign(c.ImagePath),
+				Thumb:    sign(derivatives["thumb"]),
+				Medium:   sign(derivatives["medium"]),
+				WebP:     sign(derivatives["webp"]),
+				Width:    c.ImageWidth,
+				Height:   c.ImageHeight,
+			},
+			VoicePath:  sign(c.VoicePath),
+			VoiceOpus:  sign(c.VoiceOpusPath),
+			VoiceAAC:   sign(c.VoiceAACPath),
+			DurationMs: c.DurationMs,
+		})
+	}
+	if signErr != nil {
+		return nil, signErr
+	}
+	return detail, nil
+}
+
+// SetPublished 发布或撤回工作区中的故事，撤回后回到待审阅状态
+func (s *StoryService) SetPublished(id uint, published bool) error {
+	if _, err := loadWorkspaceStory(s.WorkspaceID, id); err != nil {
+		return err
+	}
+	status := database.StoryStatusPending
+	if published {
+		status = database.StoryStatusPublished
+	}
+	if flag.StoryStore == "mysql" {
+		return database.NewStoryDao().SetStoryStatus(id, status)
+	}
+	return database.NewStoryDao().SetStoryStatusInD1(id, status)
+}
+
+// canReadStory 故事资源的访问规则：已发布的故事所有人可见，未发布的只有所属工作区的成员可见。
+// 匿名访问时 workspaceID 为0；故事详情等工作区接口只读取本工作区的故事，成员总是可见
+func canReadStory(story *database.Story, workspaceID uint) bool {
+	return story.Status == database.StoryStatusPublished || (workspaceID != 0 && story.WorkspaceID == workspaceID)
+}
+
+// loadStory 从配置的存储中读取故事
+func loadStory(id uint) (*database.Story, error) {
+	if flag.StoryStore == "mysql" {
+		return database.NewStoryDao().GetStory(id)
+	}
+	return database.NewStoryDao().GetStoryFromD1(id)
+}
+
+// loadWorkspaceStory 读取工作区中的故事，属于其他工作区的故事视为不存在
+func loadWorkspaceStory(workspaceID, id uint) (*database.Story, error) {
+	story, err := loadStory(id)
+	if err != nil {
+		return nil, err
+	}
+	if story.WorkspaceID != workspaceID {
+		return nil, database.RecordNotFoundError
+	}
+	return story, nil
+}
+
+// loadChapters 从配置的存储中读取故事的章节
+func loadChapters(storyID uint) ([]database.Chapter, error) {
+	if flag.StoryStore == "mysql" {
+		return database.NewChapterDao().ListChapters(storyID)
+	}
+	return database.NewChapterDao().ListChaptersFromD1(storyID)
+}
+
+// GenerateVoice 合成单段文本，ssml模式下先套用全局读音词典转换为SSML。
+// CosyVoice不可用时改用备用朗读，失败时返回 modelapi 中定义的语音合成错误。
+// 每个服务商的合成超时从ctx派生，请求取消时合成随之中断
+func (s *StoryService) GenerateVoice(ctx context.Context, text string, filename string) error {
+	ttsCtx, cancel := context.WithTimeout(ctx, flag.TTSTimeout)
+	defer cancel()
+	client := modelapi.NewCosyVoiceClient(s.CosyVoiceAPIKey, filename)
+	input := text
+	if flag.TTSTextType == "ssml" {
+		dict, err := ssml.LoadDictionary(flag.PronunciationFile)
 		if err != nil {
 			logger.Error(err.Error())
 			return err
 		}
+		if input, err = ssml.FromText(text, dict, 0, 0); err != nil {
+			logger.Error(err.Error())
+			return err
+		}
+		client.TextType = modelapi.TextTypeSSML
+	}
+	err := client.SynthesizeContext(ttsCtx, []string{input})
+	s.Meter.RecordVoice(database.UsageProviderCosyVoice, client.SubmittedCharacters)
+	if err != nil && modelapi.IsUnavailable(err) && s.voiceFallback() {
+		logger.Error("CosyVoice不可用，改用备用朗读：", err.Error())
+		err = s.fallbackVoice(ctx, []modelapi.SpeechSegment{{Text: text}}, filename)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		return err
 	}
 	return nil
 }
 
-func (s *StoryService) GenerateVoice(text string, filename string) bool {
-	if _, err := os.Stat(flag.VoiceRoot); os.IsNotExist(err) {
-		os.MkdirAll(flag.VoiceRoot, 0755)
+// GenerateChapterVoice 按说话人逐段合成章节语音并按顺序拼接，
+// ssml模式下每段文本会先套用读音词典转换为SSML。语速和音调只通过任务参数设置，
+// 不再写入<speak>，避免重复生效。CosyVoice熔断或重试用尽时
+// 改用 -fallback-tts-provider 以单一音色朗读，返回实际使用的服务商
+func (s *StoryService) GenerateChapterVoice(ctx context.Context, segments []modelapi.SpeechSegment, dict ssml.Dictionary, filename string) (string, error) {
+	plain := append([]modelapi.SpeechSegment(nil), segments...)
+	err := s.cosyVoiceSegments(ctx, segments, dict, filename)
+	if err == nil || !modelapi.IsUnavailable(err) || !s.voiceFallback() {
+		return database.UsageProviderCosyVoice, err
 	}
-	client := modelapi.NewCosyVoiceClient(flag.CosyVoiceAPIKey, filename)
-	err := client.Synthesize([]string{text})
+	logger.Error("CosyVoice不可用，改用备用朗读：", err.Error())
+	return database.UsageProviderVolcTTS, s.fallbackVoice(ctx, plain, filename)
+}
+
+func (s *StoryService) cosyVoiceSegments(ctx context.Context, segments []modelapi.SpeechSegment, dict ssml.Dictionary, filename string) error {
+	client := modelapi.NewCosyVoiceClient(s.CosyVoiceAPIKey, filename)
+	// 按实际提交的字数计费，重试重新提交的和合成失败前已提交的部分同样计入
+	defer func() {
+		s.Meter.RecordVoice(database.UsageProviderCosyVoice, client.SubmittedCharacters)
+	}()
+	if flag.TTSTextType == "ssml" {
+		client.TextType = modelapi.TextTypeSSML
+		for i, segment := range segments {
+			doc, err := ssml.FromText(segment.Text, dict, 0, 0)
+			if err != nil {
+				return err
+			}
+			segments[i].Text = doc
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, flag.TTSTimeout)
+	defer cancel()
+	return client.SynthesizeSegmentsContext(ctx, segments)
+}
+
+// voiceFallback 是否配置了备用朗读
+func (s *StoryService) voiceFallback() bool {
+	return flag.FallbackTTSProvider == database.UsageProviderVolcTTS && flag.VolcTTSAppID != "" && flag.VolcTTSAccessToken != ""
+}
+
+// fallbackVoice 使用火山引擎语音合成朗读，不支持SSML和多音色
+func (s *StoryService) fallbackVoice(ctx context.Context, segments []modelapi.SpeechSegment, filename string) error {
+	client := modelapi.NewVolcTTSClient(flag.VolcTTSAppID, flag.VolcTTSAccessToken, flag.VolcTTSCluster, flag.VolcTTSVoice)
+	defer func() {
+		s.Meter.RecordVoice(database.UsageProviderVolcTTS, client.SubmittedCharacters)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, flag.TTSTimeout)
+	defer cancel()
+	return client.SynthesizeSegmentsContext(ctx, segments, filename)
+}
+
+// generateImage 使用Seedream出图，Seedream熔断或重试用尽时改用 -fallback-image-provider，
+// 返回图片链接和实际使用的服务商
+func (s *StoryService) generateImage(ctx context.Context, prompt string, refURL string) (string, string, error) {
+	client := modelapi.NewDoubaoSeedreamClient(s.DoubaoSeedreamAPIKey)
+	var ref *string
+	if refURL != "" {
+		ref = &refURL
+	}
+	imgUrl, err := client.GenerateImageAndGetURLContext(ctx, prompt, ref)
+	s.Meter.RecordImages(database.UsageProviderSeedream, client.Usage.GeneratedImages)
+	if err == nil || !modelapi.IsUnavailable(err) || !s.imageFallback() {
+		return imgUrl, database.UsageProviderSeedream, err
+	}
+	logger.Error("Seedream不可用，改用即梦出图：", err.Error())
+	reqKey := JimengReqKeyT2i
+	if refURL != "" {
+		reqKey = JimengReqKeyI2i
+	}
+	jimeng := modelapi.NewJimengClient(s.JimengAccessKeyID, s.JimengSecretAccessKey)
+	jimengCtx, cancel := context.WithTimeout(ctx, flag.JimengTimeout)
+	defer cancel()
+	imgUrl, err = jimeng.GenerateImageContext(jimengCtx, reqKey, prompt, refURL)
 	if err != nil {
-		logger.Error(err.Error())
-		return false
+		return "", database.UsageProviderJimeng, err
+	}
+	s.Meter.RecordImages(database.UsageProviderJimeng, 1)
+	return imgUrl, database.UsageProviderJimeng, nil
+}
+
+// imageFallback 是否配置了备用出图
+func (s *StoryService) imageFallback() bool {
+	return flag.FallbackImageProvider == database.UsageProviderJimeng && s.JimengAccessKeyID != "" && s.JimengSecretAccessKey != ""
+}
+
+// PronunciationDictionary 合并全局读音词典与故事自带的读音标注，故事中的标注优先
+func (s *StoryService) PronunciationDictionary(story *response.Story) (ssml.Dictionary, error) {
+	dict, err := ssml.LoadDictionary(flag.PronunciationFile)
+	if err != nil {
+		return nil, err
+	}
+	storyDict := ssml.Dictionary{}
+	for _, p := range story.Pronunciations {
+		if p.Word != "" && p.Pinyin != "" {
+			storyDict[p.Word] = p.Pinyin
+		}
 	}
-	return true
+	return dict.Merge(storyDict), nil
 }
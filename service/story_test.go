@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/response"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeUploader 记录上传和删除的对象，上传键包含 failOn 时返回错误
+type fakeUploader struct {
+	failOn   string
+	uploaded []string
+	deleted  []string
+}
+
+func (u *fakeUploader) UploadFromLocalFile(ctx context.Context, localFilePath, objectKey string) error {
+	if u.failOn != "" && strings.Contains(objectKey, u.failOn) {
+		return errors.New("upload failed")
+	}
+	u.uploaded = append(u.uploaded, objectKey)
+	return nil
+}
+
+func (u *fakeUploader) DeleteObject(ctx context.Context, objectKey string) error {
+	u.deleted = append(u.deleted, objectKey)
+	return nil
+}
+
+// 上传或写库任一步失败时删除已上传的对象，占位的故事保持软删除且没有章节和资源记录
+func TestSaveStory(t *testing.T) {
+	tests := []struct {
+		name   string
+		failOn string
+		// trigger 在写库前创建的SQLite触发器，使事务中的写入失败
+		trigger string
+		wantErr bool
+	}{
+		{name: "成功"},
+		{name: "上传失败", failOn: "chapters/2/", wantErr: true},
+		{
+			name:    "事务失败",
+			trigger: "CREATE TRIGGER fail_asset BEFORE INSERT ON asset BEGIN SELECT RAISE(ABORT, 'asset insert failed'); END",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.trigger != "" {
+				if err := database.GetDB().Exec(tt.trigger).Error; err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { database.GetDB().Exec("DROP TRIGGER fail_asset") })
+			}
+			story := &response.Story{Title: tt.name, Chapters: []response.Chapter{
+				{Title: "二", ChapterNumber: 2, VoicePath: "voices/b.mp3"},
+				{Title: "一", ChapterNumber: 1, VoicePath: "voices/a.mp3", VoiceOpusPath: "voices/a.opus"},
+			}}
+			uploader := &fakeUploader{failOn: tt.failOn}
+			s := &StoryService{WorkspaceID: 1}
+			id, err := s.saveStory(context.Background(), uploader, story)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("saveStory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var stories []database.Story
+			if err := database.GetDB().Unscoped().Where("title = ?", tt.name).Find(&stories).Error; err != nil || len(stories) != 1 {
+				t.Fatalf("stories = %+v, %v, want one reserved story", stories, err)
+			}
+			storyID := stories[0].ID
+			chapters, err := database.NewChapterDao().ListChapters(storyID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var assets, pending int64
+			database.GetDB().Model(&database.Asset{}).Where("story_id = ?", storyID).Count(&assets)
+			database.GetDB().Model(&database.Outbox{}).Where("entity_id = ?", storyID).Count(&pending)
+
+			if tt.wantErr {
+				if !stories[0].DeletedAt.Valid || len(chapters) != 0 || assets != 0 || pending != 0 {
+					t.Errorf("after failure: deleted %v, %d chapters, %d assets, %d outbox entries, want reserved story only",
+						stories[0].DeletedAt.Valid, len(chapters), assets, pending)
+				}
+				if !reflect.DeepEqual(uploader.deleted, uploader.uploaded) {
+					t.Errorf("deleted %v, want every uploaded object %v", uploader.deleted, uploader.uploaded)
+				}
+				return
+			}
+			if id != storyID || stories[0].DeletedAt.Valid || len(uploader.deleted) != 0 {
+				t.Errorf("saveStory() = %d, story %+v, deleted %v", id, stories[0], uploader.deleted)
+			}
+			if len(chapters) != 2 || chapters[0].Title != "一" || chapters[0].ChapterNumber != 1 || chapters[1].ChapterNumber != 2 {
+				t.Errorf("chapters = %+v, want 一 and 二 numbered from 1", chapters)
+			}
+			if assets != int64(len(uploader.uploaded)) || pending != 1 {
+				t.Errorf("%d assets for %d uploads, %d outbox entries, want one each and one entry", assets, len(uploader.uploaded), pending)
+			}
+		})
+	}
+}
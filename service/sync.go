@@ -0,0 +1,281 @@
+package service
+
+import (
+	"context"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"strconv"
+	"time"
+)
+
+type SyncService struct {
+}
+
+func NewSyncService() *SyncService {
+	return &SyncService{}
+}
+
+// SeedIDs 把MySQL的故事和章节自增起点调到D1已有的最大id之后。以MySQL为准时，
+// 需要在写入任何故事之前执行，否则新故事会占用D1中旧故事的id
+func (s *SyncService) SeedIDs() error {
+	storyID, chapterID, err := database.NewStoryDao().MaxIDsFromD1()
+	if err != nil {
+		return err
+	}
+	return database.NewStoryDao().SeedIDsAbove(storyID, chapterID)
+}
+
+// SyncStory 将MySQL中故事和章节的最新状态（包括软删除）写入D1，可重复执行
+func (s *SyncService) SyncStory(id uint) error {
+	story, err := database.NewStoryDao().GetStoryUnscoped(id)
+	if err != nil {
+		return err
+	}
+	chapters, err := database.NewChapterDao().ListChaptersUnscoped(id)
+	if err != nil {
+		return err
+	}
+	return database.NewStoryDao().UpsertStoryWithChaptersToD1(story, chapters)
+}
+
+// RunOutbox 处理一批到期的同步记录，返回成功同步的条数。失败的记录按指数退避安排重试
+func (s *SyncService) RunOutbox() (int, error) {
+	outboxDao := database.NewOutboxDao()
+	entries, err := outboxDao.ListDue(50)
+	if err != nil {
+		return 0, err
+	}
+	synced := 0
+	done := map[uint]bool{}
+	for i := range entries {
+		entry := &entries[i]
+		// 同一故事的多条记录只需要同步一次，之后的记录在同步前已写入，同样已被覆盖
+		if done[entry.EntityID] {
+			if err := outboxDao.MarkDone(entry); err != nil {
+				return synced, err
+			}
+			continue
+		}
+		if err := s.SyncStory(entry.EntityID); err != nil {
+			next := time.Now().Add(syncBackoff(entry.Attempts))
+			logger.Error("sync story", strconv.FormatUint(uint64(entry.EntityID), 10), "failed, attempt",
+				strconv.Itoa(entry.Attempts+1)+":", err.Error())
+			if err := outboxDao.MarkFailed(entry, next, err.Error()); err != nil {
+				return synced, err
+			}
+			continue
+		}
+		if err := outboxDao.MarkDone(entry); err != nil {
+			return synced, err
+		}
+		done[entry.EntityID] = true
+		synced++
+	}
+	return synced, nil
+}
+
+// syncBackoff 第n次失败后的等待时间：SyncInterval * 2^n，不超过 SyncMaxBackoff
+func syncBackoff(attempts int) time.Duration {
+	wait := flag.SyncInterval
+	for i := 0; i < attempts && wait < flag.SyncMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > flag.SyncMaxBackoff {
+		wait = flag.SyncMaxBackoff
+	}
+	return wait
+}
+
+// StartSyncWorker 定期处理同步记录，直到ctx结束
+func (s *SyncService) StartSyncWorker(ctx context.Context) {
+	ticker := time.NewTicker(flag.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			synced, err := s.RunOutbox()
+			if err != nil {
+				logger.Error(err.Error())
+				break
+			}
+			// 一批处理满时立即处理下一批
+			if synced < 50 {
+				break
+			}
+		}
+	}
+}
+
+// Drift 一个故事在MySQL和D1之间的差异
+type Drift struct {
+	StoryID uint
+	Reason  string
+}
+
+const (
+	DriftMissingInD1    = "D1中缺失"
+	DriftDiffers        = "内容不一致"
+	DriftMissingInMySQL = "仅存在于D1"
+	DriftIDConflict     = "id冲突"
+)
+
+// reconcilePageSize 对账时每批比较的故事数
+const reconcilePageSize = 500
+
+// Reconcile 按id分批比较MySQL和D1中的所有故事和章节。fix为true时重新同步缺失或不一致的故事；
+// importD1为true时把只存在于D1的故事（同步上线前直接写入D1的数据）按原id导入MySQL。
+// 两边id相同但创建时间不同的是不同的故事，只报告 DriftIDConflict，不会互相覆盖
+func (s *SyncService) Reconcile(fix, importD1 bool) ([]Drift, error) {
+	storyDao := database.NewStoryDao()
+	var drifts []Drift
+	var after uint
+	for {
+		mysqlStories, err := storyDao.ListStoriesUnscopedAfter(after, reconcilePageSize)
+		if err != nil {
+			return drifts, err
+		}
+		d1Stories, err := storyDao.ListStoriesFromD1After(after, reconcilePageSize)
+		if err != nil {
+			return drifts, err
+		}
+		if len(mysqlStories) == 0 && len(d1Stories) == 0 {
+			break
+		}
+		// 本批比较 (after, upTo] 内的故事：取两边都完整读到的范围，upTo为0表示两边都已读完
+		var upTo uint
+		for _, page := range [][]database.Story{mysqlStories, d1Stories} {
+			if len(page) == reconcilePageSize && (upTo == 0 || page[len(page)-1].ID < upTo) {
+				upTo = page[len(page)-1].ID
+			}
+		}
+		pageDrifts, err := s.reconcileRange(inRange(mysqlStories, upTo), inRange(d1Stories, upTo), after, upTo, fix, importD1)
+		drifts = append(drifts, pageDrifts...)
+		if err != nil {
+			return drifts, err
+		}
+		if upTo == 0 {
+			break
+		}
+		after = upTo
+	}
+	logger.Log("reconcile drifts:", strconv.Itoa(len(drifts)), "fix:", strconv.FormatBool(fix), "import:", strconv.FormatBool(importD1))
+	return drifts, nil
+}
+
+// inRange 返回id不超过upTo的故事，upTo为0时全部返回
+func inRange(stories []database.Story, upTo uint) []database.Story {
+	if upTo == 0 {
+		return stories
+	}
+	for i, story := range stories {
+		if story.ID > upTo {
+			return stories[:i]
+		}
+	}
+	return stories
+}
+
+// reconcileRange 比较 (after, upTo] 内的故事，章节每边一次查询读出
+func (s *SyncService) reconcileRange(mysqlStories, d1Stories []database.Story, after, upTo uint, fix, importD1 bool) ([]Drift, error) {
+	storyDao := database.NewStoryDao()
+	chapterDao := database.NewChapterDao()
+	ids := make([]uint, 0, len(mysqlStories))
+	for _, story := range mysqlStories {
+		ids = append(ids, story.ID)
+	}
+	mysqlChapters, err := chapterDao.ListChaptersUnscopedByStories(ids)
+	if err != nil {
+		return nil, err
+	}
+	d1Chapters, err := chapterDao.ListChaptersFromD1ByStoryRange(after, upTo)
+	if err != nil {
+		return nil, err
+	}
+	mysqlChaptersByStory := groupChapters(mysqlChapters)
+	d1ChaptersByStory := groupChapters(d1Chapters)
+	d1StoryByID := make(map[uint]database.Story, len(d1Stories))
+	for _, story := range d1Stories {
+		d1StoryByID[story.ID] = story
+	}
+
+	var drifts []Drift
+	for _, story := range mysqlStories {
+		d1Story, ok := d1StoryByID[story.ID]
+		delete(d1StoryByID, story.ID)
+		var drift *Drift
+		switch {
+		case !ok:
+			drift = &Drift{StoryID: story.ID, Reason: DriftMissingInD1}
+		case !sameRecord(story.CreatedAt, d1Story.CreatedAt):
+			drifts = append(drifts, Drift{StoryID: story.ID, Reason: DriftIDConflict})
+			continue
+		case !sameStory(story, d1Story) || !sameChapters(mysqlChaptersByStory[story.ID], d1ChaptersByStory[story.ID]):
+			drift = &Drift{StoryID: story.ID, Reason: DriftDiffers}
+		}
+		if drift == nil {
+			continue
+		}
+		drifts = append(drifts, *drift)
+		if fix {
+			if err := s.SyncStory(story.ID); err != nil {
+				return drifts, err
+			}
+		}
+	}
+
+	for _, story := range d1Stories {
+		if _, ok := d1StoryByID[story.ID]; !ok {
+			continue
+		}
+		drifts = append(drifts, Drift{StoryID: story.ID, Reason: DriftMissingInMySQL})
+		if importD1 {
+			story := story
+			if err := storyDao.ImportStory(&story, d1ChaptersByStory[story.ID]); err != nil {
+				return drifts, err
+			}
+		}
+	}
+	return drifts, nil
+}
+
+func groupChapters(chapters []database.Chapter) map[uint][]database.Chapter {
+	byStory := map[uint][]database.Chapter{}
+	for _, c := range chapters {
+		byStory[c.StoryID] = append(byStory[c.StoryID], c)
+	}
+	return byStory
+}
+
+// sameRecord 按创建时间（秒）判断两边id相同的行是否为同一条记录
+func sameRecord(a, b time.Time) bool {
+	return a.Unix() == b.Unix() || (a.IsZero() && b.IsZero())
+}
+
+func sameStory(a, b database.Story) bool {
+	return a.WorkspaceID == b.WorkspaceID && a.Title == b.Title && a.Author == b.Author && a.Description == b.Description &&
+		a.MusicStyle == b.MusicStyle && a.Status == b.Status && a.DeletedAt.Valid == b.DeletedAt.Valid
+}
+
+func sameChapters(a, b []database.Chapter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byID := make(map[uint]database.Chapter, len(b))
+	for _, c := range b {
+		byID[c.ID] = c
+	}
+	for _, x := range a {
+		y, ok := byID[x.ID]
+		if !ok || !sameRecord(x.CreatedAt, y.CreatedAt) || x.StoryID != y.StoryID || x.Title != y.Title || x.Content != y.Content || x.ImagePath != y.ImagePath ||
+			x.VoicePath != y.VoicePath || x.VoiceOpusPath != y.VoiceOpusPath || x.VoiceAACPath != y.VoiceAACPath ||
+			x.DurationMs != y.DurationMs || x.DeletedAt.Valid != y.DeletedAt.Valid {
+			return false
+		}
+	}
+	return true
+}
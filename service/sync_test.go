@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fairytale-creator/database"
+	"fairytale-creator/modelapi"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// 同步失败时记录原因并按退避时间重试，到期重试成功后同一故事的记录都标记为完成
+func TestRunOutboxRetry(t *testing.T) {
+	server := openTestD1(t)
+	story := &database.Story{Title: "同步"}
+	if err := database.NewStoryDao().AddStory(story); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.NewChapterDao().AddChapter(&database.Chapter{StoryID: story.ID, Title: "一"}); err != nil {
+		t.Fatal(err)
+	}
+	outboxDao := database.NewOutboxDao()
+	for i := 0; i < 2; i++ {
+		if err := outboxDao.Enqueue(database.OutboxEntityStory, story.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := func() []database.Outbox {
+		var list []database.Outbox
+		if err := database.GetDB().Where("entity_id = ?", story.ID).Order("id").Find(&list).Error; err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+	inD1 := func() int {
+		var n int
+		if err := server.DB.QueryRow("SELECT COUNT(*) FROM chapter WHERE story_id = ?", story.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	requests := 0
+	server.SetFail(func(batch []modelapi.D1QueryRequest) (int, string) {
+		requests++
+		return http.StatusBadRequest, "D1_ERROR"
+	})
+	if synced, err := NewSyncService().RunOutbox(); err != nil || synced != 0 {
+		t.Fatalf("RunOutbox() with D1 down = %d, %v, want 0, nil", synced, err)
+	}
+	for _, e := range entries() {
+		if e.Attempts != 1 || e.LastError == "" || !e.NextAttemptAt.After(time.Now()) || e.DoneAt != nil {
+			t.Errorf("entry after failure = %+v, want one attempt with error and a later retry", e)
+		}
+	}
+
+	// 未到重试时间时不会再次请求D1
+	before := requests
+	if synced, err := NewSyncService().RunOutbox(); err != nil || synced != 0 || requests != before {
+		t.Fatalf("RunOutbox() before retry time = %d, %v, %d requests, want no attempt", synced, err, requests-before)
+	}
+
+	server.SetFail(nil)
+	if err := database.GetDB().Model(&database.Outbox{}).Where("entity_id = ?", story.ID).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if synced, err := NewSyncService().RunOutbox(); err != nil || synced != 1 {
+		t.Fatalf("RunOutbox() after recovery = %d, %v, want 1, nil", synced, err)
+	}
+	for _, e := range entries() {
+		if e.DoneAt == nil {
+			t.Errorf("entry %d not done after successful sync", e.ID)
+		}
+	}
+	if n := inD1(); n != 1 {
+		t.Errorf("D1 has %d chapters, want 1", n)
+	}
+}
@@ -0,0 +1,315 @@
+package service
+
+import (
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/flag"
+	"fairytale-creator/logger"
+	"fairytale-creator/modelapi"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	QuotaScopeWorkspace = "workspace"
+	QuotaScopeUser      = "user"
+	QuotaPeriodDay      = "day"
+	QuotaPeriodMonth    = "month"
+	QuotaKindStory      = "story" // 生成任务数
+	QuotaKindCost       = "cost"  // 估算费用（元）
+)
+
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	ErrInvalidPeriod = errors.New("invalid usage period")
+)
+
+// QuotaStatus 一项配额的上限和当前周期内的用量
+type QuotaStatus struct {
+	Scope  string  `json:"scope"`
+	Period string  `json:"period"`
+	Kind   string  `json:"kind"`
+	Limit  float64 `json:"limit"`
+	Used   float64 `json:"used"`
+}
+
+func (q QuotaStatus) exceeded() bool {
+	return q.Limit > 0 && q.Used >= q.Limit
+}
+
+// QuotaError 超出配额，可通过 errors.Is(err, ErrQuotaExceeded) 判断
+type QuotaError struct {
+	QuotaStatus
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s %s %s quota exceeded: %g/%g", e.Scope, e.Period, e.Kind, e.Used, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+func dayStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// quotaStatuses 统计工作区和用户已设置的各项配额在当前周期的用量，userID为0时只统计工作区配额。
+// 用户的故事配额按所有工作区合计
+func quotaStatuses(workspace *database.Workspace, userID uint) ([]QuotaStatus, error) {
+	now := time.Now()
+	checks := []struct {
+		status QuotaStatus
+		filter database.UsageFilter
+	}{
+		{QuotaStatus{Scope: QuotaScopeWorkspace, Period: QuotaPeriodDay, Kind: QuotaKindStory, Limit: float64(workspace.DailyStoryQuota)},
+			database.UsageFilter{WorkspaceID: workspace.ID, From: dayStart(now)}},
+		{QuotaStatus{Scope: QuotaScopeWorkspace, Period: QuotaPeriodMonth, Kind: QuotaKindStory, Limit: float64(workspace.MonthlyStoryQuota)},
+			database.UsageFilter{WorkspaceID: workspace.ID, From: monthStart(now)}},
+		{QuotaStatus{Scope: QuotaScopeWorkspace, Period: QuotaPeriodMonth, Kind: QuotaKindCost, Limit: workspace.MonthlyCostQuota},
+			database.UsageFilter{WorkspaceID: workspace.ID, From: monthStart(now)}},
+	}
+	if userID != 0 {
+		checks = append(checks, []struct {
+			status QuotaStatus
+			filter database.UsageFilter
+		}{
+			{QuotaStatus{Scope: QuotaScopeUser, Period: QuotaPeriodDay, Kind: QuotaKindStory, Limit: float64(flag.UserDailyStoryQuota)},
+				database.UsageFilter{UserID: userID, From: dayStart(now)}},
+			{QuotaStatus{Scope: QuotaScopeUser, Period: QuotaPeriodMonth, Kind: QuotaKindStory, Limit: float64(flag.UserMonthlyStoryQuota)},
+				database.UsageFilter{UserID: userID, From: monthStart(now)}},
+		}...)
+	}
+	usageDao := database.NewUsageDao()
+	list := make([]QuotaStatus, 0, len(checks))
+	for _, check := range checks {
+		if check.status.Limit <= 0 {
+			continue
+		}
+		status := check.status
+		if status.Kind == QuotaKindCost {
+			cost, err := usageDao.SumCost(check.filter)
+			if err != nil {
+				return nil, err
+			}
+			status.Used = cost
+		} else {
+			count, err := usageDao.CountJobs(check.filter)
+			if err != nil {
+				return nil, err
+			}
+			status.Used = float64(count)
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// jobMutex 串行化配额检查和任务记录，避免并发请求同时通过检查。多实例部署时配额可能被少量超出
+var jobMutex sync.Mutex
+
+// StartJob 在生成故事之前检查工作区和用户的配额，通过后记录一次生成任务，返回记录本任务用量的计量器
+func StartJob(workspace *database.Workspace, userID uint) (*UsageMeter, error) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+	statuses, err := quotaStatuses(workspace, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		if status.exceeded() {
+			return nil, &QuotaError{status}
+		}
+	}
+	job := &database.UsageRecord{
+		WorkspaceID: workspace.ID,
+		UserID:      userID,
+		Operation:   database.UsageOperationStory,
+		Unit:        database.UsageUnitJob,
+		Units:       1,
+	}
+	if err := database.NewUsageDao().AddUsage(job); err != nil {
+		return nil, err
+	}
+	return &UsageMeter{WorkspaceID: workspace.ID, UserID: userID, JobID: job.ID, CostLimit: workspace.MonthlyCostQuota}, nil
+}
+
+// CheckCostQuota 单独调用服务商（如合成一段语音）之前检查工作区的费用配额
+func CheckCostQuota(workspace *database.Workspace) error {
+	statuses, err := quotaStatuses(workspace, 0)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Kind == QuotaKindCost && status.exceeded() {
+			return &QuotaError{status}
+		}
+	}
+	return nil
+}
+
+// UsageMeter 记录服务商调用的用量并按 -price-* 参数估算费用。记录失败只打日志，
+// 调用已经发生，不应因此让生成失败。nil 计量器不记录
+type UsageMeter struct {
+	WorkspaceID uint
+	UserID      uint
+	JobID       uint    // 不属于生成任务时为0
+	CostLimit   float64 // 工作区每月的费用上限，为0时 CheckCost 不检查
+}
+
+func NewUsageMeter(workspaceID, userID uint) *UsageMeter {
+	return &UsageMeter{WorkspaceID: workspaceID, UserID: userID}
+}
+
+// RecordText 记录一次DeepSeek调用消耗的tokens
+func (m *UsageMeter) RecordText(usage modelapi.DeepSeekUsage) {
+	cost := float64(usage.PromptTokens)/1000*flag.PriceDeepSeekInput + float64(usage.CompletionTokens)/1000*flag.PriceDeepSeekOutput
+	m.record(database.UsageProviderDeepSeek, database.UsageOperationText, database.UsageUnitToken, int64(usage.TotalTokens), cost)
+}
+
+// RecordImages 记录一次出图调用（Seedream或备用的即梦）生成的图片数
+func (m *UsageMeter) RecordImages(provider string, n int) {
+	price := flag.PriceSeedreamImage
+	if provider == database.UsageProviderJimeng {
+		price = flag.PriceJimengImage
+	}
+	m.record(provider, database.UsageOperationImage, database.UsageUnitImage, int64(n), float64(n)*price)
+}
+
+// RecordVoice 记录一次语音合成（CosyVoice或备用的火山引擎）提交的字符数
+func (m *UsageMeter) RecordVoice(provider string, characters int) {
+	price := flag.PriceCosyVoice
+	if provider == database.UsageProviderVolcTTS {
+		price = flag.PriceVolcTTS
+	}
+	m.record(provider, database.UsageOperationVoice, database.UsageUnitCharacter, int64(characters), float64(characters)/10000*price)
+}
+
+// CheckCost 生成过程中重新检查工作区本月的估算费用，超出上限时返回 QuotaError。
+// StartJob 只在单个实例内串行化，多个实例或多个长任务可能同时通过检查，
+// 逐章检查把超出的部分限制在每个任务一个章节的用量之内
+func (m *UsageMeter) CheckCost() error {
+	if m == nil || m.CostLimit <= 0 {
+		return nil
+	}
+	cost, err := database.NewUsageDao().SumCost(database.UsageFilter{WorkspaceID: m.WorkspaceID, From: monthStart(time.Now())})
+	if err != nil {
+		return err
+	}
+	status := QuotaStatus{Scope: QuotaScopeWorkspace, Period: QuotaPeriodMonth, Kind: QuotaKindCost, Limit: m.CostLimit, Used: cost}
+	if status.exceeded() {
+		return &QuotaError{status}
+	}
+	return nil
+}
+
+// SetStory 故事入库后把本任务的用量关联到故事
+func (m *UsageMeter) SetStory(storyID uint) {
+	if m == nil || m.JobID == 0 {
+		return
+	}
+	if err := database.NewUsageDao().SetJobStory(m.JobID, storyID); err != nil {
+		logger.Error("关联用量和故事失败：", err.Error())
+	}
+}
+
+func (m *UsageMeter) record(provider, operation, unit string, units int64, cost float64) {
+	if m == nil || units <= 0 {
+		return
+	}
+	err := database.NewUsageDao().AddUsage(&database.UsageRecord{
+		WorkspaceID: m.WorkspaceID,
+		UserID:      m.UserID,
+		JobID:       m.JobID,
+		Provider:    provider,
+		Operation:   operation,
+		Unit:        unit,
+		Units:       units,
+		Cost:        cost,
+	})
+	if err != nil {
+		logger.Error("记录用量失败：", provider, err.Error())
+	}
+}
+
+// UsageReport 一段时间内的用量汇总，以及当前各项配额的使用情况
+type UsageReport struct {
+	From        time.Time               `json:"from"`
+	To          time.Time               `json:"to"`
+	WorkspaceID uint                    `json:"workspace_id"`
+	UserID      uint                    `json:"user_id,omitempty"` // 为0时为整个工作区
+	Stories     int64                   `json:"stories"`           // 生成任务数
+	Cost        float64                 `json:"cost"`              // 估算费用（元）
+	Items       []database.UsageSummary `json:"items"`
+	Quotas      []QuotaStatus           `json:"quotas"`
+}
+
+type UsageService struct {
+}
+
+func NewUsageService() UsageService {
+	return UsageService{}
+}
+
+// UsagePeriod 解析统计区间。给出from（和to，格式 2006-01-02，均含当天）时按日期统计，
+// 否则按period统计当天（day）或当月（month，默认）
+func UsagePeriod(period, from, to string) (time.Time, time.Time, error) {
+	now := time.Now()
+	if from != "" {
+		start, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrInvalidPeriod
+		}
+		end := dayStart(now)
+		if to != "" {
+			if end, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+				return time.Time{}, time.Time{}, ErrInvalidPeriod
+			}
+		}
+		end = end.AddDate(0, 0, 1)
+		if !end.After(start) {
+			return time.Time{}, time.Time{}, ErrInvalidPeriod
+		}
+		return start, end, nil
+	}
+	switch period {
+	case QuotaPeriodDay:
+		return dayStart(now), dayStart(now).AddDate(0, 0, 1), nil
+	case QuotaPeriodMonth, "":
+		return monthStart(now), monthStart(now).AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, ErrInvalidPeriod
+}
+
+// Report 汇总工作区在[from, to)内的用量，userID不为0时只统计该用户
+func (p *UsageService) Report(workspace *database.Workspace, userID uint, from, to time.Time) (*UsageReport, error) {
+	items, err := database.NewUsageDao().Summarize(database.UsageFilter{WorkspaceID: workspace.ID, UserID: userID, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := quotaStatuses(workspace, userID)
+	if err != nil {
+		return nil, err
+	}
+	report := &UsageReport{From: from, To: to, WorkspaceID: workspace.ID, UserID: userID, Items: items, Quotas: quotas}
+	for i, item := range items {
+		if item.Operation == database.UsageOperationStory {
+			report.Stories += item.Calls
+		}
+		report.Cost += item.Cost
+		items[i].Cost = roundCost(item.Cost)
+	}
+	report.Cost = roundCost(report.Cost)
+	return report, nil
+}
+
+// roundCost 费用保留6位小数，去掉浮点累加的误差
+func roundCost(cost float64) float64 {
+	return math.Round(cost*1e6) / 1e6
+}
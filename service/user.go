@@ -1,11 +1,37 @@
 package service
 
 import (
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-gonic/gin"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fairytale-creator/database"
 	"fairytale-creator/flag"
 	"fairytale-creator/logger"
 	"fairytale-creator/request"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	SessionUserID = "user_id"
+	SessionLogin  = "login"
+	ContextUser   = "user"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong    = errors.New("password must be at most 72 bytes")
+	ErrInvalidUsername    = errors.New("username must be 3-64 characters")
+	ErrUsernameTaken      = errors.New("username already exists")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidToken       = errors.New("token is invalid or expired")
 )
 
 type UserService struct {
@@ -15,19 +41,253 @@ func NewUserService() UserService {
 	return UserService{}
 }
 
-func (p *UserService) Login(c *gin.Context, req request.LoginReq) bool {
-	logger.Log(req.Username, req.Password)
-	if req.Username != flag.Username {
-		return false
+// Login 校验用户名和密码，成功后在会话中记录用户id
+func (p *UserService) Login(c *gin.Context, req request.LoginReq) (*database.User, error) {
+	// 连续失败次数过多时暂时锁定该用户名，不存在的用户名同样计数，避免借此判断用户是否存在
+	ip := c.ClientIP()
+	if err := checkLoginLocked(req.Username, ip); err != nil {
+		return nil, err
+	}
+	user, err := database.NewUserDao().GetUserByUsername(req.Username)
+	if errors.Is(err, database.RecordNotFoundError) {
+		// 用户不存在时同样计算一次哈希，避免通过响应时间判断用户名是否存在
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(req.Password))
+		recordLoginFailure(req.Username, ip)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+		logger.Log("login failed:", req.Username)
+		recordLoginFailure(req.Username, ip)
+		return nil, ErrInvalidCredentials
 	}
-	if req.Password != flag.Password {
-		return false
+	resetLoginFailures(req.Username, ip)
+	session := sessions.Default(c)
+	session.Set(SessionLogin, true)
+	session.Set(SessionUserID, user.ID)
+	if err := session.Save(); err != nil {
+		logger.Error(err.Error())
+		return nil, err
 	}
+	return user, nil
+}
+
+// Logout 清除会话
+func (p *UserService) Logout(c *gin.Context) error {
 	session := sessions.Default(c)
-	session.Set("login", true)
-	err := session.Save()
+	session.Clear()
+	return session.Save()
+}
+
+// CurrentUserID 返回会话中的用户id，未登录时返回0
+func CurrentUserID(c *gin.Context) uint {
+	id, _ := sessions.Default(c).Get(SessionUserID).(uint)
+	return id
+}
+
+// CurrentUser 返回 middleware.LoginAuth 放入上下文的当前用户，未经过认证时返回nil
+func CurrentUser(c *gin.Context) *database.User {
+	user, _ := c.Get(ContextUser)
+	u, _ := user.(*database.User)
+	return u
+}
+
+// CreateUser 直接创建用户，用于初始化管理员
+func (p *UserService) CreateUser(username, password, role string) (*database.User, error) {
+	user, err := newUser(username, password, role)
 	if err != nil {
-		return false
+		return nil, err
 	}
-	return true
+	if err := database.NewUserDao().AddUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
+
+// BootstrapLegacyAdmin 兼容旧的 -username/-password 启动参数：还没有管理员时用它们创建管理员，
+// 否则忽略。两种情况都提示改用 bootstrap-admin 子命令
+func (p *UserService) BootstrapLegacyAdmin(username, password string) error {
+	logger.Error("-username/-password 已废弃，请改用 bootstrap-admin 子命令创建管理员")
+	if username == "" || password == "" {
+		return nil
+	}
+	count, err := database.NewUserDao().CountUsersByRole(database.RoleAdmin)
+	if err != nil || count > 0 {
+		return err
+	}
+	user, err := p.CreateUser(username, password, database.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	logger.Log("已按 -username 创建管理员", user.Username)
+	return nil
+}
+
+// CreateInvite 创建邀请令牌，返回令牌原文，只在创建时可见。注册后以同一角色加入工作区
+func (p *UserService) CreateInvite(createdBy uint, role string, workspaceID uint) (string, error) {
+	if !validRole(role) {
+		return "", ErrInvalidRole
+	}
+	if workspaceID == 0 {
+		workspaceID = database.DefaultWorkspaceID
+	}
+	if _, err := database.NewWorkspaceDao().GetWorkspace(workspaceID); errors.Is(err, database.RecordNotFoundError) {
+		return "", ErrWorkspaceNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return p.createToken(&database.UserToken{Kind: database.UserTokenInvite, Role: role, WorkspaceID: workspaceID, CreatedBy: createdBy}, flag.InviteTTL)
+}
+
+// CreateResetToken 为用户创建重置密码令牌，由管理员转交给用户
+func (p *UserService) CreateResetToken(createdBy, userID uint) (string, error) {
+	if _, err := database.NewUserDao().GetUser(userID); err != nil {
+		return "", err
+	}
+	return p.createToken(&database.UserToken{Kind: database.UserTokenReset, UserID: userID, CreatedBy: createdBy}, flag.ResetTokenTTL)
+}
+
+func (p *UserService) createToken(t *database.UserToken, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	t.TokenHash = hashToken(token)
+	t.ExpiresAt = time.Now().Add(ttl)
+	if err := database.NewUserDao().AddToken(t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Register 使用邀请令牌注册，角色由邀请决定
+func (p *UserService) Register(req request.RegisterReq) (*database.User, error) {
+	user, err := newUser(req.Username, req.Password, database.RoleReader)
+	if err != nil {
+		return nil, err
+	}
+	userDao := database.NewUserDao()
+	if _, err := userDao.GetUserByUsername(req.Username); err == nil {
+		return nil, ErrUsernameTaken
+	} else if !errors.Is(err, database.RecordNotFoundError) {
+		return nil, err
+	}
+	err = userDao.UseToken(database.UserTokenInvite, hashToken(req.Token), func(tx *gorm.DB, t *database.UserToken) error {
+		user.Role = t.Role
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&database.WorkspaceMember{WorkspaceID: t.WorkspaceID, UserID: user.ID, Role: t.Role}).Error
+	})
+	if errors.Is(err, database.RecordNotFoundError) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ResetPassword 使用重置令牌设置新密码
+func (p *UserService) ResetPassword(req request.ResetPasswordReq) error {
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	err = database.NewUserDao().UseToken(database.UserTokenReset, hashToken(req.Token), func(tx *gorm.DB, t *database.UserToken) error {
+		if err := tx.Model(&database.User{}).Where("id = ?", t.UserID).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		// 重置密码后所有已登录的会话失效
+		return tx.Unscoped().Where("user_id = ?", t.UserID).Delete(&database.UserSession{}).Error
+	})
+	if errors.Is(err, database.RecordNotFoundError) {
+		return ErrInvalidToken
+	}
+	return err
+}
+
+// ChangePassword 校验当前用户的旧密码后修改密码，并撤销该用户的其他会话
+func (p *UserService) ChangePassword(c *gin.Context, req request.ChangePasswordReq) error {
+	userID := CurrentUser(c).ID
+	userDao := database.NewUserDao()
+	user, err := userDao.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.OldPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+	if err := userDao.UpdatePassword(userID, hash); err != nil {
+		return err
+	}
+	sessionService := NewSessionService()
+	_, err = sessionService.RevokeOtherSessions(c, userID)
+	return err
+}
+
+// ListUsers 列出所有用户
+func (p *UserService) ListUsers() ([]database.User, error) {
+	return database.NewUserDao().ListUsers()
+}
+
+// SetRole 修改用户角色
+func (p *UserService) SetRole(userID uint, role string) error {
+	if !validRole(role) {
+		return ErrInvalidRole
+	}
+	return database.NewUserDao().UpdateRole(userID, role)
+}
+
+func newUser(username, password, role string) (*database.User, error) {
+	if n := utf8.RuneCountInString(username); n < 3 || n > 64 {
+		return nil, ErrInvalidUsername
+	}
+	if !validRole(role) {
+		return nil, ErrInvalidRole
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &database.User{Username: username, PasswordHash: hash, Role: role}, nil
+}
+
+func validRole(role string) bool {
+	switch role {
+	case database.RoleAdmin, database.RoleEditor, database.RoleReader:
+		return true
+	}
+	return false
+}
+
+func hashPassword(password string) (string, error) {
+	if utf8.RuneCountInString(password) < 8 {
+		return "", ErrWeakPassword
+	}
+	// bcrypt 只使用前72字节，更长的密码拒绝而不是静默截断
+	if len(password) > 72 {
+		return "", ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// hashToken 令牌是高熵随机数，数据库中只保存SHA-256即可
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// dummyHash 用户不存在时用于比较的哈希，对应一个随机密码
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("fairytale-creator-dummy"), bcrypt.DefaultCost)
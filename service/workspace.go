@@ -0,0 +1,283 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fairytale-creator/database"
+	"fairytale-creator/request"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ContextWorkspace = "workspace"
+	// WorkspaceHeader 选择工作区的请求头，值为工作区id，未指定时使用用户加入的第一个工作区
+	WorkspaceHeader = "X-Workspace-ID"
+)
+
+var (
+	ErrNotMember         = errors.New("not a member of the workspace")
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	ErrWorkspaceMismatch = errors.New("credential is bound to another workspace")
+	ErrInvalidSlug       = errors.New("slug must be 2-64 lowercase letters, digits or dashes")
+	ErrInvalidWorkspace  = errors.New("workspace name must be 1-64 characters")
+	ErrSlugTaken         = errors.New("slug already exists")
+	ErrInvalidSettings   = errors.New("invalid workspace settings")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+// CurrentWorkspace 返回 middleware.Workspace 放入上下文的当前工作区，未经过该中间件时返回nil
+func CurrentWorkspace(c *gin.Context) *database.Workspace {
+	workspace, _ := c.Get(ContextWorkspace)
+	w, _ := workspace.(*database.Workspace)
+	return w
+}
+
+// ResolveWorkspace 确定本次请求的工作区并校验成员身份，需在 Authenticate 之后调用。
+// 成功后把工作区放入上下文，并把有效角色改为在该工作区中的角色（全局管理员视为每个工作区的管理员）
+func ResolveWorkspace(c *gin.Context) error {
+	user := CurrentUser(c)
+	bound := c.GetUint(ContextBoundWorkspace)
+	var requested uint
+	if header := c.GetHeader(WorkspaceHeader); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			return ErrNotMember
+		}
+		requested = uint(id)
+	}
+
+	workspaceDao := database.NewWorkspaceDao()
+	id := requested
+	switch {
+	case bound != 0:
+		if requested != 0 && requested != bound {
+			return ErrWorkspaceMismatch
+		}
+		id = bound
+	case requested == 0:
+		list, err := workspaceDao.ListWorkspacesByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		if len(list) > 0 {
+			id = list[0].ID
+		} else if user.Role == database.RoleAdmin {
+			id = database.DefaultWorkspaceID
+		} else {
+			return ErrNotMember
+		}
+	}
+
+	workspace, err := workspaceDao.GetWorkspace(id)
+	if errors.Is(err, database.RecordNotFoundError) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+	role := database.RoleAdmin
+	if user.Role != database.RoleAdmin {
+		member, err := workspaceDao.GetMember(id, user.ID)
+		if errors.Is(err, database.RecordNotFoundError) {
+			return ErrNotMember
+		}
+		if err != nil {
+			return err
+		}
+		role = member.Role
+	}
+	c.Set(ContextWorkspace, workspace)
+	c.Set(ContextRole, lowerRole(c.GetString(ContextCredentialRole), role))
+	return nil
+}
+
+// WorkspaceDetail 工作区详情，主题、画风和选角解析为JSON，服务商密钥只返回是否已配置
+type WorkspaceDetail struct {
+	database.Workspace
+	Themes       []string        `json:"themes"`
+	Styles       []string        `json:"styles"`
+	VoiceCasting json.RawMessage `json:"voice_casting,omitempty"`
+	Credentials  map[string]bool `json:"credentials"`
+	Role         string          `json:"role"` // 当前用户在工作区中的角色
+}
+
+type WorkspaceService struct {
+}
+
+func NewWorkspaceService() WorkspaceService {
+	return WorkspaceService{}
+}
+
+// ListWorkspaces 列出当前用户可访问的工作区，全局管理员可访问所有工作区
+func (p *WorkspaceService) ListWorkspaces(c *gin.Context) ([]database.Workspace, error) {
+	user := CurrentUser(c)
+	if user.Role == database.RoleAdmin {
+		return database.NewWorkspaceDao().ListWorkspaces()
+	}
+	return database.NewWorkspaceDao().ListWorkspacesByUser(user.ID)
+}
+
+// CreateWorkspace 创建工作区，当前用户成为工作区管理员
+func (p *WorkspaceService) CreateWorkspace(c *gin.Context, req request.CreateWorkspaceReq) (*database.Workspace, error) {
+	if n := utf8.RuneCountInString(req.Name); n < 1 || n > 64 {
+		return nil, ErrInvalidWorkspace
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		return nil, ErrInvalidSlug
+	}
+	workspaceDao := database.NewWorkspaceDao()
+	if _, err := workspaceDao.GetWorkspaceBySlug(req.Slug); err == nil {
+		return nil, ErrSlugTaken
+	} else if !errors.Is(err, database.RecordNotFoundError) {
+		return nil, err
+	}
+	workspace := &database.Workspace{Name: req.Name, Slug: req.Slug}
+	if err := workspaceDao.AddWorkspace(workspace, CurrentUser(c).ID); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// GetWorkspace 返回当前工作区的详情
+func (p *WorkspaceService) GetWorkspace(c *gin.Context) *WorkspaceDetail {
+	w := CurrentWorkspace(c)
+	detail := &WorkspaceDetail{
+		Workspace: *w,
+		Themes:    decodeList(w.Themes),
+		Styles:    decodeList(w.Styles),
+		Credentials: map[string]bool{
+			"deepseek":        w.DeepSeekAPIKey != "",
+			"doubao_seedream": w.DoubaoSeedreamAPIKey != "",
+			"cosy_voice":      w.CosyVoiceAPIKey != "",
+			"jimeng":          w.JimengAccessKeyID != "" && w.JimengSecretAccessKey != "",
+		},
+		Role: CurrentRole(c),
+	}
+	if w.VoiceCasting != "" {
+		detail.VoiceCasting = json.RawMessage(w.VoiceCasting)
+	}
+	return detail
+}
+
+// UpdateWorkspace 修改当前工作区的配置，只修改请求中给出的字段，字符串置空表示恢复使用全局配置
+func (p *WorkspaceService) UpdateWorkspace(c *gin.Context, req request.UpdateWorkspaceReq) error {
+	fields := map[string]interface{}{}
+	if req.Name != nil {
+		if n := utf8.RuneCountInString(*req.Name); n < 1 || n > 64 {
+			return ErrInvalidWorkspace
+		}
+		fields["name"] = *req.Name
+	}
+	if req.Themes != nil {
+		fields["themes"] = encodeList(*req.Themes)
+	}
+	if req.Styles != nil {
+		fields["styles"] = encodeList(*req.Styles)
+	}
+	if req.VoiceCasting != nil {
+		casting := strings.TrimSpace(string(*req.VoiceCasting))
+		if casting == "null" {
+			casting = ""
+		}
+		if casting != "" {
+			if _, err := ParseVoiceCasting([]byte(casting)); err != nil {
+				return ErrInvalidSettings
+			}
+		}
+		fields["voice_casting"] = casting
+	}
+	for column, value := range map[string]*int{
+		"daily_story_quota":   req.DailyStoryQuota,
+		"monthly_story_quota": req.MonthlyStoryQuota,
+	} {
+		if value != nil {
+			if *value < 0 {
+				return ErrInvalidSettings
+			}
+			fields[column] = *value
+		}
+	}
+	if req.MonthlyCostQuota != nil {
+		if *req.MonthlyCostQuota < 0 {
+			return ErrInvalidSettings
+		}
+		fields["monthly_cost_quota"] = *req.MonthlyCostQuota
+	}
+	for column, value := range map[string]*string{
+		"deepseek_api_key":         req.DeepSeekAPIKey,
+		"doubao_seedream_api_key":  req.DoubaoSeedreamAPIKey,
+		"cosy_voice_api_key":       req.CosyVoiceAPIKey,
+		"jimeng_access_key_id":     req.JimengAccessKeyID,
+		"jimeng_secret_access_key": req.JimengSecretAccessKey,
+	} {
+		if value != nil {
+			encrypted, err := encryptSecret(strings.TrimSpace(*value))
+			if err != nil {
+				return err
+			}
+			fields[column] = encrypted
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return database.NewWorkspaceDao().UpdateWorkspace(CurrentWorkspace(c).ID, fields)
+}
+
+// ListMembers 列出当前工作区的成员
+func (p *WorkspaceService) ListMembers(c *gin.Context) ([]database.WorkspaceMember, error) {
+	return database.NewWorkspaceDao().ListMembers(CurrentWorkspace(c).ID)
+}
+
+// SetMember 把用户加入当前工作区或修改其角色
+func (p *WorkspaceService) SetMember(c *gin.Context, req request.SetMemberReq) error {
+	if !validRole(req.Role) {
+		return ErrInvalidRole
+	}
+	if _, err := database.NewUserDao().GetUser(req.UserID); err != nil {
+		return err
+	}
+	return database.NewWorkspaceDao().SetMember(&database.WorkspaceMember{
+		WorkspaceID: CurrentWorkspace(c).ID,
+		UserID:      req.UserID,
+		Role:        req.Role,
+	})
+}
+
+// RemoveMember 把用户移出当前工作区，用户在该工作区的API Key随之失效
+func (p *WorkspaceService) RemoveMember(c *gin.Context, userID uint) error {
+	return database.NewWorkspaceDao().RemoveMember(CurrentWorkspace(c).ID, userID)
+}
+
+// decodeList 解析JSON字符串数组，为空或格式错误时返回nil
+func decodeList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil
+	}
+	return list
+}
+
+// encodeList 去掉空白项后编码为JSON，列表为空时返回空字符串，表示使用内置配置
+func encodeList(list []string) string {
+	var items []string
+	for _, item := range list {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(items)
+	return string(data)
+}
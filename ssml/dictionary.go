@@ -0,0 +1,110 @@
+package ssml
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 文本中视为停顿的标记及对应时长（毫秒）
+var pauseMarks = []struct {
+	mark string
+	ms   int
+}{
+	{"……", 600},
+	{"...", 600},
+	{"——", 400},
+}
+
+// Dictionary 读音词典，键为词语，值为拼音（如 "xing2 zhe3"）
+type Dictionary map[string]string
+
+// LoadDictionary 从JSON文件加载读音词典，路径为空时返回空词典
+func LoadDictionary(filename string) (Dictionary, error) {
+	dict := Dictionary{}
+	if filename == "" {
+		return dict, nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("读取读音词典失败: %w", err)
+	}
+	if err := json.Unmarshal(data, &dict); err != nil {
+		return nil, fmt.Errorf("解析读音词典失败: %w", err)
+	}
+	return dict, nil
+}
+
+// Merge 合并词典，other 中的读音覆盖当前词典
+func (d Dictionary) Merge(other Dictionary) Dictionary {
+	merged := Dictionary{}
+	for word, ph := range d {
+		merged[word] = ph
+	}
+	for word, ph := range other {
+		merged[word] = ph
+	}
+	return merged
+}
+
+// Apply 将纯文本写入构建器：词典中的词语按最长匹配标注读音，停顿标记转换为 <break>
+func (d Dictionary) Apply(b *Builder, text string) *Builder {
+	words := make([]string, 0, len(d))
+	for word := range d {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		return len(words[i]) > len(words[j])
+	})
+
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			b.Text(plain.String())
+			plain.Reset()
+		}
+	}
+	for i := 0; i < len(text); {
+		matched := false
+		for _, p := range pauseMarks {
+			if strings.HasPrefix(text[i:], p.mark) {
+				flush()
+				b.Break(p.ms)
+				i += len(p.mark)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, word := range words {
+			if strings.HasPrefix(text[i:], word) {
+				flush()
+				b.Phoneme("py", d[word], word)
+				i += len(word)
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		// 按字节前进，多字节字符会在后续循环中被完整写入
+		plain.WriteByte(text[i])
+		i++
+	}
+	flush()
+	return b
+}
+
+// FromText 将纯文本转换为SSML文档
+func FromText(text string, dict Dictionary, rate, pitch float64) (string, error) {
+	b := NewBuilder().Speak(rate, pitch, 0)
+	dict.Apply(b, text)
+	return b.Build()
+}
@@ -0,0 +1,332 @@
+package ssml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fairytale-creator/util"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builder SSML文档构建器，所有文本都会被转义，最终由 Build 包裹 <speak> 根节点
+type Builder struct {
+	attrs []xml.Attr
+	body  strings.Builder
+}
+
+// NewBuilder 创建新的SSML构建器
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Speak 设置根节点的整体语速、音调和音量，参数为零值时不设置
+func (b *Builder) Speak(rate, pitch float64, volume int) *Builder {
+	if rate > 0 {
+		b.attrs = append(b.attrs, xml.Attr{Name: xml.Name{Local: "rate"}, Value: formatFloat(rate)})
+	}
+	if pitch > 0 {
+		b.attrs = append(b.attrs, xml.Attr{Name: xml.Name{Local: "pitch"}, Value: formatFloat(pitch)})
+	}
+	if volume > 0 {
+		b.attrs = append(b.attrs, xml.Attr{Name: xml.Name{Local: "volume"}, Value: strconv.Itoa(volume)})
+	}
+	return b
+}
+
+// Text 追加普通文本
+func (b *Builder) Text(text string) *Builder {
+	xml.EscapeText(&b.body, []byte(text))
+	return b
+}
+
+// Break 追加停顿，单位毫秒
+func (b *Builder) Break(ms int) *Builder {
+	fmt.Fprintf(&b.body, `<break time="%dms"/>`, ms)
+	return b
+}
+
+// Emphasis 追加重读文本，level 可选 strong / moderate / reduced
+func (b *Builder) Emphasis(level, text string) *Builder {
+	b.open("emphasis", "level", level)
+	b.Text(text)
+	b.close("emphasis")
+	return b
+}
+
+// Prosody 追加局部调整语速、音调的文本
+func (b *Builder) Prosody(rate, pitch float64, text string) *Builder {
+	var attrs []string
+	if rate > 0 {
+		attrs = append(attrs, "rate", formatFloat(rate))
+	}
+	if pitch > 0 {
+		attrs = append(attrs, "pitch", formatFloat(pitch))
+	}
+	b.open("prosody", attrs...)
+	b.Text(text)
+	b.close("prosody")
+	return b
+}
+
+// Phoneme 追加带读音标注的文本，alphabet 为 py（拼音，如 "xing2"）或 cmu
+func (b *Builder) Phoneme(alphabet, ph, text string) *Builder {
+	b.open("phoneme", "alphabet", alphabet, "ph", ph)
+	b.Text(text)
+	b.close("phoneme")
+	return b
+}
+
+// SayAs 追加指定读法的文本，如数字、日期、电话号码
+func (b *Builder) SayAs(interpretAs, text string) *Builder {
+	b.open("say-as", "interpret-as", interpretAs)
+	b.Text(text)
+	b.close("say-as")
+	return b
+}
+
+// Sub 追加替换读音的文本，朗读时读 alias
+func (b *Builder) Sub(alias, text string) *Builder {
+	b.open("sub", "alias", alias)
+	b.Text(text)
+	b.close("sub")
+	return b
+}
+
+// Build 生成完整的SSML文档并校验
+func (b *Builder) Build() (string, error) {
+	var doc strings.Builder
+	doc.WriteString("<speak")
+	for _, attr := range b.attrs {
+		doc.WriteString(" " + attr.Name.Local + `="`)
+		xml.EscapeText(&doc, []byte(attr.Value))
+		doc.WriteString(`"`)
+	}
+	doc.WriteString(">")
+	doc.WriteString(b.body.String())
+	doc.WriteString("</speak>")
+	result := doc.String()
+	if err := Validate(result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (b *Builder) open(name string, attrs ...string) {
+	b.body.WriteString("<" + name)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		b.body.WriteString(" " + attrs[i] + `="`)
+		xml.EscapeText(&b.body, []byte(attrs[i+1]))
+		b.body.WriteString(`"`)
+	}
+	b.body.WriteString(">")
+}
+
+func (b *Builder) close(name string) {
+	b.body.WriteString("</" + name + ">")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var (
+	ErrInvalidSSML = errors.New("SSML格式错误")
+	// ErrSSMLTooLong 单个标签超过切分长度，无法在不破坏标签的前提下切分
+	ErrSSMLTooLong = errors.New("SSML标签过长，无法切分")
+
+	breakTimePattern = regexp.MustCompile(`^\d+(ms|s)$`)
+	// 各标签允许的属性
+	allowedElements = map[string]map[string]bool{
+		"speak":    {"rate": true, "pitch": true, "volume": true, "voice": true},
+		"break":    {"time": true},
+		"emphasis": {"level": true},
+		"prosody":  {"rate": true, "pitch": true, "volume": true},
+		"phoneme":  {"alphabet": true, "ph": true},
+		"say-as":   {"interpret-as": true},
+		"sub":      {"alias": true},
+	}
+)
+
+// Validate 校验SSML文档：根节点必须是 speak，只允许受支持的标签和属性，
+// 且不允许嵌套 speak
+func Validate(doc string) error {
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	depth := 0
+	seenRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSSML, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if depth == 0 {
+				if seenRoot || name != "speak" {
+					return fmt.Errorf("%w: 根节点必须是唯一的<speak>", ErrInvalidSSML)
+				}
+				seenRoot = true
+			} else if name == "speak" {
+				return fmt.Errorf("%w: 不允许嵌套<speak>", ErrInvalidSSML)
+			}
+			attrs, ok := allowedElements[name]
+			if !ok {
+				return fmt.Errorf("%w: 不支持的标签<%s>", ErrInvalidSSML, name)
+			}
+			for _, attr := range t.Attr {
+				if !attrs[attr.Name.Local] {
+					return fmt.Errorf("%w: <%s>不支持属性%s", ErrInvalidSSML, name, attr.Name.Local)
+				}
+				if name == "break" && attr.Name.Local == "time" && !breakTimePattern.MatchString(attr.Value) {
+					return fmt.Errorf("%w: 非法的停顿时长%s", ErrInvalidSSML, attr.Value)
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && strings.TrimSpace(string(t)) != "" {
+				return fmt.Errorf("%w: <speak>外存在文本", ErrInvalidSSML)
+			}
+		}
+	}
+	if !seenRoot {
+		return fmt.Errorf("%w: 缺少<speak>根节点", ErrInvalidSSML)
+	}
+	return nil
+}
+
+// PlainText 返回SSML文档中的文本内容，去掉标签并还原转义字符，用于按字数计费
+func PlainText(doc string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return text.String(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidSSML, err)
+		}
+		if data, ok := token.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+}
+
+// splitUnit 切分SSML时不可再分的片段：<speak>直接包含的一句文本或一个完整的标签
+type splitUnit struct {
+	markup      string
+	runes       int
+	sentenceEnd bool // 片段以句末结束，优先在其后切分
+	element     bool
+}
+
+// Split 将SSML文档切分为多个不超过 maxRunes 个字符（不含<speak>本身）的完整文档，
+// 每片都用与原文档属性相同的<speak>包裹。只在<speak>直接包含的文本处切分，优先在句末切分，
+// 标签整体保留在同一片中；单个标签超过 maxRunes 时返回 ErrSSMLTooLong
+func Split(doc string, maxRunes int) ([]string, error) {
+	if err := Validate(doc); err != nil {
+		return nil, err
+	}
+	decoder := xml.NewDecoder(strings.NewReader(doc))
+	var root []xml.Attr
+	var units []splitUnit
+	element := NewBuilder()
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSSML, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				root = t.Copy().Attr
+				continue
+			}
+			var attrs []string
+			for _, attr := range t.Attr {
+				attrs = append(attrs, attr.Name.Local, attr.Value)
+			}
+			element.open(t.Name.Local, attrs...)
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				continue
+			}
+			element.close(t.Name.Local)
+			if depth == 1 {
+				markup := element.body.String()
+				units = append(units, splitUnit{markup: markup, runes: utf8.RuneCountInString(markup), element: true})
+				element.body.Reset()
+			}
+		case xml.CharData:
+			if depth > 1 {
+				element.Text(string(t))
+				continue
+			}
+			for _, sentence := range util.SplitSentences(string(t)) {
+				for _, piece := range util.ChunkText(sentence, maxRunes) {
+					var markup strings.Builder
+					xml.EscapeText(&markup, []byte(piece))
+					units = append(units, splitUnit{markup: markup.String(), runes: utf8.RuneCountInString(markup.String()), sentenceEnd: util.EndsSentence(piece)})
+				}
+			}
+		}
+	}
+
+	var chunks []string
+	var current []splitUnit
+	size := 0
+	emit := func(n int) error {
+		b := &Builder{attrs: root}
+		for _, u := range current[:n] {
+			b.body.WriteString(u.markup)
+			size -= u.runes
+		}
+		chunk, err := b.Build()
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, chunk)
+		current = append([]splitUnit(nil), current[n:]...)
+		return nil
+	}
+	for _, u := range units {
+		if maxRunes > 0 && u.element && u.runes > maxRunes {
+			return nil, fmt.Errorf("%w: %d字超过上限%d", ErrSSMLTooLong, u.runes, maxRunes)
+		}
+		for maxRunes > 0 && len(current) > 0 && size+u.runes > maxRunes {
+			cut := len(current)
+			for i := len(current) - 1; i >= 0; i-- {
+				if current[i].sentenceEnd {
+					cut = i + 1
+					break
+				}
+			}
+			if err := emit(cut); err != nil {
+				return nil, err
+			}
+		}
+		current = append(current, u)
+		size += u.runes
+	}
+	if len(current) > 0 {
+		if err := emit(len(current)); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,217 @@
+package ssml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder) *Builder
+		want  string
+	}{
+		{
+			name:  "空文档",
+			build: func(b *Builder) *Builder { return b },
+			want:  "<speak></speak>",
+		},
+		{
+			name:  "根节点属性",
+			build: func(b *Builder) *Builder { return b.Speak(1.2, 0.9, 60).Text("你好") },
+			want:  `<speak rate="1.2" pitch="0.9" volume="60">你好</speak>`,
+		},
+		{
+			name:  "零值属性不输出",
+			build: func(b *Builder) *Builder { return b.Speak(0, 1, 0).Text("你好") },
+			want:  `<speak pitch="1">你好</speak>`,
+		},
+		{
+			name:  "转义文本",
+			build: func(b *Builder) *Builder { return b.Text(`a<b&"c"`) },
+			want:  "<speak>a&lt;b&amp;&#34;c&#34;</speak>",
+		},
+		{
+			name: "各类标签",
+			build: func(b *Builder) *Builder {
+				return b.Text("从前").Break(500).Emphasis("strong", "有").Prosody(0.8, 0, "一座山").
+					Phoneme("py", "xing2", "行").SayAs("cardinal", "3").Sub("世界卫生组织", "WHO")
+			},
+			want: `<speak>从前<break time="500ms"/><emphasis level="strong">有</emphasis><prosody rate="0.8">一座山</prosody>` +
+				`<phoneme alphabet="py" ph="xing2">行</phoneme><say-as interpret-as="cardinal">3</say-as><sub alias="世界卫生组织">WHO</sub></speak>`,
+		},
+		{
+			name:  "转义属性",
+			build: func(b *Builder) *Builder { return b.Sub(`"A"&B`, "x") },
+			want:  `<speak><sub alias="&#34;A&#34;&amp;B">x</sub></speak>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build(NewBuilder()).Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Build() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{"合法文档", `<speak rate="1.1">你好<break time="1s"/><phoneme alphabet="py" ph="hao3">好</phoneme></speak>`, false},
+		{"根节点外的空白", " <speak>你好</speak>\n", false},
+		{"缺少根节点", "你好", true},
+		{"根节点不是speak", "<prosody>你好</prosody>", true},
+		{"多个根节点", "<speak>a</speak><speak>b</speak>", true},
+		{"嵌套speak", "<speak><speak>a</speak></speak>", true},
+		{"不支持的标签", "<speak><audio>a</audio></speak>", true},
+		{"不支持的属性", `<speak><break strength="weak"/></speak>`, true},
+		{"非法的停顿时长", `<speak><break time="1m"/></speak>`, true},
+		{"根节点外的文本", "<speak>a</speak>b", true},
+		{"未闭合的标签", "<speak><emphasis>a</speak>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSSML) {
+				t.Errorf("Validate() error = %v, want ErrInvalidSSML", err)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		want    string
+		wantErr bool
+	}{
+		{"纯文本", "<speak>你好</speak>", "你好", false},
+		{"去掉标签和属性", `<speak rate="1.1">你<break time="1s"/><phoneme alphabet="py" ph="hao3">好</phoneme></speak>`, "你好", false},
+		{"还原转义", "<speak>a&lt;b</speak>", "a<b", false},
+		{"非法文档", "<speak>a", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PlainText(tt.doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlainText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PlainText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	dict := Dictionary{"银行": "yin2 hang2", "行": "xing2", "": "ignored"}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"无标注", "从前有座山", "<speak>从前有座山</speak>"},
+		{"最长匹配", "去银行", `<speak>去<phoneme alphabet="py" ph="yin2 hang2">银行</phoneme></speak>`},
+		{"多次匹配", "行行", `<speak><phoneme alphabet="py" ph="xing2">行</phoneme><phoneme alphabet="py" ph="xing2">行</phoneme></speak>`},
+		{"停顿标记", "等等……好——走...", `<speak>等等<break time="600ms"/>好<break time="400ms"/>走<break time="600ms"/></speak>`},
+		{"转义文本", "a<b", "<speak>a&lt;b</speak>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dict.Apply(NewBuilder(), tt.text).Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		maxRunes int
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "不超过上限不切分",
+			doc:      "<speak>从前有座山。</speak>",
+			maxRunes: 100,
+			want:     []string{"<speak>从前有座山。</speak>"},
+		},
+		{
+			name:     "不限长度",
+			doc:      "<speak>第一句。第二句。</speak>",
+			maxRunes: 0,
+			want:     []string{"<speak>第一句。第二句。</speak>"},
+		},
+		{
+			name:     "在句末切分并保留根节点属性",
+			doc:      `<speak rate="1.2">第一句。第二句。第三句。</speak>`,
+			maxRunes: 8,
+			want:     []string{`<speak rate="1.2">第一句。第二句。</speak>`, `<speak rate="1.2">第三句。</speak>`},
+		},
+		{
+			name:     "标签整体保留",
+			doc:      `<speak>开头。<emphasis level="strong">重点</emphasis>结尾。</speak>`,
+			maxRunes: 41,
+			want:     []string{`<speak>开头。</speak>`, `<speak><emphasis level="strong">重点</emphasis>结尾。</speak>`},
+		},
+		{
+			name:     "标签超过上限",
+			doc:      `<speak><emphasis level="strong">重点</emphasis></speak>`,
+			maxRunes: 10,
+			wantErr:  ErrSSMLTooLong,
+		},
+		{
+			name:     "非法文档",
+			doc:      "<speak><audio/></speak>",
+			maxRunes: 10,
+			wantErr:  ErrInvalidSSML,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Split(tt.doc, tt.maxRunes)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Split() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Split() error = %v", err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Split() = %q, want %q", got, tt.want)
+			}
+			for _, chunk := range got {
+				if err := Validate(chunk); err != nil {
+					t.Errorf("Split() chunk %s invalid: %v", chunk, err)
+				}
+				body := strings.TrimSuffix(chunk[strings.Index(chunk, ">")+1:], "</speak>")
+				if tt.maxRunes > 0 && utf8.RuneCountInString(body) > tt.maxRunes {
+					t.Errorf("Split() chunk %s longer than %d", chunk, tt.maxRunes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 去除首尾静音：先去掉开头的静音，翻转后再去掉一次（即原音频的结尾），最后翻转回来
+const trimSilenceFilter = "silenceremove=start_periods=1:start_duration=0.05:start_threshold=-50dB," +
+	"areverse," +
+	"silenceremove=start_periods=1:start_duration=0.05:start_threshold=-50dB," +
+	"areverse"
+
+// 各输出格式对应的FFmpeg编码参数
+var audioEncoders = map[string][]string{
+	"mp3":  {"-c:a", "libmp3lame", "-q:a", "4"},
+	"opus": {"-c:a", "libopus", "-b:a", "32k", "-vbr", "on", "-application", "voip"},
+	"aac":  {"-c:a", "aac", "-b:a", "64k", "-movflags", "+faststart"},
+}
+
+// 各输出格式的文件扩展名
+var audioExtensions = map[string]string{
+	"mp3":  ".mp3",
+	"opus": ".opus",
+	"aac":  ".m4a",
+}
+
+// AudioExtension 返回输出格式对应的文件扩展名
+func AudioExtension(format string) string {
+	return audioExtensions[format]
+}
+
+// NormalizeAudio 使用FFmpeg去除首尾静音并将响度归一化到 targetLUFS，输出为MP3。
+// 输入输出可以是同一个文件，结果先写入临时文件再替换
+func NormalizeAudio(inputFile, outputFile string, targetLUFS float64, sampleRate int) error {
+	filter := fmt.Sprintf("%s,loudnorm=I=%s:TP=-1.5:LRA=11", trimSilenceFilter, strconv.FormatFloat(targetLUFS, 'f', -1, 64))
+	args := []string{"-hide_banner", "-y", "-i", inputFile, "-af", filter}
+	// loudnorm 内部会升采样到192kHz，需要显式指定输出采样率
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	args = append(args, audioEncoders["mp3"]...)
+	return runFFmpegToFile(args, outputFile)
+}
+
+// EncodeAudio 将音频转码为指定格式：mp3 / opus / aac
+func EncodeAudio(inputFile, outputFile, format string) error {
+	encoder, ok := audioEncoders[format]
+	if !ok {
+		return fmt.Errorf("不支持的音频格式: %s", format)
+	}
+	args := append([]string{"-hide_banner", "-y", "-i", inputFile, "-vn"}, encoder...)
+	return runFFmpegToFile(args, outputFile)
+}
+
+// ProbeAudioDuration 使用FFprobe获取音频时长
+func ProbeAudioDuration(file string) (time.Duration, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe执行失败: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("无法解析音频时长%q: %w", strings.TrimSpace(string(output)), err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// runFFmpegToFile 执行FFmpeg，输出写入同目录临时文件，成功后重命名为目标文件
+func runFFmpegToFile(args []string, outputFile string) error {
+	ext := filepath.Ext(outputFile)
+	tmpFile := strings.TrimSuffix(outputFile, ext) + ".tmp" + ext
+	cmd := exec.Command("ffmpeg", append(args, tmpFile)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("ffmpeg执行失败: %w: %s", err, lastLines(string(output), 5))
+	}
+	if err := os.Rename(tmpFile, outputFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("重命名输出文件失败: %w", err)
+	}
+	return nil
+}
+
+// lastLines 返回文本的最后 n 行，用于截取FFmpeg的错误信息
+func lastLines(text string, n int) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,128 @@
+package util
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ImageDerivative 图片衍生版本的规格
+type ImageDerivative struct {
+	Name   string // 版本名，同时用于生成对象键
+	Width  int    // 目标宽度，高度按比例缩放；原图更窄时不放大
+	Format string // jpeg / webp
+	Ext    string // 衍生版本的扩展名
+}
+
+// ImageDerivatives 每张章节图片生成的衍生版本
+var ImageDerivatives = []ImageDerivative{
+	{Name: "thumb", Width: 360, Format: "jpeg", Ext: ".jpg"},
+	{Name: "medium", Width: 720, Format: "jpeg", Ext: ".jpg"},
+	{Name: "webp", Width: 720, Format: "webp", Ext: ".webp"},
+}
+
+// ImageDerivativeKey 根据原图的文件名或对象键生成衍生版本的键，与原图位于同一目录。
+// 扩展名前总是带有 _版本名，原图本身是WebP时也不会与原图相同，
+// 如 "a/b.png" 的 thumb 版本为 "a/b_thumb.jpg"，"a/b.webp" 的 webp 版本为 "a/b_webp.webp"
+func ImageDerivativeKey(key string, d ImageDerivative) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + d.Name + d.Ext
+}
+
+// LegacyWebPKey 早期版本WebP衍生图的键，直接把原图的扩展名换成 .webp。
+// 原图本身是WebP时该键就是原图，返回空字符串
+func LegacyWebPKey(key string) string {
+	ext := path.Ext(key)
+	if ext == ".webp" {
+		return ""
+	}
+	return strings.TrimSuffix(key, ext) + ".webp"
+}
+
+// ImageDerivativeKeys 返回原图所有衍生版本的键，以版本名为键
+func ImageDerivativeKeys(key string) map[string]string {
+	keys := make(map[string]string, len(ImageDerivatives))
+	for _, d := range ImageDerivatives {
+		keys[d.Name] = ImageDerivativeKey(key, d)
+	}
+	return keys
+}
+
+// GenerateImageDerivatives 为本地图片生成所有衍生版本，保存在原图旁边，返回版本名到文件路径的映射
+func GenerateImageDerivatives(imagePath string) (map[string]string, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	src, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	outputs := make(map[string]string, len(ImageDerivatives))
+	for _, d := range ImageDerivatives {
+		output := ImageDerivativeKey(imagePath, d)
+		if d.Format == "webp" {
+			err = encodeWebP(imagePath, output, d.Width)
+		} else {
+			err = writeDerivative(resizeToWidth(src, d.Width), output, d.Format)
+		}
+		if err != nil {
+			return nil, err
+		}
+		outputs[d.Name] = output
+	}
+	return outputs, nil
+}
+
+// encodeWebP 使用FFmpeg（libwebp）有损压缩生成WebP，纯Go的编码器只支持无损，体积往往比JPEG还大
+func encodeWebP(imagePath, output string, width int) error {
+	args := []string{"-hide_banner", "-y", "-i", imagePath,
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-1", width),
+		"-c:v", "libwebp", "-quality", "75", "-frames:v", "1"}
+	return runFFmpegToFile(args, output)
+}
+
+// resizeToWidth 按宽度等比缩放，原图不超过目标宽度时原样返回
+func resizeToWidth(src image.Image, width int) image.Image {
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return src
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
+
+func writeDerivative(img image.Image, output, format string) error {
+	tmp := output + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	err = encodeImage(file, img, format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to encode %s: %w", output, err)
+	}
+	return os.Rename(tmp, output)
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+	default:
+		return fmt.Errorf("unsupported image format: %s", format)
+	}
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImageDerivativeKey(t *testing.T) {
+	thumb := ImageDerivative{Name: "thumb", Width: 360, Format: "jpeg", Ext: ".jpg"}
+	webp := ImageDerivative{Name: "webp", Width: 720, Format: "webp", Ext: ".webp"}
+	tests := []struct {
+		name string
+		key  string
+		d    ImageDerivative
+		want string
+	}{
+		{"对象键", "a/b.png", thumb, "a/b_thumb.jpg"},
+		{"本地路径", "images/2024/abc.jpg", webp, "images/2024/abc_webp.webp"},
+		{"原图是WebP", "a/b.webp", webp, "a/b_webp.webp"},
+		{"没有扩展名", "a/b", thumb, "a/b_thumb.jpg"},
+		{"目录名带点", "v1.2/b.png", thumb, "v1.2/b_thumb.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ImageDerivativeKey(tt.key, tt.d)
+			if got != tt.want {
+				t.Errorf("ImageDerivativeKey(%q, %s) = %q, want %q", tt.key, tt.d.Name, got, tt.want)
+			}
+			if got == tt.key {
+				t.Errorf("ImageDerivativeKey(%q, %s) collides with the original", tt.key, tt.d.Name)
+			}
+		})
+	}
+}
+
+func TestLegacyWebPKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"a/b.png", "a/b.webp"},
+		{"a/b.jpg", "a/b.webp"},
+		{"a/b.webp", ""},
+	}
+	for _, tt := range tests {
+		if got := LegacyWebPKey(tt.key); got != tt.want {
+			t.Errorf("LegacyWebPKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestImageDerivativeKeys(t *testing.T) {
+	want := map[string]string{"thumb": "a/b_thumb.jpg", "medium": "a/b_medium.jpg", "webp": "a/b_webp.webp"}
+	if got := ImageDerivativeKeys("a/b.png"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ImageDerivativeKeys() = %v, want %v", got, want)
+	}
+}
@@ -1,46 +1,132 @@
 package util
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
 )
 
-func SaveImage(url, path string) error {
-	// 发送HTTP GET请求获取图片
-	response, err := http.Get(url)
+// imageDownloadTimeout 下载单张图片的最长时间，避免CDN卡住时生成流程无限等待
+const imageDownloadTimeout = 2 * time.Minute
+
+var imageHTTPClient = &http.Client{Timeout: imageDownloadTimeout}
+
+// ImageInfo 归档后的图片信息
+type ImageInfo struct {
+	Path     string // 本地存储路径，文件名为内容哈希
+	Hash     string // 内容的SHA-256
+	MimeType string // 根据文件内容判断的MIME类型
+	Width    int
+	Height   int
+	Size     int64
+}
+
+// 支持归档的图片类型及扩展名
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ArchiveImage 立即下载图片到 dir 目录，以内容哈希命名，ctx结束或超过 imageDownloadTimeout 时中止。
+// MIME类型根据文件内容判断而不信任响应头，并解析图片宽高；相同内容的图片只保存一份
+func ArchiveImage(ctx context.Context, url, dir string) (*ImageInfo, error) {
+	body, err := openImage(ctx, url)
 	if err != nil {
-		return fmt.Errorf("failed to make HTTP request: %w", err)
+		return nil, err
 	}
-	defer response.Body.Close()
+	defer body.Close()
 
-	// 检查HTTP响应状态码
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP request failed with status: %s", response.Status)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "download-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	// 确保目录存在
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
 	}
 
-	// 创建目标文件
-	file, err := os.Create(path)
+	info, err := inspectImage(tmp)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	info.Hash = hex.EncodeToString(hasher.Sum(nil))
+	info.Size = size
+	info.Path = filepath.Join(dir, info.Hash+imageExtensions[info.MimeType])
 
-	// 将响应体内容复制到文件
-	_, err = io.Copy(file, response.Body)
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), info.Path); err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	return info, nil
+}
+
+// openImage 下载图片，data URL（如即梦以base64返回的图片）直接解码
+func openImage(ctx context.Context, url string) (io.ReadCloser, error) {
+	if strings.HasPrefix(url, "data:") {
+		_, data, ok := strings.Cut(url, ";base64,")
+		if !ok {
+			return nil, fmt.Errorf("unsupported data URL")
+		}
+		return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))), nil
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		// 如果复制失败，删除可能已创建的不完整文件
-		os.Remove(path)
-		return fmt.Errorf("failed to save image: %w", err)
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
 	}
+	response, err := imageHTTPClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make HTTP request: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("HTTP request failed with status: %s", response.Status)
+	}
+	return response.Body, nil
+}
 
-	return nil
+// inspectImage 根据文件头判断MIME类型并解析图片宽高
+func inspectImage(file *os.File) (*ImageInfo, error) {
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	mimeType := http.DetectContentType(head[:n])
+	if _, ok := imageExtensions[mimeType]; !ok {
+		return nil, fmt.Errorf("unsupported image type: %s", mimeType)
+	}
+	config, _, err := image.DecodeConfig(io.NewSectionReader(file, 0, 1<<62))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return &ImageInfo{
+		MimeType: mimeType,
+		Width:    config.Width,
+		Height:   config.Height,
+	}, nil
 }
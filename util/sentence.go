@@ -0,0 +1,123 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 句末标点，遇到时结束一句
+var sentenceEnds = map[rune]bool{
+	'。': true, '！': true, '？': true, '；': true, '…': true,
+	'.': true, '!': true, '?': true, ';': true, '\n': true,
+}
+
+// 紧跟在句末标点后、应归入同一句的闭合符号
+var sentenceClosers = map[rune]bool{
+	'”': true, '’': true, '」': true, '』': true, '）': true, '》': true,
+	'"': true, '\'': true, ')': true, ']': true,
+}
+
+// 句子过长时退而求其次的断点
+var clauseBreaks = map[rune]bool{
+	'，': true, '、': true, '：': true, ',': true, ':': true,
+}
+
+// SplitSentences 按中英文句末标点将文本切分为句子，闭合引号和括号保留在句尾。
+// 英文句点后必须跟空白或文本结束才视为句末，避免切断 "3.14"、"Mr.Fox" 之类的写法
+func SplitSentences(text string) []string {
+	runes := []rune(text)
+	var sentences []string
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if !sentenceEnds[r] {
+			continue
+		}
+		if (r == '.' || r == '!' || r == '?' || r == ';') && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !sentenceClosers[runes[i+1]] {
+			continue
+		}
+		end := i + 1
+		// 连续的句末标点（如 "！？"、"……"）和闭合符号并入当前句
+		for end < len(runes) && (sentenceEnds[runes[end]] || sentenceClosers[runes[end]]) {
+			end++
+		}
+		if s := strings.TrimSpace(string(runes[start:end])); s != "" {
+			sentences = append(sentences, string(runes[start:end]))
+		}
+		start = end
+		i = end - 1
+	}
+	if start < len(runes) && strings.TrimSpace(string(runes[start:])) != "" {
+		sentences = append(sentences, string(runes[start:]))
+	}
+	return sentences
+}
+
+// ChunkText 将文本按句子边界打包为不超过 maxRunes 个字符的片段。
+// 单句超长时在逗号等次级标点或空白处切分，仍然超长才按长度硬切
+func ChunkText(text string, maxRunes int) []string {
+	if maxRunes <= 0 || len([]rune(text)) <= maxRunes {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		return []string{text}
+	}
+	var chunks []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, string(current))
+			current = nil
+		}
+	}
+	for _, sentence := range SplitSentences(text) {
+		for _, piece := range splitLong([]rune(sentence), maxRunes) {
+			if len(current)+len(piece) > maxRunes {
+				flush()
+			}
+			current = append(current, piece...)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitLong 将超长句子在次级标点或空白处切分，必要时按长度硬切
+func splitLong(sentence []rune, maxRunes int) [][]rune {
+	if len(sentence) <= maxRunes {
+		return [][]rune{sentence}
+	}
+	var pieces [][]rune
+	for len(sentence) > maxRunes {
+		cut := -1
+		for i := maxRunes - 1; i > 0; i-- {
+			if clauseBreaks[sentence[i]] {
+				cut = i + 1
+				break
+			}
+		}
+		// 没有次级标点时在空白处切分，避免切断英文单词
+		for i := maxRunes - 1; cut <= 0 && i > 0; i-- {
+			if unicode.IsSpace(sentence[i]) {
+				cut = i + 1
+			}
+		}
+		if cut <= 0 {
+			cut = maxRunes
+		}
+		pieces = append(pieces, sentence[:cut])
+		sentence = sentence[cut:]
+	}
+	if len(sentence) > 0 {
+		pieces = append(pieces, sentence)
+	}
+	return pieces
+}
+
+// EndsSentence 文本（忽略末尾换行以外的空白和闭合符号）是否以句末标点结束
+func EndsSentence(text string) bool {
+	runes := []rune(strings.TrimRightFunc(text, func(r rune) bool {
+		return (unicode.IsSpace(r) && r != '\n') || sentenceClosers[r]
+	}))
+	return len(runes) > 0 && sentenceEnds[runes[len(runes)-1]]
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"空文本", "", nil},
+		{"只有空白", " \n ", nil},
+		{"中文句末标点", "从前有座山。山里有座庙！庙里有什么？", []string{"从前有座山。", "山里有座庙！", "庙里有什么？"}},
+		{"没有句末标点", "从前有座山", []string{"从前有座山"}},
+		{"剩余文本单独成句", "第一句。第二句", []string{"第一句。", "第二句"}},
+		{"连续句末标点", "真的吗！？好吧……走", []string{"真的吗！？", "好吧……", "走"}},
+		{"闭合引号归入句尾", "他说：“走吧。”然后走了。", []string{"他说：“走吧。”", "然后走了。"}},
+		{"英文句子", "Hello. How are you? Fine!", []string{"Hello.", " How are you?", " Fine!"}},
+		{"英文句点后不是空白", "Pi is 3.14 and Mr.Fox is here.", []string{"Pi is 3.14 and Mr.Fox is here."}},
+		{"换行结束一句", "第一行\n第二行", []string{"第一行\n", "第二行"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitSentences(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxRunes int
+		want     []string
+	}{
+		{"空文本", "", 10, nil},
+		{"不限长度", "第一句。第二句。", 0, []string{"第一句。第二句。"}},
+		{"不超过上限", "第一句。第二句。", 8, []string{"第一句。第二句。"}},
+		{"按句子打包", "第一句。第二句。第三句。", 8, []string{"第一句。第二句。", "第三句。"}},
+		{"长句在逗号处切分", "一二三，四五六七八九。", 5, []string{"一二三，", "四五六七八", "九。"}},
+		{"长句在空白处切分", "hello world again", 8, []string{"hello ", "world ", "again"}},
+		{"没有断点时硬切", "一二三四五六七", 3, []string{"一二三", "四五六", "七"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ChunkText(tt.text, tt.maxRunes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChunkText(%q, %d) = %q, want %q", tt.text, tt.maxRunes, got, tt.want)
+			}
+			for _, chunk := range got {
+				if tt.maxRunes > 0 && utf8.RuneCountInString(chunk) > tt.maxRunes {
+					t.Errorf("ChunkText() chunk %q longer than %d", chunk, tt.maxRunes)
+				}
+			}
+		})
+	}
+}
+
+func TestEndsSentence(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"", false},
+		{"从前有座山。", true},
+		{"他说：“走吧。”", true},
+		{"从前有座山。 ", true},
+		{"从前有座山，", false},
+		{"第一行\n", true},
+	}
+	for _, tt := range tests {
+		if got := EndsSentence(tt.text); got != tt.want {
+			t.Errorf("EndsSentence(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -6,39 +6,47 @@ import (
 	"fmt"
 )
 
-// GenerateDailyTheme 根据日期生成唯一主题
+// GenerateDailyTheme 根据日期从内置主题中生成唯一主题
 func GenerateDailyTheme(date string) string {
+	return GenerateDailyThemeFrom(date, defaultThemes)
+}
+
+// GenerateDailyThemeFrom 根据日期从给定主题中生成唯一主题，themes为空时使用内置主题
+func GenerateDailyThemeFrom(date string, themes []string) string {
+	if len(themes) == 0 {
+		themes = defaultThemes
+	}
 	hasher := md5.New()
 	hasher.Write([]byte(date))
 	hash := hex.EncodeToString(hasher.Sum(nil))
 
-	themes := []string{
-		"勇气与友谊", "魔法森林", "海底冒险", "星空之旅", "时间之谜",
-		"失落王国", "智慧试炼", "梦幻花园", "月光城堡", "彩虹桥",
-		"云雾山脉", "水晶洞穴", "火焰山", "冰晶宫殿", "风之谷",
-		"光影迷宫", "回声岛屿", "梦境漩涡", "星辰大海", "幻影沙漠",
-		"会说话的动物", "蝴蝶王国", "兔子洞的秘密", "狐狸的智慧", "狼群守护者",
-		"鸟儿传信使", "蚂蚁大工程", "蜜蜂的宝藏", "猫咪夜行记", "狗狗忠诚记",
-		"松鼠储备战", "熊族冬眠谜", "鱼儿逆流旅", "蜘蛛织网术", "萤火虫之光",
-		"春日复苏", "夏日狂欢", "秋日收获", "冬日奇迹", "雨季的秘密",
-		"雪花的形状", "阳光的礼物", "月亮的阴影", "星星的指引", "风的低语",
-		"云的形状", "河流的旅程", "山脉的回声", "森林的呼吸", "海洋的韵律",
-		"魔法药水", "预言水晶", "隐身斗篷", "飞行扫帚", "会说话的镜子",
-		"时间沙漏", "愿望井", "魔法种子", "咒语书", "巫师学徒",
-		"精灵的帮助", "巨人的花园", "龙蛋孵化", "独角兽的角", "美人鱼的歌声",
-		"第一次冒险", "克服恐惧", "学会分享", "诚实的力量", "耐心的回报",
-		"勇敢的决定", "友谊的考验", "责任的重量", "梦想的追求", "知识的宝库",
-		"创造的力量", "好奇心的引导", "坚持不懈", "团队合作", "自我发现",
-		"东方龙传说", "北欧神话", "非洲草原", "亚马逊雨林", "北极光之旅",
-		"沙漠商队", "岛屿部落", "山地民族", "河流文明", "海洋探险",
-		"古老地图", "失落文明", "神秘符号", "传统节日", "民间艺术",
-		"玩具复活夜", "书本里的世界", "衣柜后的通道", "后院探险", "厨房魔法",
-	}
-
 	index := int(hash[0]) % len(themes)
 	return fmt.Sprintf("%s-%s", themes[index], date)
 }
 
+var defaultThemes = []string{
+	"勇气与友谊", "魔法森林", "海底冒险", "星空之旅", "时间之谜",
+	"失落王国", "智慧试炼", "梦幻花园", "月光城堡", "彩虹桥",
+	"云雾山脉", "水晶洞穴", "火焰山", "冰晶宫殿", "风之谷",
+	"光影迷宫", "回声岛屿", "梦境漩涡", "星辰大海", "幻影沙漠",
+	"会说话的动物", "蝴蝶王国", "兔子洞的秘密", "狐狸的智慧", "狼群守护者",
+	"鸟儿传信使", "蚂蚁大工程", "蜜蜂的宝藏", "猫咪夜行记", "狗狗忠诚记",
+	"松鼠储备战", "熊族冬眠谜", "鱼儿逆流旅", "蜘蛛织网术", "萤火虫之光",
+	"春日复苏", "夏日狂欢", "秋日收获", "冬日奇迹", "雨季的秘密",
+	"雪花的形状", "阳光的礼物", "月亮的阴影", "星星的指引", "风的低语",
+	"云的形状", "河流的旅程", "山脉的回声", "森林的呼吸", "海洋的韵律",
+	"魔法药水", "预言水晶", "隐身斗篷", "飞行扫帚", "会说话的镜子",
+	"时间沙漏", "愿望井", "魔法种子", "咒语书", "巫师学徒",
+	"精灵的帮助", "巨人的花园", "龙蛋孵化", "独角兽的角", "美人鱼的歌声",
+	"第一次冒险", "克服恐惧", "学会分享", "诚实的力量", "耐心的回报",
+	"勇敢的决定", "友谊的考验", "责任的重量", "梦想的追求", "知识的宝库",
+	"创造的力量", "好奇心的引导", "坚持不懈", "团队合作", "自我发现",
+	"东方龙传说", "北欧神话", "非洲草原", "亚马逊雨林", "北极光之旅",
+	"沙漠商队", "岛屿部落", "山地民族", "河流文明", "海洋探险",
+	"古老地图", "失落文明", "神秘符号", "传统节日", "民间艺术",
+	"玩具复活夜", "书本里的世界", "衣柜后的通道", "后院探险", "厨房魔法",
+}
+
 func GetStyleArray() []string {
 	return []string{
 		"美式喜剧卡通风格", "日系 Q 版动漫插画", "新中式动漫插画",